@@ -38,6 +38,11 @@ type Config struct {
 	RedisPassword string
 	RedisDB       int
 
+	// K线事件来源: "stream" (Redis Streams 消费组, 默认) 或 "pubsub" (旧的 Pub/Sub 订阅)
+	KlineTransport string
+	// Stream 消费组名, 每个实例一个且重启后保持不变; 为空时使用 api_hub:{hostname}
+	KlineStreamGroup string
+
 	// PostgreSQL/TimescaleDB配置（用于K线数据）
 	PGHost     string
 	PGPort     string
@@ -92,6 +97,10 @@ func LoadConfig() *Config {
 		RedisPassword: getEnv("REDIS_PASSWORD", ""),
 		RedisDB:       redisDB,
 
+		// K线事件来源
+		KlineTransport:   getEnv("KLINE_TRANSPORT", "stream"),
+		KlineStreamGroup: getEnv("KLINE_STREAM_GROUP", ""),
+
 		// PostgreSQL/TimescaleDB配置
 		PGHost:     getEnv("PG_HOST", "localhost"),
 		PGPort:     getEnv("PG_PORT", "5432"),
@@ -43,12 +43,14 @@ type Kline struct {
 // @Tags Kline
 // @Param symbol query string true "交易品种" default(XAUUSD)
 // @Param timeframe query string true "时间周期" default(M1)
+// @Param price_type query string false "价格序列 (bid/ask/mid)" default(bid)
 // @Param limit query int false "数量限制" default(300)
 // @Success 200 {object} map[string]interface{}
 // @Router /api/mt4/kline [get]
 func (kc *KlineController) GetHistoricalKline(c *gin.Context) {
 	symbol := c.DefaultQuery("symbol", "XAUUSD")
 	timeframe := c.DefaultQuery("timeframe", "M1")
+	priceType := c.DefaultQuery("price_type", "bid")
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "300"))
 
 	if limit > 1000 {
@@ -66,7 +68,7 @@ func (kc *KlineController) GetHistoricalKline(c *gin.Context) {
 		return
 	}
 
-	// 查询历史K线，使用PostgreSQL语法
+	// 查询历史K线，使用PostgreSQL语法 (klines 表由 db 服务的 migrations 创建)
 	query := `
 		SELECT 
 			EXTRACT(EPOCH FROM start_time)::bigint * 1000 as time,
@@ -75,14 +77,14 @@ func (kc *KlineController) GetHistoricalKline(c *gin.Context) {
 			low,
 			close,
 			volume
-		FROM candles
-		WHERE symbol = $1 AND timeframe = $2
+		FROM klines
+		WHERE symbol = $1 AND timeframe = $2 AND price_type = $3
 		ORDER BY start_time DESC
-		LIMIT $3
+		LIMIT $4
 	`
 
 	var klines []Kline
-	err := kc.pgDB.Select(&klines, query, symbol, timeframe, limit)
+	err := kc.pgDB.Select(&klines, query, symbol, timeframe, priceType, limit)
 	if err != nil {
 		log.Printf("Failed to query klines: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
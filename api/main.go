@@ -97,8 +97,13 @@ func main() {
 	// 10. 创建WebSocket Hub
 	wsHub := ws.NewHub(500, database.GetRedis(), pgDB) // 500根K线缓冲, 传入PostgreSQL连接
 	go wsHub.Run()                                     // 启动Hub
-	pubSubManager := ws.NewPubSubManager(database.GetRedis(), wsHub)
-	go pubSubManager.Run() // 启动Redis订阅
+	if cfg.KlineTransport == "pubsub" {
+		pubSubManager := ws.NewPubSubManager(database.GetRedis(), wsHub)
+		go pubSubManager.Run() // 启动Redis订阅
+	} else {
+		streamConsumer := ws.NewStreamConsumer(database.GetRedis(), wsHub, cfg.KlineStreamGroup)
+		go streamConsumer.Run() // 以消费组方式读取K线 Stream
+	}
 	log.Println("WebSocket Hub initialized")
 
 	// 11. 创建EA运行时服务
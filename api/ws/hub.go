@@ -91,7 +91,7 @@ func (h *Hub) handleKlineMessage(msg *redis.Message) {
 
 	// 尝试解析candle service的格式 (带status字段)
 	var candleServiceMsg struct {
-		Status string `json:"status"` // "UPDATE" or "CLOSE"
+		Status string `json:"status"` // "UPDATE", "CLOSE" or "AMEND"
 		Candle struct {
 			Symbol    string    `json:"symbol"`
 			Timeframe string    `json:"timeframe"`
@@ -101,10 +101,16 @@ func (h *Hub) handleKlineMessage(msg *redis.Message) {
 			Low       float64   `json:"low"`
 			Close     float64   `json:"close"`
 			Volume    int64     `json:"volume"`
+			PriceType string    `json:"price_type"` // "bid"(默认) | "ask" | "mid"
 		} `json:"candle"`
 	}
 
 	if err := json.Unmarshal([]byte(msg.Payload), &candleServiceMsg); err == nil && candleServiceMsg.Status != "" {
+		// 前端和指标只使用 bid 序列, ask/mid 序列 (kline:SYMBOL:TF:ask) 跳过
+		if pt := candleServiceMsg.Candle.PriceType; pt != "" && pt != "bid" {
+			return
+		}
+
 		// 成功解析candle service格式
 		log.Printf("✅ Parsed candle service format: %s %s (status=%s)", 
 			candleServiceMsg.Candle.Symbol, candleServiceMsg.Candle.Timeframe, candleServiceMsg.Status)
@@ -128,8 +134,12 @@ func (h *Hub) handleKlineMessage(msg *redis.Message) {
 		// 提取symbol和timeframe
 		key := klineMsg.Symbol + ":" + klineMsg.Timeframe
 
-		// 添加到缓冲区
-		h.indicatorManager.AddCandle(key, klineMsg.Candle, klineMsg.IsNew)
+		// 添加到缓冲区; AMEND 修正已闭合的K线
+		if candleServiceMsg.Status == "AMEND" {
+			h.indicatorManager.AmendCandle(key, klineMsg.Candle)
+		} else {
+			h.indicatorManager.AddCandle(key, klineMsg.Candle, klineMsg.IsNew)
+		}
 
 		// ✅ 简化方案：获取完整的K线列表
 		allCandles := h.indicatorManager.GetCandles(key)
@@ -59,6 +59,20 @@ func (cb *CandleBuffer) Update(candle CandleData) {
 	}
 }
 
+// Amend 替换开始时间相同的K线 (迟到Tick修正), 缓冲区中没有该K线时返回 false
+func (cb *CandleBuffer) Amend(candle CandleData) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	for i := len(cb.candles) - 1; i >= 0; i-- {
+		if cb.candles[i].Time.Equal(candle.Time) {
+			cb.candles[i] = candle
+			return true
+		}
+	}
+	return false
+}
+
 // GetAll 获取所有K线 (从旧到新)
 func (cb *CandleBuffer) GetAll() []CandleData {
 	cb.mu.RLock()
@@ -212,7 +226,7 @@ func (m *MultiPeriodManager) loadFromDB(key string, buffer *CandleBuffer) {
 			close,
 			volume
 		FROM klines
-		WHERE symbol = $1 AND timeframe = $2
+		WHERE symbol = $1 AND timeframe = $2 AND price_type = 'bid'
 		ORDER BY start_time DESC
 		LIMIT $3
 	`
@@ -335,6 +349,15 @@ func (m *MultiPeriodManager) AddCandle(key string, candle CandleData, isNew bool
 	}
 }
 
+// AmendCandle 修正缓冲区中已闭合的K线
+func (m *MultiPeriodManager) AmendCandle(key string, candle CandleData) {
+	buffer := m.GetOrCreateBuffer(key)
+	if buffer.Amend(candle) {
+		log.Printf("✏️  Amended candle in %s: time=%s, close=%.2f",
+			key, candle.Time.Format("15:04:05"), candle.Close)
+	}
+}
+
 // GetCandles 获取K线
 func (m *MultiPeriodManager) GetCandles(key string) []CandleData {
 	m.mu.RLock()
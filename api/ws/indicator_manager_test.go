@@ -105,6 +105,36 @@ func TestCandleBuffer_Update_PopulatedBuffer(t *testing.T) {
 	}
 }
 
+func TestCandleBuffer_Amend_ReplacesByTime(t *testing.T) {
+	buffer := NewCandleBuffer(500)
+	baseTime := time.Now()
+
+	buffer.Add(createValidCandle(baseTime, 0))
+	buffer.Add(createValidCandle(baseTime, 1))
+
+	amended := createValidCandle(baseTime, 0)
+	amended.High += 5
+	amended.Close += 5
+	if !buffer.Amend(amended) {
+		t.Fatal("Expected amend to find the candle")
+	}
+
+	candles := buffer.GetAll()
+	if len(candles) != 2 {
+		t.Errorf("Expected size 2, got %d", len(candles))
+	}
+	if candles[0].Close != amended.Close {
+		t.Errorf("Expected amended close %.2f, got %.2f", amended.Close, candles[0].Close)
+	}
+	if candles[1].Close != createValidCandle(baseTime, 1).Close {
+		t.Errorf("Expected last candle unchanged")
+	}
+
+	if buffer.Amend(createValidCandle(baseTime, 5)) {
+		t.Error("Expected amend of unknown candle to return false")
+	}
+}
+
 func TestCandleBuffer_GetAll_ReturnsCorrectData(t *testing.T) {
 	buffer := NewCandleBuffer(500)
 	baseTime := time.Now()
@@ -0,0 +1,142 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis Streams 约定 (与 candle 服务一致)
+const (
+	streamIndexKey      = "kline_streams" // Set: 所有品种的 Stream key
+	streamRefreshPeriod = 10 * time.Second
+	streamReadBlock     = 2 * time.Second
+)
+
+// StreamConsumer 以消费组方式读取K线 Stream 并交给 Hub
+//
+// 每个 API 实例使用独立的消费组 (KLINE_STREAM_GROUP, 默认 api_hub:{hostname}), 因此每个实例都能收到全部事件;
+// 组名需在重启后保持不变, 已有的消费组从上次读到的位置继续.
+// 新建的消费组从本实例启动时刻开始, 不回放更早的历史 (历史K线由数据库加载),
+// 也不会漏掉启动后才出现的品种 Stream 在加入消费组之前写入的事件.
+type StreamConsumer struct {
+	rdb      *redis.Client
+	hub      *Hub
+	ctx      context.Context
+	group    string
+	consumer string
+	startID  string // 新建消费组的起始ID: 启动时刻
+	joined   map[string]bool
+}
+
+// NewStreamConsumer 创建 Stream 消费者; group 为空时按主机名生成
+func NewStreamConsumer(rdb *redis.Client, hub *Hub, group string) *StreamConsumer {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "api"
+	}
+	if group == "" {
+		group = "api_hub:" + host
+	}
+	return &StreamConsumer{
+		rdb:      rdb,
+		hub:      hub,
+		ctx:      context.Background(),
+		group:    group,
+		consumer: host,
+		startID:  fmt.Sprintf("%d-0", time.Now().UnixMilli()),
+		joined:   make(map[string]bool),
+	}
+}
+
+// Run 启动消费
+func (sc *StreamConsumer) Run() {
+	log.Printf("✅ Consuming kline streams as %s/%s", sc.group, sc.consumer)
+
+	var streams []string
+	var lastRefresh time.Time
+	readPending := true // 重启后先处理已领取但未 ACK 的消息
+
+	for {
+		if time.Since(lastRefresh) > streamRefreshPeriod {
+			streams = sc.refreshStreams()
+			lastRefresh = time.Now()
+		}
+		if len(streams) == 0 {
+			time.Sleep(time.Second)
+			continue
+		}
+
+		id := ">"
+		if readPending {
+			id = "0"
+		}
+		args := make([]string, 0, 2*len(streams))
+		args = append(args, streams...)
+		for range streams {
+			args = append(args, id)
+		}
+		res, err := sc.rdb.XReadGroup(sc.ctx, &redis.XReadGroupArgs{
+			Group:    sc.group,
+			Consumer: sc.consumer,
+			Streams:  args,
+			Count:    200,
+			Block:    streamReadBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			log.Printf("❌ XREADGROUP failed: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		got := 0
+		for _, stream := range res {
+			for _, msg := range stream.Messages {
+				got++
+				sc.hub.RedisMessages <- &redis.Message{
+					Channel: fmt.Sprint(msg.Values["channel"]),
+					Payload: fmt.Sprint(msg.Values["event"]),
+				}
+				if err := sc.rdb.XAck(sc.ctx, stream.Stream, sc.group, msg.ID).Err(); err != nil {
+					log.Printf("❌ XACK %s %s failed: %v", stream.Stream, msg.ID, err)
+				}
+			}
+		}
+		if readPending && got == 0 {
+			readPending = false
+		}
+	}
+}
+
+// refreshStreams 为新出现的品种 Stream 创建消费组
+func (sc *StreamConsumer) refreshStreams() []string {
+	keys, err := sc.rdb.SMembers(sc.ctx, streamIndexKey).Result()
+	if err != nil {
+		log.Printf("❌ Failed to list kline streams: %v", err)
+	}
+	for _, key := range keys {
+		if sc.joined[key] {
+			continue
+		}
+		err := sc.rdb.XGroupCreateMkStream(sc.ctx, key, sc.group, sc.startID).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			log.Printf("❌ Failed to create consumer group on %s: %v", key, err)
+			continue
+		}
+		sc.joined[key] = true
+	}
+
+	streams := make([]string, 0, len(sc.joined))
+	for key := range sc.joined {
+		streams = append(streams, key)
+	}
+	return streams
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+const defaultAdminAddr = "127.0.0.1:9108" // 管理接口监听地址 (配置节 admin.addr 或环境变量 ADMIN_ADDR), 默认只监听本机
+
+// SymbolStatus 品种的运行状态
+type SymbolStatus struct {
+	Symbol      string             `json:"symbol"`
+	Timeframes  []string           `json:"timeframes"`
+	QueueDepth  int                `json:"queue_depth"`
+	QueueSize   int                `json:"queue_size"`
+	LastTickAge float64            `json:"last_tick_age_seconds"` // 距最近一次入队的秒数 (尚无Tick时从创建算起)
+	Candles     map[string]*Candle `json:"candles,omitempty"`     // 未闭合的K线, 周期键 -> K线 (无则为 null)
+}
+
+// SymbolStatuses 返回所有活跃品种的状态, withCandles 时包含未闭合的K线
+func (m *AggregatorManager) SymbolStatuses(withCandles bool) []SymbolStatus {
+	m.lock.RLock()
+	queues := make([]*tickQueue, 0, len(m.queues))
+	for _, q := range m.queues {
+		queues = append(queues, q)
+	}
+	m.lock.RUnlock()
+
+	result := make([]SymbolStatus, 0, len(queues))
+	for _, q := range queues {
+		result = append(result, q.status(withCandles))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Symbol < result[j].Symbol })
+	return result
+}
+
+// SymbolStatus 返回单个活跃品种的状态 (包含未闭合的K线)
+func (m *AggregatorManager) SymbolStatus(symbol string) (SymbolStatus, bool) {
+	m.lock.RLock()
+	q, ok := m.queues[symbol]
+	m.lock.RUnlock()
+	if !ok {
+		return SymbolStatus{}, false
+	}
+	return q.status(true), true
+}
+
+func (q *tickQueue) status(withCandles bool) SymbolStatus {
+	candles := q.agg.Snapshot()
+	st := SymbolStatus{
+		Symbol:      q.symbol,
+		QueueDepth:  q.len(),
+		QueueSize:   q.size,
+		LastTickAge: time.Since(q.lastTickAt()).Seconds(),
+	}
+	for key := range candles {
+		st.Timeframes = append(st.Timeframes, key)
+	}
+	sort.Strings(st.Timeframes)
+	if withCandles {
+		st.Candles = candles
+	}
+	return st
+}
+
+// AddSymbol 启用品种并立即创建聚合器; 之前被移除的品种重新开始接收Tick
+func (m *AggregatorManager) AddSymbol(symbol string) error {
+	m.lock.Lock()
+	delete(m.disabled, symbol)
+	m.lock.Unlock()
+	_, err := m.symbolQueue(symbol)
+	return err
+}
+
+// RemoveSymbol 停止聚合品种: 丢弃排队的Tick和未闭合的K线 (不发布 CLOSE),
+// 之后收到的该品种Tick被丢弃, 直到再次 AddSymbol. 返回品种此前是否存在
+func (m *AggregatorManager) RemoveSymbol(symbol string) bool {
+	m.lock.Lock()
+	if m.disabled == nil {
+		m.disabled = make(map[string]bool)
+	}
+	m.disabled[symbol] = true
+	q, live := m.queues[symbol]
+	_, dormant := m.dormant[symbol]
+	delete(m.queues, symbol)
+	delete(m.Aggregators, symbol)
+	delete(m.filters, symbol)
+	delete(m.dormant, symbol)
+	m.lock.Unlock()
+
+	if live {
+		q.stop()
+		q.agg.Discard()
+	}
+	if dormant && m.State != nil {
+		fields, err := m.State.LoadSymbol(ctx, symbol)
+		if err != nil {
+			log.Printf("ERROR: Failed to discard candle state of %s: %v", symbol, err)
+		}
+		for key := range fields {
+			m.State.Delete(symbol, key)
+		}
+	}
+	if live || dormant {
+		log.Printf("🗑️  Removed symbol %s", symbol)
+	}
+	return live || dormant
+}
+
+// AddTimeframe 为品种增加周期; 同时写入品种配置, 品种被回收后重建时仍然生效
+func (m *AggregatorManager) AddTimeframe(symbol, name string) error {
+	tf, err := ParseTimeframe(name)
+	if err != nil {
+		return err
+	}
+	m.updateTimeframes(symbol, func(names []string) []string {
+		if slices.Contains(names, tf.Name) {
+			return names
+		}
+		return append(names, tf.Name)
+	})
+	if sa := m.liveAggregator(symbol); sa != nil && sa.AddTimeframe(tf) {
+		log.Printf("➕ Added timeframe %s to %s", tf.Name, symbol)
+	}
+	return nil
+}
+
+// RemoveTimeframe 移除品种的周期, 该周期未闭合的K线被丢弃
+func (m *AggregatorManager) RemoveTimeframe(symbol, name string) error {
+	tf, err := ParseTimeframe(name)
+	if err != nil {
+		return err
+	}
+	var updateErr error
+	m.updateTimeframes(symbol, func(names []string) []string {
+		kept := slices.DeleteFunc(slices.Clone(names), func(n string) bool { return n == tf.Name })
+		if len(kept) == 0 {
+			updateErr = fmt.Errorf("cannot remove the last timeframe of %s", symbol)
+			return names
+		}
+		return kept
+	})
+	if updateErr != nil {
+		return updateErr
+	}
+	if sa := m.liveAggregator(symbol); sa != nil && sa.RemoveTimeframe(tf.Name) {
+		log.Printf("➖ Removed timeframe %s from %s", tf.Name, symbol)
+	}
+	return nil
+}
+
+// updateTimeframes 以品种当前生效的周期列表为基础修改, 写回品种配置
+func (m *AggregatorManager) updateTimeframes(symbol string, update func([]string) []string) {
+	m.configLock.Lock()
+	defer m.configLock.Unlock()
+	if m.symbolConfigs == nil {
+		m.symbolConfigs = SymbolConfigs{}
+	}
+	names := update(slices.Clone(m.symbolConfigs.For(symbol).Timeframes))
+	override := m.symbolConfigs[symbol]
+	override.Timeframes = names
+	m.symbolConfigs[symbol] = override
+}
+
+// ForceClose 立即关闭品种指定周期 (为空时为所有周期) 的未闭合K线
+func (m *AggregatorManager) ForceClose(symbol, timeframe string) ([]Candle, error) {
+	if timeframe != "" {
+		tf, err := ParseTimeframe(timeframe)
+		if err != nil {
+			return nil, err
+		}
+		timeframe = tf.Name
+	}
+	sa := m.liveAggregator(symbol)
+	if sa == nil {
+		return nil, fmt.Errorf("symbol %s is not active", symbol)
+	}
+	closed := sa.ForceClose(timeframe)
+	for _, c := range closed {
+		log.Printf("🔒 Force-closed %s:%s bar %s", c.Symbol, c.Timeframe, c.StartTime.Format("2006-01-02 15:04:05"))
+	}
+	return closed, nil
+}
+
+func (m *AggregatorManager) liveAggregator(symbol string) *SymbolAggregator {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.Aggregators[symbol]
+}
+
+// --- HTTP ---
+
+type adminServer struct {
+	manager   *AggregatorManager
+	pingRedis func(ctx context.Context) error
+	sources   func() map[string]bool // 行情源 -> 是否已连接
+}
+
+// NewAdminHandler 返回管理接口:
+//
+//	GET    /healthz                                 存活检查
+//	GET    /readyz                                  就绪检查: Redis 可达且至少一个行情源已连接
+//	GET    /metrics                                 Prometheus 指标
+//	GET    /symbols                                 活跃品种列表
+//	GET    /symbols/{symbol}                        品种状态和未闭合的K线
+//	POST   /symbols/{symbol}                        启用并创建品种
+//	DELETE /symbols/{symbol}                        移除品种
+//	POST   /symbols/{symbol}/timeframes/{timeframe} 增加周期
+//	DELETE /symbols/{symbol}/timeframes/{timeframe} 移除周期
+//	POST   /symbols/{symbol}/close[?timeframe=M1]   强制关闭未闭合的K线
+//
+// 运行时的修改不持久化, 重启后以 SYMBOL_CONFIG 为准.
+func NewAdminHandler(m *AggregatorManager, pingRedis func(ctx context.Context) error, sources func() map[string]bool, token string) http.Handler {
+	a := &adminServer{manager: m, pingRedis: pingRedis, sources: sources}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", a.ready)
+	mux.Handle("GET /metrics", m.MetricsHandler())
+	mux.HandleFunc("GET /symbols", a.listSymbols)
+	mux.HandleFunc("GET /symbols/{symbol}", a.getSymbol)
+	mux.HandleFunc("POST /symbols/{symbol}", a.addSymbol)
+	mux.HandleFunc("DELETE /symbols/{symbol}", a.removeSymbol)
+	mux.HandleFunc("POST /symbols/{symbol}/timeframes/{timeframe}", a.addTimeframe)
+	mux.HandleFunc("DELETE /symbols/{symbol}/timeframes/{timeframe}", a.removeTimeframe)
+	mux.HandleFunc("POST /symbols/{symbol}/close", a.forceClose)
+	return requireToken(token, mux)
+}
+
+// requireToken 设置了 token 时, 除 GET 以外的请求都需要携带
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := []byte(r.Header.Get("Authorization"))
+		if r.Method != http.MethodGet && subtle.ConstantTimeCompare(given, []byte("Bearer "+token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *adminServer) ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	redisStatus := "ok"
+	if err := a.pingRedis(ctx); err != nil {
+		redisStatus = err.Error()
+	}
+	sources := a.sources()
+	anyConnected := false
+	for _, connected := range sources {
+		anyConnected = anyConnected || connected
+	}
+	ages := make(map[string]float64)
+	for _, st := range a.manager.SymbolStatuses(false) {
+		ages[st.Symbol] = st.LastTickAge
+	}
+
+	ready := redisStatus == "ok" && anyConnected
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"ready":                 ready,
+		"redis":                 redisStatus,
+		"sources":               sources,
+		"last_tick_age_seconds": ages,
+	})
+}
+
+func (a *adminServer) listSymbols(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.manager.SymbolStatuses(false))
+}
+
+func (a *adminServer) getSymbol(w http.ResponseWriter, r *http.Request) {
+	st, ok := a.manager.SymbolStatus(r.PathValue("symbol"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("symbol %s is not active", r.PathValue("symbol")))
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (a *adminServer) addSymbol(w http.ResponseWriter, r *http.Request) {
+	symbol := r.PathValue("symbol")
+	if err := a.manager.AddSymbol(symbol); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	st, _ := a.manager.SymbolStatus(symbol)
+	writeJSON(w, http.StatusCreated, st)
+}
+
+func (a *adminServer) removeSymbol(w http.ResponseWriter, r *http.Request) {
+	symbol := r.PathValue("symbol")
+	existed := a.manager.RemoveSymbol(symbol)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"symbol": symbol, "removed": existed})
+}
+
+func (a *adminServer) addTimeframe(w http.ResponseWriter, r *http.Request) {
+	a.changeTimeframe(w, r, a.manager.AddTimeframe)
+}
+
+func (a *adminServer) removeTimeframe(w http.ResponseWriter, r *http.Request) {
+	a.changeTimeframe(w, r, a.manager.RemoveTimeframe)
+}
+
+func (a *adminServer) changeTimeframe(w http.ResponseWriter, r *http.Request, change func(symbol, timeframe string) error) {
+	symbol := r.PathValue("symbol")
+	if err := change(symbol, r.PathValue("timeframe")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"symbol":     symbol,
+		"timeframes": a.manager.symbolConfig(symbol).Timeframes,
+	})
+}
+
+func (a *adminServer) forceClose(w http.ResponseWriter, r *http.Request) {
+	closed, err := a.manager.ForceClose(r.PathValue("symbol"), r.URL.Query().Get("timeframe"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"closed": closed})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func noSources() map[string]bool { return nil }
+
+func newAdminTestServer(t *testing.T, token string) (*AggregatorManager, *recordingPublisher, http.Handler) {
+	pub := &recordingPublisher{}
+	m := newPoolTestManager(t, nil, pub, WorkerPoolConfig{Workers: 1, IdleTimeout: -1})
+	ping := func(ctx context.Context) error { return nil }
+	return m, pub, NewAdminHandler(m, ping, noSources, token)
+}
+
+func adminRequest(t *testing.T, h http.Handler, method, path string, out interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: bad response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdminSymbolLifecycle(t *testing.T) {
+	m, pub, h := newAdminTestServer(t, "")
+	m.HandleTick(testTick(t, "2025-11-24T10:00:10Z", 2000))
+	waitIdle(t, m)
+
+	var st SymbolStatus
+	if code := adminRequest(t, h, "GET", "/symbols/XAUUSD", &st); code != http.StatusOK {
+		t.Fatalf("GET symbol: status %d", code)
+	}
+	if c := st.Candles["M1"]; c == nil || c.Open != 2000 {
+		t.Fatalf("want in-progress M1 candle, got %+v", st.Candles)
+	}
+
+	if code := adminRequest(t, h, "POST", "/symbols/XAUUSD/timeframes/H1", nil); code != http.StatusOK {
+		t.Fatalf("add timeframe: status %d", code)
+	}
+	m.HandleTick(testTick(t, "2025-11-24T10:00:20Z", 2001))
+	waitIdle(t, m)
+	adminRequest(t, h, "GET", "/symbols/XAUUSD", &st)
+	if c := st.Candles["H1"]; c == nil || c.Open != 2001 {
+		t.Fatalf("want H1 aggregating after add, got %+v", st.Candles)
+	}
+
+	var closed struct{ Closed []Candle }
+	adminRequest(t, h, "POST", "/symbols/XAUUSD/close?timeframe=M1", &closed)
+	if len(closed.Closed) != 1 || closed.Closed[0].Timeframe != "M1" || len(pub.closed()) != 1 {
+		t.Fatalf("want M1 force-closed, got %+v", closed.Closed)
+	}
+
+	if code := adminRequest(t, h, "DELETE", "/symbols/XAUUSD/timeframes/M1", nil); code != http.StatusOK {
+		t.Fatalf("remove timeframe: status %d", code)
+	}
+	if code := adminRequest(t, h, "DELETE", "/symbols/XAUUSD/timeframes/H1", nil); code != http.StatusBadRequest {
+		t.Fatalf("removing the last timeframe: want 400, got %d", code)
+	}
+
+	adminRequest(t, h, "DELETE", "/symbols/XAUUSD", nil)
+	m.HandleTick(testTick(t, "2025-11-24T10:00:30Z", 2002))
+	if code := adminRequest(t, h, "GET", "/symbols/XAUUSD", nil); code != http.StatusNotFound {
+		t.Fatalf("want removed symbol to stay inactive, got %d", code)
+	}
+
+	if code := adminRequest(t, h, "POST", "/symbols/XAUUSD", &st); code != http.StatusCreated || len(st.Timeframes) != 1 || st.Timeframes[0] != "H1" {
+		t.Fatalf("want symbol re-added with runtime timeframes, got %d %+v", code, st)
+	}
+}
+
+func TestAdminReadiness(t *testing.T) {
+	m, _, _ := newAdminTestServer(t, "")
+	monitor := &FeedMonitor{}
+	monitor.setConnected("admin-test", true)
+
+	var body struct {
+		Ready bool
+		Redis string
+	}
+	ok := NewAdminHandler(m, func(ctx context.Context) error { return nil }, monitor.Connected, "")
+	if code := adminRequest(t, ok, "GET", "/readyz", &body); code != http.StatusOK || !body.Ready {
+		t.Fatalf("want ready, got %d %+v", code, body)
+	}
+	down := NewAdminHandler(m, func(ctx context.Context) error { return errors.New("connection refused") }, monitor.Connected, "")
+	if code := adminRequest(t, down, "GET", "/readyz", &body); code != http.StatusServiceUnavailable || body.Redis != "connection refused" {
+		t.Fatalf("want not ready without Redis, got %d %+v", code, body)
+	}
+}
+
+func TestAdminTokenRequiredForChanges(t *testing.T) {
+	_, _, h := newAdminTestServer(t, "secret")
+	if code := adminRequest(t, h, "POST", "/symbols/XAUUSD", nil); code != http.StatusUnauthorized {
+		t.Fatalf("want 401 without token, got %d", code)
+	}
+	if code := adminRequest(t, h, "GET", "/symbols", nil); code != http.StatusOK {
+		t.Fatalf("want read access without token, got %d", code)
+	}
+
+	req := httptest.NewRequest("POST", "/symbols/XAUUSD", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("want 201 with token, got %d", rec.Code)
+	}
+}
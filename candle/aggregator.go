@@ -2,33 +2,53 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/go-redis/redis/v8"
 )
 
 // --- 管理单个周期 (例如 1M) ---
 type TimeframeAggregator struct {
 	Symbol       string
-	Timeframe    time.Duration
+	Timeframe    Timeframe
 	TfName       string // "M1", "M5" ...
+	PriceType    string // "bid" | "ask" | "mid"
+	session      *Session         // 交易时段 (决定日切和H4对齐), nil 为UTC
+	calendar     *TradingCalendar // 交易日历 (决定哪些缺口需要补), nil 为全天候
+	gapFill      string           // fill | skip | synthetic
+	closeGrace   time.Duration    // 定时关闭: 窗口结束后再等待的宽限期
+	lateTicks    string           // 已关闭K线收到迟到Tick时: amend | drop
+	maxLateness  time.Duration    // K线结束后仍接受迟到Tick的时长
 	currentCandle *Candle
+	lastClosed   *Candle    // 最近一根已闭合的K线 (定时关闭后作为参照)
+	recent       []*Candle  // 仍在迟到容忍窗口内的已闭合K线 (按时间升序)
+	watermark    time.Time  // 已收到的最新Tick时间
+	lateDropped  int64      // 未能修正K线的迟到Tick数 (drop 策略或超出容忍窗口)
 	lock         sync.Mutex // 保护此周期的 currentCandle
-	redisClient  *redis.Client
+	publisher    CandlePublisher
 	redisChannel string
+	state        StateStore    // 可选: 未闭合K线持久化
+	stateKey     string        // 在 StateStore 中的周期键, 同 SymbolAggregator.Timeframes 的 key
+	clockOffset  *atomic.Int64 // 所属品种的行情源时钟偏移, 随状态一起保存
 }
 
-func NewTimeframeAggregator(symbol, tfName string, timeframe time.Duration, rdb *redis.Client) *TimeframeAggregator {
+func NewTimeframeAggregator(symbol, series string, timeframe Timeframe, cfg SymbolConfig, pub CandlePublisher) *TimeframeAggregator {
 	return &TimeframeAggregator{
 		Symbol:       symbol,
 		Timeframe:    timeframe,
-		TfName:       tfName,
-		redisClient:  rdb,
-		redisChannel: fmt.Sprintf("kline:%s:%s", symbol, tfName),
+		TfName:       timeframe.Name,
+		PriceType:    series,
+		session:      cfg.session,
+		calendar:     cfg.calendar,
+		gapFill:      cfg.GapFill,
+		closeGrace:   cfg.CloseGrace.Std(),
+		lateTicks:    cfg.LateTicks,
+		maxLateness:  cfg.MaxLateness.Std(),
+		publisher:    pub,
+		redisChannel: klineChannel(symbol, timeframe.Name, series),
 	}
 }
 
@@ -36,276 +56,709 @@ func (t *TimeframeAggregator) ProcessTick(tick CleanTick) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	tickWindowStart := tick.Timestamp.Truncate(t.Timeframe)
+	price, ok := seriesPrice(tick, t.PriceType)
+	if !ok {
+		return // 该Tick缺少此序列需要的价格
+	}
+
+	tickWindowStart := t.session.WindowStart(t.Timeframe, tick.Timestamp)
+	if tick.Timestamp.After(t.watermark) {
+		t.watermark = tick.Timestamp
+		t.pruneRecent()
+	}
 
 	// 情况一：第一根K线
-	if t.currentCandle == nil {
-		t.currentCandle = &Candle{
-			Symbol:    t.Symbol, Timeframe: t.TfName, StartTime: tickWindowStart,
-			Open: tick.Price, High: tick.Price, Low: tick.Price, Close: tick.Price, Volume: tick.Volume,
-		}
+	if t.currentCandle == nil && t.lastClosed == nil {
+		t.currentCandle = newCandleFromTick(t.Symbol, t.TfName, t.PriceType, tickWindowStart, price, tick)
 		t.publishCandle("UPDATE")
 		return
 	}
 
+	// 当前K线已被定时器关闭时, 以最近闭合的K线为参照
+	ref := t.currentCandle
+	if ref == nil {
+		ref = t.lastClosed
+	}
+
 	// 情况二：Tick 属于一根新K线
-	if tickWindowStart.After(t.currentCandle.StartTime) {
+	if tickWindowStart.After(ref.StartTime) {
+		if t.currentCandle != nil {
+			t.closeCurrent() // 关闭旧K线
+		}
+
 		// 检测时间跳跃（可能丢失了中间的K线）
-		missedBars := int(tickWindowStart.Sub(t.currentCandle.StartTime) / t.Timeframe)
+		missedBars := t.session.BarsBetween(t.Timeframe, ref.StartTime, tickWindowStart)
 		if missedBars > 1 {
 			log.Printf("⚠️  Time gap detected for %s:%s - missed %d bars (from %s to %s)", 
 				t.Symbol, t.TfName, missedBars-1,
-				t.currentCandle.StartTime.Format("15:04:05"),
+				ref.StartTime.Format("15:04:05"),
 				tickWindowStart.Format("15:04:05"))
 			
 			// 填充缺失的K线（使用上一根的收盘价作为OHLC）
-			t.fillMissingBars(missedBars - 1)
+			t.fillMissingBars(ref, missedBars-1)
 		}
 		
-		t.publishCandle("CLOSE") // 关闭旧K线
-		t.currentCandle = &Candle{
-			Symbol:    t.Symbol, Timeframe: t.TfName, StartTime: tickWindowStart,
-			Open: tick.Price, High: tick.Price, Low: tick.Price, Close: tick.Price, Volume: tick.Volume,
-		}
+		t.currentCandle = newCandleFromTick(t.Symbol, t.TfName, t.PriceType, tickWindowStart, price, tick)
 		t.publishCandle("UPDATE") // 开启新K线
 		return
 	}
 
 	// 情况三：Tick 属于当前K线
-	if tickWindowStart.Equal(t.currentCandle.StartTime) {
-		t.currentCandle.High = max(t.currentCandle.High, tick.Price)
-		t.currentCandle.Low = min(t.currentCandle.Low, tick.Price)
-		t.currentCandle.Close = tick.Price
-		t.currentCandle.Volume += tick.Volume
+	if t.currentCandle != nil && tickWindowStart.Equal(t.currentCandle.StartTime) {
+		t.currentCandle.apply(price, tick)
 		t.publishCandle("UPDATE") // 实时跳动
 		return
 	}
+
+	// 情况四：迟到tick（属于容忍窗口内已闭合的K线）
+	for _, closed := range t.recent {
+		if closed.StartTime.Equal(tickWindowStart) {
+			t.handleLateTick(closed, price, tick)
+			return
+		}
+	}
 	
-	// 情况四：乱序tick（时间戳早于当前K线）
-	if tickWindowStart.Before(t.currentCandle.StartTime) {
-		log.Printf("⚠️  Out-of-order tick for %s:%s (tick: %s, current: %s) - ignoring",
-			t.Symbol, t.TfName, 
+	// 情况五：乱序tick（超出迟到容忍窗口）
+	t.lateDropped++
+	if t.lateDropped%100 == 1 {
+		log.Printf("⚠️  Out-of-order tick for %s:%s beyond %s lateness (tick: %s, current: %s) - ignoring (total: %d)",
+			t.Symbol, t.TfName, t.maxLateness,
 			tickWindowStart.Format("15:04:05"),
-			t.currentCandle.StartTime.Format("15:04:05"))
+			ref.StartTime.Format("15:04:05"), t.lateDropped)
+	}
+}
+
+// closeCurrent 发布 CLOSE 并把当前K线移到 lastClosed
+func (t *TimeframeAggregator) closeCurrent() {
+	t.publishCandle("CLOSE")
+	t.lastClosed = t.currentCandle
+	t.recent = append(t.recent, t.currentCandle)
+	t.currentCandle = nil
+}
+
+// pruneRecent 移除结束时间已超出迟到容忍窗口的K线
+func (t *TimeframeAggregator) pruneRecent() {
+	keep := 0
+	for keep < len(t.recent) {
+		end := t.session.Next(t.Timeframe, t.recent[keep].StartTime)
+		if !t.watermark.After(end.Add(t.maxLateness)) {
+			break
+		}
+		keep++
+	}
+	if keep > 0 {
+		t.recent = append(t.recent[:0], t.recent[keep:]...)
+	}
+}
+
+// handleLateTick 已闭合K线收到迟到Tick: amend 时修正并发布 AMEND, 否则丢弃计数
+func (t *TimeframeAggregator) handleLateTick(closed *Candle, price float64, tick CleanTick) {
+	if t.lateTicks != LateAmend {
+		t.lateDropped++
+		if t.lateDropped%100 == 1 {
+			log.Printf("⚠️  Late tick for closed %s:%s bar %s dropped (total: %d)",
+				t.Symbol, t.TfName, closed.StartTime.Format("15:04:05"), t.lateDropped)
+		}
 		return
 	}
+
+	if closed.TickCount == 0 {
+		// 缺口填充的K线收到第一个真实Tick, 以该Tick重建
+		*closed = *newCandleFromTick(t.Symbol, t.TfName, t.PriceType, closed.StartTime, price, tick)
+	} else {
+		closed.apply(price, tick)
+	}
+	t.publisher.Publish(t.redisChannel, PublishEvent{Status: "AMEND", Candle: *closed})
+	log.Printf("✏️  Amended closed %s:%s bar %s with late tick",
+		t.Symbol, t.TfName, closed.StartTime.Format("15:04:05"))
+}
+
+// CloseDue 定时关闭: 行情源时间已越过窗口结束 + 宽限期时关闭当前K线, 不必等待下一个Tick
+func (t *TimeframeAggregator) CloseDue(sourceNow time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// 无Tick时也按时间推进, 使容忍窗口外的K线不再接受修正
+	if sourceNow.After(t.watermark) {
+		t.watermark = sourceNow
+		t.pruneRecent()
+	}
+	if t.currentCandle == nil {
+		return
+	}
+	windowEnd := t.session.Next(t.Timeframe, t.currentCandle.StartTime)
+	if sourceNow.Before(windowEnd.Add(t.closeGrace)) {
+		return
+	}
+	t.closeCurrent()
+}
+
+// closeDueAt 返回当前K线应被定时关闭的行情源时间; 没有未闭合K线时返回 false
+func (t *TimeframeAggregator) closeDueAt() (time.Time, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.currentCandle == nil {
+		return time.Time{}, false
+	}
+	return t.session.Next(t.Timeframe, t.currentCandle.StartTime).Add(t.closeGrace), true
+}
+
+// LateDropped 返回未能修正K线而丢弃的迟到Tick数
+func (t *TimeframeAggregator) LateDropped() int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.lateDropped
 }
 
-// 填充缺失的K线
-func (t *TimeframeAggregator) fillMissingBars(count int) {
-	if t.currentCandle == nil || count <= 0 {
+// 填充 ref 之后缺失的K线 (按 gapFill 模式, 只补交易日历中开市的区间)
+func (t *TimeframeAggregator) fillMissingBars(ref *Candle, count int) {
+	if ref == nil || count <= 0 || t.gapFill == GapSkip {
 		return
 	}
 	
-	lastClose := t.currentCandle.Close
-	currentTime := t.currentCandle.StartTime
+	lastClose := ref.Close
+	currentTime := ref.StartTime
+	skipped := 0
+	filled := 0
 	
 	for i := 0; i < count; i++ {
-		currentTime = currentTime.Add(t.Timeframe)
+		currentTime = t.session.Next(t.Timeframe, currentTime)
+
+		// 休市时段 (周末、节假日、每日休市) 不补K线
+		if !t.calendar.IsOpenDuring(currentTime, t.session.Next(t.Timeframe, currentTime)) {
+			skipped++
+			continue
+		}
 		
 		// 创建一个平坦的K线（OHLC都相同）
 		missingCandle := &Candle{
 			Symbol:    t.Symbol,
 			Timeframe: t.TfName,
+			PriceType: t.PriceType,
 			StartTime: currentTime,
 			Open:      lastClose,
 			High:      lastClose,
 			Low:       lastClose,
 			Close:     lastClose,
 			Volume:    0, // 无成交量
+			Synthetic: t.gapFill == GapSynthetic,
 		}
 		
-		// 发布缺失的K线
-		event := PublishEvent{Status: "CLOSE", Candle: *missingCandle}
-		go func(e PublishEvent) {
-			err := t.redisClient.Publish(context.Background(), t.redisChannel, e.ToJSON()).Err()
-			if err != nil {
-				log.Printf("ERROR: Failed to publish missing bar: %v", err)
-			}
-		}(event)
+		// 发布缺失的K线, 并保留以便迟到Tick修正
+		t.publisher.Publish(t.redisChannel, PublishEvent{Status: "CLOSE", Candle: *missingCandle})
+		t.recent = append(t.recent, missingCandle)
+		filled++
 		
 		log.Printf("📝 Filled missing bar for %s:%s at %s", 
 			t.Symbol, t.TfName, currentTime.Format("15:04:05"))
 	}
+	if filled > 0 {
+		metrics.gapFilledBars.Add(float64(filled), t.Symbol, t.TfName)
+	}
+	if skipped > 0 {
+		log.Printf("💤 Skipped %d closed-market bars for %s:%s", skipped, t.Symbol, t.TfName)
+	}
 }
 
 func (t *TimeframeAggregator) publishCandle(status string) {
 	if t.currentCandle == nil { return }
 	event := PublishEvent{ Status: status, Candle: *t.currentCandle }
-	go func() { // 异步发布, 不阻塞K线聚合
-		err := t.redisClient.Publish(context.Background(), t.redisChannel, event.ToJSON()).Err()
-		if err != nil {
-			log.Printf("ERROR: Redis Publish to %s failed: %v", t.redisChannel, err)
-		}
-	}()
+	t.publisher.Publish(t.redisChannel, event)
+	t.saveState(status)
+}
+
+// saveState 持久化未闭合的K线, 闭合后删除
+func (t *TimeframeAggregator) saveState(status string) {
+	if t.state == nil {
+		return
+	}
+	if status == "CLOSE" {
+		t.state.Delete(t.Symbol, t.stateKey)
+		return
+	}
+	t.state.Save(t.Symbol, t.stateKey, newCandleState(t.currentCandle, time.Duration(t.clockOffset.Load())))
+}
+
+// canRestore 检查保存的状态是否仍符合当前的周期和时段配置
+func (t *TimeframeAggregator) canRestore(state CandleState) bool {
+	return state.PriceType == t.PriceType &&
+		state.Timeframe == t.TfName &&
+		t.session.WindowStart(t.Timeframe, state.StartTime).Equal(state.StartTime)
+}
+
+// restore 以保存的状态作为当前K线
+func (t *TimeframeAggregator) restore(state CandleState) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.currentCandle != nil {
+		return
+	}
+	t.currentCandle = state.candle()
+	if state.LastTick.After(t.watermark) {
+		t.watermark = state.LastTick
+	}
+}
+
+// Flush 将当前未闭合的K线作为 CLOSE 发布 (回放结束或手动强制收线时使用), 返回关闭的K线
+func (t *TimeframeAggregator) Flush() *Candle {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.currentCandle == nil {
+		return nil
+	}
+	closed := *t.currentCandle
+	t.closeCurrent()
+	return &closed
+}
+
+// discard 丢弃当前未闭合的K线和持久化状态, 不发布
+func (t *TimeframeAggregator) discard() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.currentCandle = nil
+	if t.state != nil {
+		t.state.Delete(t.Symbol, t.stateKey)
+	}
+}
+
+// snapshot 返回当前未闭合K线的副本
+func (t *TimeframeAggregator) snapshot() *Candle {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.currentCandle == nil {
+		return nil
+	}
+	c := *t.currentCandle
+	return &c
 }
 
 
 // --- 管理单个品种 (例如 XAUUSD) 的所有周期 ---
 type SymbolAggregator struct {
 	Symbol     string
-	Timeframes map[string]*TimeframeAggregator
+	Timeframes map[string]*TimeframeAggregator // key: "M1" (bid) 或 "M1:ask"
+	order      []*TimeframeAggregator          // 固定处理顺序, 保证回放结果确定
+	mu         sync.RWMutex                    // 保护 Timeframes 和 order (运行时可增删周期)
+	cfg        SymbolConfig
+	publisher  CandlePublisher
+	state      StateStore
+
+	// 行情源时间相对本机时钟的偏移 (纳秒), 定时关闭据此把墙上时间换算为行情源时间
+	clockOffset atomic.Int64
+	seenTick    atomic.Bool
 }
 
-func NewSymbolAggregator(symbol string, rdb *redis.Client) *SymbolAggregator {
+func NewSymbolAggregator(symbol string, cfg SymbolConfig, pub CandlePublisher) *SymbolAggregator {
 	sa := &SymbolAggregator{
 		Symbol:     symbol,
 		Timeframes: make(map[string]*TimeframeAggregator),
+		cfg:        cfg,
+		publisher:  pub,
+	}
+	for _, series := range cfg.PriceSeries {
+		for _, tf := range cfg.timeframes {
+			sa.addSeries(tf, series)
+		}
 	}
-	sa.Timeframes["M1"] = NewTimeframeAggregator(symbol, "M1", time.Minute, rdb)
-	sa.Timeframes["M5"] = NewTimeframeAggregator(symbol, "M5", 5*time.Minute, rdb)
-	sa.Timeframes["M15"] = NewTimeframeAggregator(symbol, "M15", 15*time.Minute, rdb)
-	sa.Timeframes["M30"] = NewTimeframeAggregator(symbol, "M30", 30*time.Minute, rdb)
-	sa.Timeframes["H1"] = NewTimeframeAggregator(symbol, "H1", time.Hour, rdb)
-	sa.Timeframes["H4"] = NewTimeframeAggregator(symbol, "H4", 4*time.Hour, rdb)
-	sa.Timeframes["D1"] = NewTimeframeAggregator(symbol, "D1", 24*time.Hour, rdb)
 	return sa
 }
 
+// timeframeKey 周期在 SymbolAggregator.Timeframes 和 StateStore 中的键
+func timeframeKey(timeframe, series string) string {
+	if series == PriceBid {
+		return timeframe
+	}
+	return timeframe + ":" + series
+}
+
+// addSeries 增加一个周期的一个价格序列, 已存在时返回 false; 调用方持有写锁
+func (s *SymbolAggregator) addSeries(tf Timeframe, series string) bool {
+	key := timeframeKey(tf.Name, series)
+	if _, exists := s.Timeframes[key]; exists {
+		return false
+	}
+	tfAgg := NewTimeframeAggregator(s.Symbol, series, tf, s.cfg, s.publisher)
+	if s.state != nil {
+		tfAgg.state = s.state
+		tfAgg.stateKey = key
+		tfAgg.clockOffset = &s.clockOffset
+	}
+	s.Timeframes[key] = tfAgg
+	s.order = append(s.order, tfAgg)
+	return true
+}
+
+// AddTimeframe 运行时增加周期, 从下一个Tick开始聚合
+func (s *SymbolAggregator) AddTimeframe(tf Timeframe) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added := false
+	for _, series := range s.cfg.PriceSeries {
+		if s.addSeries(tf, series) {
+			added = true
+		}
+	}
+	return added
+}
+
+// RemoveTimeframe 运行时移除周期; 未闭合的K线直接丢弃, 不发布 CLOSE
+func (s *SymbolAggregator) RemoveTimeframe(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := false
+	for _, series := range s.cfg.PriceSeries {
+		key := timeframeKey(name, series)
+		tfAgg, exists := s.Timeframes[key]
+		if !exists {
+			continue
+		}
+		tfAgg.discard()
+		delete(s.Timeframes, key)
+		for i, t := range s.order {
+			if t == tfAgg {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+		removed = true
+	}
+	return removed
+}
+
+// Discard 丢弃所有未闭合的K线及其持久化状态 (品种被移除时使用)
+func (s *SymbolAggregator) Discard() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, tfAgg := range s.order {
+		tfAgg.discard()
+	}
+}
+
+// ForceClose 立即关闭指定周期 (为空时为所有周期) 的未闭合K线并发布 CLOSE, 返回关闭的K线
+func (s *SymbolAggregator) ForceClose(timeframe string) []Candle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var closed []Candle
+	for _, tfAgg := range s.order {
+		if timeframe != "" && tfAgg.TfName != timeframe {
+			continue
+		}
+		if c := tfAgg.Flush(); c != nil {
+			closed = append(closed, *c)
+		}
+	}
+	return closed
+}
+
+// Snapshot 返回每个周期当前未闭合K线的副本, 没有未闭合K线的周期为 nil
+func (s *SymbolAggregator) Snapshot() map[string]*Candle {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make(map[string]*Candle, len(s.Timeframes))
+	for key, tfAgg := range s.Timeframes {
+		result[key] = tfAgg.snapshot()
+	}
+	return result
+}
+
+// attachState 为所有周期启用状态持久化
+func (s *SymbolAggregator) attachState(store StateStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = store
+	for key, tfAgg := range s.Timeframes {
+		tfAgg.state = store
+		tfAgg.stateKey = key
+		tfAgg.clockOffset = &s.clockOffset
+	}
+}
+
+// restoreClock 恢复时沿用保存的时钟偏移, 使定时关闭在第一个Tick到来前也能工作
+func (s *SymbolAggregator) restoreClock(state CandleState) {
+	if s.seenTick.Load() {
+		return
+	}
+	s.clockOffset.Store(int64(state.ClockOffset))
+	s.seenTick.Store(true)
+}
+
 func (s *SymbolAggregator) ProcessTick(tick CleanTick) {
-	for _, tfAgg := range s.Timeframes {
+	if !tick.ReceivedAt.IsZero() {
+		s.clockOffset.Store(int64(tick.Timestamp.Sub(tick.ReceivedAt)))
+		s.seenTick.Store(true)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, tfAgg := range s.order {
 		tfAgg.ProcessTick(tick)
 	}
 }
 
+func (s *SymbolAggregator) Flush() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, tfAgg := range s.order {
+		tfAgg.Flush()
+	}
+}
+
+// CloseDue 按本机时间 now 关闭已到期的K线; 尚未收到过Tick时无从换算行情源时间, 跳过
+func (s *SymbolAggregator) CloseDue(now time.Time) {
+	if !s.seenTick.Load() {
+		return
+	}
+	sourceNow := now.Add(time.Duration(s.clockOffset.Load()))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, tfAgg := range s.order {
+		tfAgg.CloseDue(sourceNow)
+	}
+}
+
+// nextCloseAt 返回最早一根未闭合K线应被定时关闭的本机时间; 没有未闭合K线时返回 false
+func (s *SymbolAggregator) nextCloseAt() (time.Time, bool) {
+	var next time.Time
+	open := false
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, tfAgg := range s.order {
+		due, ok := tfAgg.closeDueAt()
+		if ok && (!open || due.Before(next)) {
+			next, open = due, true
+		}
+	}
+	return next.Add(-time.Duration(s.clockOffset.Load())), open
+}
+
+// LateDropped 返回该品种所有周期丢弃的迟到Tick总数
+func (s *SymbolAggregator) LateDropped() int64 {
+	var total int64
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, tfAgg := range s.order {
+		total += tfAgg.LateDropped()
+	}
+	return total
+}
 
 
 type AggregatorManager struct {
-	Aggregators  map[string]*SymbolAggregator // 存储聚合器实例
-	Channels     map[string]chan CleanTick    // 存储每个Symbol的专属通道
-	lock         sync.RWMutex                 // 保护上面两个 map
-	redisClient  *redis.Client
-	droppedTicks map[string]int64             // 统计每个品种丢弃的tick数量
-	statsLock    sync.Mutex                   // 保护统计数据
+	Aggregators   map[string]*SymbolAggregator // 存储聚合器实例
+	queues        map[string]*tickQueue        // 每个品种的Tick队列
+	dormant       map[string]time.Time         // 已回收但仍有未闭合K线的品种 -> 最早到期的本机时间
+	disabled      map[string]bool              // 通过管理接口移除的品种, 其Tick被丢弃
+	lock          sync.RWMutex                 // 保护上面四个 map 和 filters
+	shards        []*poolShard                 // 固定数量的分片工人, 品种按名称哈希分配
+	pool          WorkerPoolConfig
+	publisher     CandlePublisher
+	symbolConfigs SymbolConfigs
+	configLock    sync.RWMutex                 // 保护 symbolConfigs (管理接口可在运行时修改)
+	Archiver      *TickArchiver                // 可选: 通过质量过滤的Tick归档
+	State         StateStore                   // 可选: 未闭合K线持久化
+	OnReject      func(TickRejection)          // 可选: Tick被质量过滤拒绝时的通知
+	filters       map[string]*TickFilter       // 每个品种的质量过滤 (未配置的品种没有)
+	lookup        KlineLookup                  // RestoreState 传入, 回收的品种恢复时同样用于核对
+	resume        atomic.Bool                  // RestoreState 完成后, 新建的品种先从 State 恢复
+	droppedTicks  map[string]int64             // 统计每个品种丢弃的tick数量
+	sourceTicks   map[string]int64             // 统计每个行情源收到的tick数量
+	statsLock     sync.Mutex                   // 保护统计数据
 }
 
-func NewAggregatorManager(rdb *redis.Client) *AggregatorManager {
+// NewAggregatorManager 启动分片工人和后台 goroutine; ctx 结束时它们全部退出 (停机时先 Drain)
+func NewAggregatorManager(ctx context.Context, pub CandlePublisher, symbolConfigs SymbolConfigs, pool WorkerPoolConfig) *AggregatorManager {
 	am := &AggregatorManager{
-		Aggregators:  make(map[string]*SymbolAggregator),
-		Channels:     make(map[string]chan CleanTick),
-		redisClient:  rdb,
-		droppedTicks: make(map[string]int64),
+		Aggregators:   make(map[string]*SymbolAggregator),
+		queues:        make(map[string]*tickQueue),
+		dormant:       make(map[string]time.Time),
+		disabled:      make(map[string]bool),
+		pool:          pool.withDefaults(),
+		publisher:     pub,
+		symbolConfigs: symbolConfigs,
+		filters:       make(map[string]*TickFilter),
+		droppedTicks:  make(map[string]int64),
+		sourceTicks:   make(map[string]int64),
 	}
-	
+	for i := 0; i < am.pool.Workers; i++ {
+		shard := newPoolShard()
+		am.shards = append(am.shards, shard)
+		go shard.run(ctx)
+	}
+	log.Printf("🚀 Started %d aggregation workers", len(am.shards))
+
 	// 启动监控goroutine，每30秒输出统计信息
-	go am.monitorStats()
-	
+	go am.monitorStats(ctx)
+	if am.pool.IdleTimeout > 0 {
+		go am.runEviction(ctx)
+	}
+
 	return am
 }
 
-func (m *AggregatorManager) HandleRawQuote(quote UpstreamQuote) {
-	cleanTick, err := m.parseQuote(quote)
-	if err != nil {
-		log.Printf("Failed to parse quote: %v", err)
+// HandleTick 接收任意行情源产出的Tick, 放入对应品种的队列
+func (m *AggregatorManager) HandleTick(cleanTick CleanTick) {
+	m.statsLock.Lock()
+	m.sourceTicks[cleanTick.Source]++
+	m.statsLock.Unlock()
+	metrics.ticksReceived.Inc(cleanTick.Source, cleanTick.Symbol)
+
+	for {
+		q, err := m.symbolQueue(cleanTick.Symbol)
+		if err != nil {
+			reason := "symbol_limit"
+			if err == errSymbolDisabled {
+				reason = "disabled"
+			}
+			m.countDropped(cleanTick.Symbol, reason, err.Error())
+			return
+		}
+		accepted, dropped := q.push(cleanTick)
+		if !accepted {
+			continue // 队列刚被回收, 重新创建
+		}
+		if dropped {
+			m.countDropped(cleanTick.Symbol, "queue_full", fmt.Sprintf("queue full (%d), %s", q.size, q.policy))
+		}
 		return
 	}
+}
+
+// symbolConfig 返回品种当前生效的配置
+func (m *AggregatorManager) symbolConfig(symbol string) SymbolConfig {
+	m.configLock.RLock()
+	defer m.configLock.RUnlock()
+	return m.symbolConfigs.For(symbol)
+}
+
+// IsMarketOpen 判断品种在 t 时刻是否处于交易时段 (行情源报价过期检测使用)
+func (m *AggregatorManager) IsMarketOpen(symbol string, t time.Time) bool {
+	if sa := m.liveAggregator(symbol); sa != nil {
+		return sa.cfg.calendar.IsOpen(t)
+	}
+	return m.symbolConfig(symbol).calendar.IsOpen(t)
+}
+
+// countDropped 记录丢弃的Tick, 每个品种每 1000 个输出一次日志
+func (m *AggregatorManager) countDropped(symbol, reason, detail string) {
+	metrics.ticksDropped.Inc(symbol, reason)
+	m.statsLock.Lock()
+	m.droppedTicks[symbol]++
+	dropped := m.droppedTicks[symbol]
+	m.statsLock.Unlock()
+
+	if dropped%1000 == 1 {
+		log.Printf("🔴 DROPPED tick for %s: %s (total dropped: %d)", symbol, detail, dropped)
+	}
+}
+
+var (
+	errSymbolDisabled = errors.New("symbol removed via admin API")
+	errSymbolLimit    = errors.New("symbol limit reached")
+)
+
+// symbolAggregator 返回品种的聚合器, 不存在时创建; 无法创建时返回 nil
+func (m *AggregatorManager) symbolAggregator(symbol string) *SymbolAggregator {
+	q, err := m.symbolQueue(symbol)
+	if err != nil {
+		return nil
+	}
+	return q.agg
+}
 
+// symbolQueue 返回品种的队列, 不存在时创建聚合器、过滤器和队列;
+// 品种已被移除或品种数已达上限时返回错误
+func (m *AggregatorManager) symbolQueue(symbol string) (*tickQueue, error) {
 	m.lock.RLock()
-	tickChannel, exists := m.Channels[cleanTick.Symbol]
+	q, exists := m.queues[symbol]
+	err := m.canCreate(symbol)
 	m.lock.RUnlock()
+	if exists {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	if !exists {
-		m.lock.Lock()
-		if tickChannel, exists = m.Channels[cleanTick.Symbol]; !exists {
-			log.Printf("🔧 Creating new Worker/Channel for %s", cleanTick.Symbol)
-			sa := NewSymbolAggregator(cleanTick.Symbol, m.redisClient)
-			tickChannel = make(chan CleanTick, 5000) // 增加缓冲容量到5000
-			m.Aggregators[cleanTick.Symbol] = sa
-			m.Channels[cleanTick.Symbol] = tickChannel
-			
-			go m.startSymbolWorker(sa, tickChannel) // 启动专属的 Goroutine (工人)
+	cfg := m.symbolConfig(symbol)
+	sa := NewSymbolAggregator(symbol, cfg, m.publisher)
+	if m.State != nil {
+		sa.attachState(m.State)
+		if m.resume.Load() {
+			m.resumeSymbol(sa) // 在接受Tick之前恢复, 否则新Tick会另起一根K线
 		}
-		m.lock.Unlock()
+	}
+	var filter *TickFilter
+	if cfg.Filters != nil {
+		filter = NewTickFilter(symbol, *cfg.Filters, cfg.session, m.OnReject)
 	}
 
-	// 改进的背压机制：阻塞发送 + 超时
-	// 优先尝试立即发送
-	select {
-	case tickChannel <- cleanTick:
-		// 成功发送
-		return
-	default:
-		// Channel满，记录警告并尝试等待
-		queueLen := len(tickChannel)
-		log.Printf("⚠️  Channel busy for %s (queue: %d/5000), waiting...", cleanTick.Symbol, queueLen)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if q, exists := m.queues[symbol]; exists {
+		return q, nil
 	}
-	
-	// 带超时的阻塞发送
-	select {
-	case tickChannel <- cleanTick:
-		// 成功发送
-		log.Printf("✅ Tick sent after waiting for %s", cleanTick.Symbol)
-	case <-time.After(500 * time.Millisecond):
-		// 超时，记录丢弃
-		m.statsLock.Lock()
-		m.droppedTicks[cleanTick.Symbol]++
-		dropped := m.droppedTicks[cleanTick.Symbol]
-		m.statsLock.Unlock()
-		
-		log.Printf("🔴 DROPPED tick for %s (total dropped: %d) - Worker may be stuck!", 
-			cleanTick.Symbol, dropped)
+	if err := m.canCreate(symbol); err != nil {
+		return nil, err
+	}
+	log.Printf("🔧 Creating aggregator for %s (%s, queue %d)", symbol, cfg.Backpressure, cfg.QueueSize)
+	q = newTickQueue(sa, filter, cfg, m.shardFor(symbol))
+	q.archiver = m.Archiver
+	m.queues[symbol] = q
+	m.Aggregators[symbol] = sa
+	if filter != nil {
+		m.filters[symbol] = filter
 	}
+	delete(m.dormant, symbol)
+	return q, nil
 }
 
-// 每个品种专属的“工人”
-func (m *AggregatorManager) startSymbolWorker(agg *SymbolAggregator, ch chan CleanTick) {
-	log.Printf("🚀 Worker started for %s", agg.Symbol)
-	tickCount := 0
-	lastLog := time.Now()
-	
-	for tick := range ch {
-		agg.ProcessTick(tick)
-		tickCount++
-		
-		// 每10秒输出一次处理速度
-		if time.Since(lastLog) > 10*time.Second {
-			queueLen := len(ch)
-			log.Printf("📊 %s: processed %d ticks in 10s (queue: %d/5000)", 
-				agg.Symbol, tickCount, queueLen)
-			tickCount = 0
-			lastLog = time.Now()
-			
-			// 如果队列积压严重，发出警告
-			if queueLen > 4000 {
-				log.Printf("⚠️  WARNING: %s queue is %d%% full!", 
-					agg.Symbol, queueLen*100/5000)
-			}
-		}
+// canCreate 检查能否新建品种; 调用方持有锁
+func (m *AggregatorManager) canCreate(symbol string) error {
+	if m.disabled[symbol] {
+		return errSymbolDisabled
+	}
+	if m.pool.MaxSymbols > 0 && len(m.queues) >= m.pool.MaxSymbols {
+		return fmt.Errorf("%w (%d)", errSymbolLimit, m.pool.MaxSymbols)
 	}
+	return nil
 }
 
-func (m *AggregatorManager) parseQuote(quote UpstreamQuote) (CleanTick, error) {
+// parseQuote 将上游 MT4 报价转换为 CleanTick
+func parseQuote(quote UpstreamQuote) (CleanTick, error) {
 	if quote.Type != "Quote" {
 		return CleanTick{}, fmt.Errorf("not a quote message")
 	}
 	args := quote.Data.Args
 	
-	const layout = "2006-01-02T15:04:05" 
-	ts, err := time.ParseInLocation(layout, args.Time, time.UTC) 
+	ts, err := time.ParseInLocation(upstreamTimeLayout, args.Time, time.UTC) 
 	if err != nil {
 		return CleanTick{}, fmt.Errorf("invalid time format: %s", args.Time)
 	}
 
-	cleanSymbol := args.Symbol
-	if strings.Contains(cleanSymbol, ".") {
-		parts := strings.SplitN(cleanSymbol, ".", 2) // 按第一个 "." 分割
-		cleanSymbol = parts[0] // 只取第一部分
-	}
-
 	return CleanTick{
-		Symbol:    cleanSymbol,
-		Price:     args.Bid,   // 使用 Bid
-		Volume:    1,          // 使用 Tick Volume
-		Timestamp: ts,
+		Symbol:     cleanSymbol(args.Symbol),
+		RawSymbol:  args.Symbol,
+		PlatformID: quote.Data.PlatformId,
+		Price:      args.Bid,   // 使用 Bid
+		Volume:     1,          // 使用 Tick Volume
+		Timestamp:  ts,
+		Bid:        args.Bid,
+		Ask:        args.Ask,
+		Spread:     args.Spread,
+		ReceivedAt: time.Now().UTC(),
 	}, nil
 }
 
 // 监控统计信息
-func (m *AggregatorManager) monitorStats() {
+func (m *AggregatorManager) monitorStats(ctx context.Context) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 	
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		m.statsLock.Lock()
 		if len(m.droppedTicks) > 0 {
 			log.Println("📈 === Dropped Ticks Statistics ===")
@@ -315,15 +768,29 @@ func (m *AggregatorManager) monitorStats() {
 				}
 			}
 		}
+		if len(m.sourceTicks) > 0 {
+			log.Println("📡 === Ticks per Source ===")
+			for source, count := range m.sourceTicks {
+				log.Printf("   %s: %d ticks", source, count)
+			}
+		}
 		m.statsLock.Unlock()
 		
 		m.lock.RLock()
-		log.Printf("📊 Active workers: %d symbols", len(m.Aggregators))
-		for symbol, ch := range m.Channels {
-			queueLen := len(ch)
-			if queueLen > 1000 {
-				log.Printf("   %s: queue %d/5000 (%d%%)", 
-					symbol, queueLen, queueLen*100/5000)
+		for symbol, sa := range m.Aggregators {
+			if late := sa.LateDropped(); late > 0 {
+				log.Printf("   %s: %d late ticks dropped", symbol, late)
+			}
+		}
+		for symbol, filter := range m.filters {
+			for rule, count := range filter.Counts() {
+				log.Printf("   %s: %d ticks rejected by %s filter", symbol, count, rule)
+			}
+		}
+		log.Printf("📊 Active symbols: %d (%d dormant) on %d workers", len(m.queues), len(m.dormant), len(m.shards))
+		for symbol, q := range m.queues {
+			if queueLen := q.len(); queueLen > q.size*8/10 {
+				log.Printf("   %s: queue %d/%d (%d%%)", symbol, queueLen, q.size, queueLen*100/q.size)
 			}
 		}
 		m.lock.RUnlock()
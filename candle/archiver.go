@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+
+	"shared/configfile"
+)
+
+const defaultRetentionKey = "(default)" // 没有单独配置保留期的品种
+
+// TickArchiveConfig Tick归档配置 (环境变量 TICK_ARCHIVE, JSON; 或配置节 tick_archive)
+//
+// TimescaleDB 的压缩以 chunk 为单位, 一个 chunk 内包含所有品种,
+// 因此压缩策略只能按表配置; 保留期可以按品种配置:
+// 表级保留策略取所有品种中最长的保留期, 较短的品种由定时 DELETE 清理.
+// 按行 DELETE 不能用于已压缩的 chunk, 所以这些较短的保留期不能超过 compress_after.
+type TickArchiveConfig struct {
+	BatchSize     int                            `json:"batch_size"`     // 每批 COPY 行数, 默认 5000
+	FlushInterval Duration                       `json:"flush_interval"` // 最长攒批时间, 默认 1s
+	QueueSize     int                            `json:"queue_size"`     // 内存队列长度, 默认 100000
+	Retention     Duration                       `json:"retention"`      // 默认保留期, 0 表示永久
+	CompressAfter Duration                       `json:"compress_after"` // 超过该时间的 chunk 压缩, 0 表示不压缩
+	Symbols       map[string]SymbolArchivePolicy `json:"symbols"`        // 按品种覆盖
+}
+
+// SymbolArchivePolicy 单个品种的归档策略
+type SymbolArchivePolicy struct {
+	Disabled  bool     `json:"disabled"`  // 不归档该品种
+	Retention Duration `json:"retention"` // 覆盖默认保留期
+}
+
+func loadTickArchiveConfig(f *configfile.File) (TickArchiveConfig, error) {
+	cfg := TickArchiveConfig{}
+	if _, err := f.LoadJSONEnv("TICK_ARCHIVE", &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 5000
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = Duration(time.Second)
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100000
+	}
+	if cfg.CompressAfter > 0 {
+		for symbol, r := range cfg.rowRetentions() {
+			if r > cfg.CompressAfter.Std() {
+				return cfg, fmt.Errorf("TICK_ARCHIVE: retention %s of %s exceeds compress_after %s, expired ticks would be in compressed chunks",
+					r, symbol, cfg.CompressAfter.Std())
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// retentionFor 返回品种的保留期 (0 表示永久)
+func (c TickArchiveConfig) retentionFor(symbol string) time.Duration {
+	if p, ok := c.Symbols[symbol]; ok && p.Retention > 0 {
+		return p.Retention.Std()
+	}
+	return c.Retention.Std()
+}
+
+// maxRetention 表级保留策略: 任何品种永久保留时返回 0
+func (c TickArchiveConfig) maxRetention() time.Duration {
+	longest := c.Retention.Std()
+	if longest == 0 {
+		return 0
+	}
+	for _, p := range c.Symbols {
+		if p.Retention.Std() > longest {
+			longest = p.Retention.Std()
+		}
+	}
+	return longest
+}
+
+// rowRetentions 短于表级策略、需要按行 DELETE 的保留期; 默认保留期的键为 "(default)"
+func (c TickArchiveConfig) rowRetentions() map[string]time.Duration {
+	tableRetention := c.maxRetention()
+	shorter := func(r time.Duration) bool { return r > 0 && (tableRetention == 0 || r < tableRetention) }
+	rows := make(map[string]time.Duration)
+	for symbol := range c.Symbols {
+		if r := c.retentionFor(symbol); shorter(r) {
+			rows[symbol] = r
+		}
+	}
+	if r := c.Retention.Std(); shorter(r) {
+		rows[defaultRetentionKey] = r
+	}
+	return rows
+}
+
+// TickArchiver 将所有 CleanTick 批量 COPY 到 ticks 超表
+type TickArchiver struct {
+	db      *sqlx.DB
+	cfg     TickArchiveConfig
+	queue   chan CleanTick
+	done    chan struct{} // run 结束 (剩余Tick已写入) 时关闭
+	dropped int64         // 队列满时丢弃的数量
+	written int64
+}
+
+func NewTickArchiver(db *sqlx.DB, cfg TickArchiveConfig) *TickArchiver {
+	return &TickArchiver{
+		db:    db,
+		cfg:   cfg,
+		queue: make(chan CleanTick, cfg.QueueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// Start 建表/设置策略, 然后启动写入与清理 goroutine; ctx 结束时写完队列中剩余的Tick
+func (a *TickArchiver) Start(ctx context.Context) error {
+	if err := a.ensureSchema(ctx); err != nil {
+		return err
+	}
+	go a.run(ctx)
+	go a.retentionLoop(ctx)
+	return nil
+}
+
+// Archive 非阻塞入队; 队列满时丢弃并计数, 不影响K线聚合
+func (a *TickArchiver) Archive(tick CleanTick) {
+	if p, ok := a.cfg.Symbols[tick.Symbol]; ok && p.Disabled {
+		return
+	}
+	select {
+	case a.queue <- tick:
+	default:
+		if n := atomic.AddInt64(&a.dropped, 1); n%1000 == 1 {
+			log.Printf("⚠️  Tick archive queue full, dropped %d ticks so far", n)
+		}
+	}
+}
+
+func (a *TickArchiver) ensureSchema(ctx context.Context) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ticks (
+			time        TIMESTAMPTZ NOT NULL,
+			received_at TIMESTAMPTZ NOT NULL,
+			symbol      TEXT NOT NULL,
+			source      TEXT NOT NULL,
+			bid         DOUBLE PRECISION,
+			ask         DOUBLE PRECISION,
+			spread      DOUBLE PRECISION
+		)`,
+		"SELECT create_hypertable('ticks', 'time', chunk_time_interval => INTERVAL '1 day', if_not_exists => TRUE)",
+		"CREATE INDEX IF NOT EXISTS ticks_symbol_time_idx ON ticks (symbol, time DESC)",
+	}
+	for _, stmt := range statements {
+		if _, err := a.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("tick archive schema setup failed: %w\nSQL: %s", err, stmt)
+		}
+	}
+
+	// 策略先删除再添加, 修改后的间隔在重启时生效; 压缩设置只在尚未开启时修改
+	statements = []string{
+		"SELECT remove_compression_policy('ticks', if_exists => TRUE)",
+		"SELECT remove_retention_policy('ticks', if_exists => TRUE)",
+	}
+	if a.cfg.CompressAfter > 0 {
+		var compressed bool
+		if err := a.db.GetContext(ctx, &compressed,
+			"SELECT compression_enabled FROM timescaledb_information.hypertables WHERE hypertable_name = 'ticks'"); err != nil {
+			return fmt.Errorf("tick archive schema setup failed: %w", err)
+		}
+		if !compressed {
+			statements = append(statements,
+				"ALTER TABLE ticks SET (timescaledb.compress, timescaledb.compress_segmentby = 'symbol', timescaledb.compress_orderby = 'time DESC')")
+		}
+		statements = append(statements, fmt.Sprintf("SELECT add_compression_policy('ticks', INTERVAL '%d seconds')",
+			int64(a.cfg.CompressAfter.Std().Seconds())))
+	}
+	if r := a.cfg.maxRetention(); r > 0 {
+		statements = append(statements,
+			fmt.Sprintf("SELECT add_retention_policy('ticks', INTERVAL '%d seconds')", int64(r.Seconds())))
+	}
+	for _, stmt := range statements {
+		if _, err := a.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("tick archive policy setup failed: %w\nSQL: %s", err, stmt)
+		}
+	}
+	log.Println("✅ Tick archive table ready")
+	return nil
+}
+
+// Close 等待停止后剩余的Tick写入完成 (需先结束 Start 的 ctx)
+func (a *TickArchiver) Close() error {
+	<-a.done
+	return nil
+}
+
+func (a *TickArchiver) run(ctx context.Context) {
+	defer close(a.done)
+	batch := make([]CleanTick, 0, a.cfg.BatchSize)
+	ticker := time.NewTicker(a.cfg.FlushInterval.Std())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			a.drain(batch)
+			return
+		case tick := <-a.queue:
+			batch = append(batch, tick)
+			if len(batch) >= a.cfg.BatchSize {
+				a.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				a.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// drain 停止时写入批次和队列中剩余的Tick
+func (a *TickArchiver) drain(batch []CleanTick) {
+	for {
+		select {
+		case tick := <-a.queue:
+			batch = append(batch, tick)
+			if len(batch) >= a.cfg.BatchSize {
+				a.flush(context.Background(), batch)
+				batch = batch[:0]
+			}
+		default:
+			if len(batch) > 0 {
+				a.flush(context.Background(), batch)
+			}
+			return
+		}
+	}
+}
+
+// flush 通过 COPY 写入一批Tick, 失败时重试两次后放弃
+func (a *TickArchiver) flush(ctx context.Context, batch []CleanTick) {
+	var err error
+	for attempt := 1; attempt <= 3; attempt++ {
+		if err = a.copyTicks(ctx, batch); err == nil {
+			atomic.AddInt64(&a.written, int64(len(batch)))
+			return
+		}
+		log.Printf("ERROR: Tick archive COPY failed (attempt %d/3, %d rows): %v", attempt, len(batch), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+	atomic.AddInt64(&a.dropped, int64(len(batch)))
+	log.Printf("🔴 Dropped %d archived ticks after repeated COPY failures: %v", len(batch), err)
+}
+
+func (a *TickArchiver) copyTicks(ctx context.Context, batch []CleanTick) error {
+	rows := make([][]interface{}, len(batch))
+	for i, t := range batch {
+		rows[i] = []interface{}{t.Timestamp, t.ReceivedAt, t.Symbol, t.Source, t.Bid, t.Ask, t.Spread}
+	}
+
+	conn, err := a.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		_, err := pgConn.CopyFrom(ctx,
+			pgx.Identifier{"ticks"},
+			[]string{"time", "received_at", "symbol", "source", "bid", "ask", "spread"},
+			pgx.CopyFromRows(rows),
+		)
+		return err
+	})
+}
+
+// retentionLoop 每小时清理保留期短于表级策略的品种
+func (a *TickArchiver) retentionLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		for symbol, r := range a.cfg.rowRetentions() {
+			if symbol == defaultRetentionKey {
+				a.deleteDefaultOlderThan(ctx, r)
+			} else {
+				a.deleteOlderThan(ctx, symbol, r)
+			}
+		}
+
+		log.Printf("📦 Tick archive: %d written, %d dropped", atomic.LoadInt64(&a.written), atomic.LoadInt64(&a.dropped))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *TickArchiver) deleteOlderThan(ctx context.Context, symbol string, retention time.Duration) {
+	res, err := a.db.ExecContext(ctx, "DELETE FROM ticks WHERE symbol = $1 AND time < $2",
+		symbol, time.Now().Add(-retention))
+	logRetention(symbol, res, err)
+}
+
+// deleteDefaultOlderThan 清理没有单独配置保留期的品种
+func (a *TickArchiver) deleteDefaultOlderThan(ctx context.Context, retention time.Duration) {
+	custom := make([]string, 0, len(a.cfg.Symbols))
+	for symbol, p := range a.cfg.Symbols {
+		if p.Retention > 0 {
+			custom = append(custom, symbol)
+		}
+	}
+	// 追加一个空字符串, 避免 NOT IN () 语法错误
+	query, args, err := sqlx.In("DELETE FROM ticks WHERE time < ? AND symbol NOT IN (?)",
+		time.Now().Add(-retention), append(custom, ""))
+	if err != nil {
+		log.Printf("ERROR: Tick retention query: %v", err)
+		return
+	}
+	res, err := a.db.ExecContext(ctx, a.db.Rebind(query), args...)
+	logRetention(defaultRetentionKey, res, err)
+}
+
+func logRetention(symbol string, res sql.Result, err error) {
+	if err != nil {
+		log.Printf("ERROR: Tick retention cleanup for %s failed: %v", symbol, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("🧹 Tick retention: removed %d old ticks for %s", n, symbol)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "shared/calendar"
+
+// 缺失K线的处理方式
+const (
+	GapFill      = "fill"      // 用上一根收盘价补平K线
+	GapSkip      = "skip"      // 不补
+	GapSynthetic = "synthetic" // 补平K线并标记 synthetic=true
+)
+
+// CalendarConfig 品种的交易日历 (节假日, 每日休市), 定义见 shared/calendar
+type CalendarConfig = calendar.CalendarConfig
+
+// TradingCalendar 判断某一时刻是否可交易: 交易时段/周末 + 节假日 + 每日休市
+type TradingCalendar = calendar.Calendar
+
+// NewTradingCalendar 组合交易时段与日历配置; 两者都为空时返回 nil (全天候交易)
+func NewTradingCalendar(session *Session, cfg *CalendarConfig) (*TradingCalendar, error) {
+	var hours *calendar.Hours
+	if session != nil {
+		hours = session.hours
+	}
+	return calendar.New(hours, cfg)
+}
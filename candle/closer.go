@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// 已闭合K线收到迟到Tick时的处理方式
+const (
+	LateAmend = "amend" // 修正已闭合K线并发布 AMEND
+	LateDrop  = "drop"  // 丢弃并计数
+)
+
+const (
+	defaultCloseGrace  = 2 * time.Second        // 窗口结束后的默认宽限期
+	defaultMaxLateness = time.Minute            // K线结束后默认接受迟到Tick的时长
+	closeCheckPeriod   = 250 * time.Millisecond // 定时关闭的检查间隔
+)
+
+// RunCloseScheduler 按墙上时钟定期检查所有品种, 在窗口结束 + 宽限期后关闭K线,
+// 安静行情下 CLOSE 事件不必等到下一个Tick才发出. 阻塞直到 ctx 结束
+func (m *AggregatorManager) RunCloseScheduler(ctx context.Context) {
+	ticker := time.NewTicker(closeCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.CloseDue(now)
+		}
+	}
+}
+
+// CloseDue 关闭所有已到期的K线 (包括已被回收品种保存在 StateStore 中的K线)
+func (m *AggregatorManager) CloseDue(now time.Time) {
+	m.wakeDormant(now)
+
+	m.lock.RLock()
+	aggregators := make([]*SymbolAggregator, 0, len(m.Aggregators))
+	for _, sa := range m.Aggregators {
+		aggregators = append(aggregators, sa)
+	}
+	m.lock.RUnlock()
+
+	for _, sa := range aggregators {
+		sa.CloseDue(now)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// recordingPublisher 记录所有发布的事件
+type recordingPublisher struct {
+	events []PublishEvent
+}
+
+func (p *recordingPublisher) Publish(channel string, event PublishEvent) {
+	p.events = append(p.events, event)
+}
+
+func (p *recordingPublisher) closed() []Candle { return p.withStatus("CLOSE") }
+
+func (p *recordingPublisher) withStatus(status string) []Candle {
+	var result []Candle
+	for _, e := range p.events {
+		if e.Status == status {
+			result = append(result, e.Candle)
+		}
+	}
+	return result
+}
+
+func newTestM1(t *testing.T, late string) (*TimeframeAggregator, *recordingPublisher) {
+	t.Helper()
+	m1, _ := ParseTimeframe("M1")
+	cfg := SymbolConfigs{"*": {LateTicks: late}}.For("XAUUSD")
+	pub := &recordingPublisher{}
+	return NewTimeframeAggregator("XAUUSD", PriceBid, m1, cfg, pub), pub
+}
+
+func testTick(t *testing.T, ts string, bid float64) CleanTick {
+	return CleanTick{Symbol: "XAUUSD", Price: bid, Bid: bid, Volume: 1, Timestamp: mustTime(t, ts)}
+}
+
+func TestCloseDueWaitsForGrace(t *testing.T) {
+	agg, pub := newTestM1(t, LateAmend)
+	agg.ProcessTick(testTick(t, "2025-11-24T10:00:30Z", 2000))
+
+	agg.CloseDue(mustTime(t, "2025-11-24T10:01:01Z"))
+	if n := len(pub.closed()); n != 0 {
+		t.Fatalf("closed %d candles before grace period elapsed", n)
+	}
+
+	agg.CloseDue(mustTime(t, "2025-11-24T10:01:02Z"))
+	closed := pub.closed()
+	if len(closed) != 1 || !closed[0].StartTime.Equal(mustTime(t, "2025-11-24T10:00:00Z")) {
+		t.Fatalf("want one CLOSE for 10:00, got %+v", closed)
+	}
+
+	// 已关闭后再次检查不应重复发布
+	agg.CloseDue(mustTime(t, "2025-11-24T10:05:00Z"))
+	if n := len(pub.closed()); n != 1 {
+		t.Fatalf("want 1 CLOSE after repeated check, got %d", n)
+	}
+}
+
+func TestLateTickPolicies(t *testing.T) {
+	amend, amendPub := newTestM1(t, LateAmend)
+	drop, dropPub := newTestM1(t, LateDrop)
+	for _, agg := range []*TimeframeAggregator{amend, drop} {
+		agg.ProcessTick(testTick(t, "2025-11-24T10:00:30Z", 2000))
+		agg.CloseDue(mustTime(t, "2025-11-24T10:01:05Z"))
+		agg.ProcessTick(testTick(t, "2025-11-24T10:00:59Z", 2005))
+	}
+
+	amended := amendPub.withStatus("AMEND")
+	if len(amended) != 1 || amended[0].High != 2005 || amended[0].Close != 2005 || amended[0].TickCount != 2 {
+		t.Fatalf("amend: want AMEND with late tick applied, got %+v", amended)
+	}
+	if n := len(amendPub.closed()); n != 1 {
+		t.Fatalf("amend: want 1 CLOSE, got %d", n)
+	}
+	if n := len(dropPub.closed()) + len(dropPub.withStatus("AMEND")); n != 1 {
+		t.Fatalf("drop: want only the original CLOSE, got %d events", n)
+	}
+	if n := drop.LateDropped(); n != 1 {
+		t.Fatalf("drop: want 1 late tick dropped, got %d", n)
+	}
+}
+
+func TestTickAfterTimerCloseFillsGap(t *testing.T) {
+	agg, pub := newTestM1(t, LateAmend)
+	agg.ProcessTick(testTick(t, "2025-11-24T10:00:30Z", 2000))
+	agg.CloseDue(mustTime(t, "2025-11-24T10:01:05Z"))
+	agg.ProcessTick(testTick(t, "2025-11-24T10:02:10Z", 2001))
+
+	closed := pub.closed()
+	if len(closed) != 2 {
+		t.Fatalf("want CLOSE for 10:00 and filled 10:01, got %+v", closed)
+	}
+	if filled := closed[1]; !filled.StartTime.Equal(mustTime(t, "2025-11-24T10:01:00Z")) || filled.Close != 2000 {
+		t.Fatalf("unexpected filled bar %+v", filled)
+	}
+	if len(pub.events) == 0 || pub.events[len(pub.events)-1].Status != "UPDATE" {
+		t.Fatalf("want new candle opened with UPDATE")
+	}
+}
+
+func TestSymbolCloseDueUsesSourceClock(t *testing.T) {
+	pub := &recordingPublisher{}
+	cfg := SymbolConfigs{"*": {Timeframes: []string{"M1"}}}.For("XAUUSD")
+	sa := NewSymbolAggregator("XAUUSD", cfg, pub)
+
+	// 行情源时间比本机快2小时 (经纪商服务器时区)
+	received := mustTime(t, "2025-11-24T08:00:30Z")
+	tick := testTick(t, "2025-11-24T10:00:30Z", 2000)
+	tick.ReceivedAt = received
+	sa.ProcessTick(tick)
+
+	sa.CloseDue(received.Add(20 * time.Second))
+	if n := len(pub.closed()); n != 0 {
+		t.Fatalf("closed %d candles too early", n)
+	}
+	sa.CloseDue(received.Add(35 * time.Second))
+	if n := len(pub.closed()); n != 1 {
+		t.Fatalf("want 1 CLOSE, got %d", n)
+	}
+}
+
+func TestLateTickBeyondLatenessIsDropped(t *testing.T) {
+	agg, pub := newTestM1(t, LateAmend)
+	agg.ProcessTick(testTick(t, "2025-11-24T10:00:30Z", 2000))
+	agg.ProcessTick(testTick(t, "2025-11-24T10:01:10Z", 2001))
+	agg.ProcessTick(testTick(t, "2025-11-24T10:02:30Z", 2002))
+
+	// 10:00 结束于 10:01, 默认容忍 1m, 水位 10:02:30 已超出
+	agg.ProcessTick(testTick(t, "2025-11-24T10:00:50Z", 1990))
+	// 10:01 仍在容忍窗口内
+	agg.ProcessTick(testTick(t, "2025-11-24T10:01:20Z", 2010))
+
+	amended := pub.withStatus("AMEND")
+	if len(amended) != 1 || !amended[0].StartTime.Equal(mustTime(t, "2025-11-24T10:01:00Z")) {
+		t.Fatalf("want one AMEND for 10:01, got %+v", amended)
+	}
+	if amended[0].Close != 2010 || amended[0].High != 2010 {
+		t.Fatalf("unexpected amended candle %+v", amended[0])
+	}
+	if n := agg.LateDropped(); n != 1 {
+		t.Fatalf("want 1 late tick dropped, got %d", n)
+	}
+}
+
+func TestExplicitZeroGraceAndLatenessAreKept(t *testing.T) {
+	zero, grace := Duration(0), Duration(5*time.Second)
+	configs := SymbolConfigs{
+		"*":      {CloseGrace: &grace},
+		"XAUUSD": {CloseGrace: &zero, MaxLateness: &zero},
+	}
+	cfg := configs.For("XAUUSD")
+	if cfg.CloseGrace.Std() != 0 || cfg.MaxLateness.Std() != 0 {
+		t.Fatalf("want explicit zeros kept, got grace %s lateness %s", cfg.CloseGrace.Std(), cfg.MaxLateness.Std())
+	}
+	if cfg = configs.For("EURUSD"); cfg.CloseGrace.Std() != 5*time.Second || cfg.MaxLateness.Std() != defaultMaxLateness {
+		t.Fatalf("want defaults for other symbols, got grace %s lateness %s", cfg.CloseGrace.Std(), cfg.MaxLateness.Std())
+	}
+}
+
+func TestLateTickRebuildsFilledBar(t *testing.T) {
+	agg, pub := newTestM1(t, LateAmend)
+	agg.ProcessTick(testTick(t, "2025-11-24T10:00:30Z", 2000))
+	agg.ProcessTick(testTick(t, "2025-11-24T10:02:10Z", 2003))
+	agg.ProcessTick(testTick(t, "2025-11-24T10:01:40Z", 2007))
+
+	amended := pub.withStatus("AMEND")
+	if len(amended) != 1 {
+		t.Fatalf("want one AMEND, got %+v", amended)
+	}
+	c := amended[0]
+	if c.Open != 2007 || c.Low != 2007 || c.TickCount != 1 || c.Synthetic {
+		t.Fatalf("filled bar not rebuilt from late tick: %+v", c)
+	}
+}
+
+func TestOutOfOrderTickKeepsClose(t *testing.T) {
+	agg, pub := newTestM1(t, LateAmend)
+	agg.ProcessTick(testTick(t, "2025-11-24T10:00:30Z", 2000))
+	agg.ProcessTick(testTick(t, "2025-11-24T10:00:40Z", 2001))
+	agg.ProcessTick(testTick(t, "2025-11-24T10:00:10Z", 1995))
+
+	last := pub.events[len(pub.events)-1].Candle
+	if last.Open != 1995 || last.Close != 2001 || last.Low != 1995 {
+		t.Fatalf("want open from earliest and close from latest tick, got %+v", last)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+
+	"shared/configfile"
+)
+
+// 配置文件的格式、${NAME} 引用和环境变量覆盖规则见 shared/configfile, 与 db 服务相同
+type (
+	Duration       = configfile.Duration
+	RedisConfig    = configfile.RedisConfig
+	PostgresConfig = configfile.PostgresConfig
+	LogConfig      = configfile.LogConfig
+)
+
+// configSections 配置文件的节名 -> 对应的环境变量; 为空表示只能用字段级的变量覆盖 (env 标签)
+var configSections = map[string]string{
+	"redis":                "",
+	"postgres":             "",
+	"log":                  "",
+	"admin":                "",
+	"upstream":             "TICK_SOURCES",
+	"symbols":              "SYMBOL_CONFIG",
+	"timeframes":           "TIMEFRAMES",
+	"symbol_mapping":       "SYMBOL_MAPPING",
+	"output":               "KLINE_OUTPUT",
+	"worker_pool":          "WORKER_POOL",
+	"tick_archive":         "TICK_ARCHIVE",
+	"shutdown_timeout":     "SHUTDOWN_TIMEOUT",
+	"persist_open_candles": "PERSIST_OPEN_CANDLES",
+}
+
+// Config candle 服务的全部配置, 启动时一次性读取并校验
+type Config struct {
+	Redis           RedisConfig         `json:"redis"`
+	Postgres        PostgresConfig      `json:"postgres"` // 可选, 用于Tick归档、品种映射和重启时核对K线
+	Log             LogConfig           `json:"log"`
+	Admin           AdminConfig         `json:"admin"`
+	Upstream        []SourceConfig      `json:"upstream"`
+	Symbols         SymbolConfigs       `json:"symbols"`
+	Timeframes      []string            `json:"timeframes"` // 品种未配置周期时使用, 默认 DefaultTimeframes
+	SymbolMapping   SymbolMappingConfig `json:"symbol_mapping"`
+	Output          PublishConfig       `json:"output"`
+	WorkerPool      WorkerPoolConfig    `json:"worker_pool"`
+	TickArchive     TickArchiveConfig   `json:"tick_archive"`
+	ShutdownTimeout Duration            `json:"shutdown_timeout"`
+	// 停机时保留未闭合的K线, 重启后恢复 (默认); false 时停机清除, 重启后从新Tick开始
+	PersistOpenCandles bool `json:"persist_open_candles"`
+}
+
+// AdminConfig 管理接口 (配置节 admin)
+type AdminConfig struct {
+	Addr  string `json:"addr" env:"ADMIN_ADDR"`   // 默认 127.0.0.1:9108; 监听其它地址时应设置 token
+	Token string `json:"token" env:"ADMIN_TOKEN"` // 设置后修改类请求需要带 "Authorization: Bearer <token>"
+}
+
+// loadConfig 读取配置文件 (path 为空时只用环境变量) 并校验全部配置
+func loadConfig(path string) (*Config, error) {
+	f, err := configfile.Load(path, configSections)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if cfg.Redis, err = f.LoadRedis(); err != nil {
+		return nil, err
+	}
+	if cfg.Postgres, err = f.LoadPostgres(); err != nil {
+		return nil, err
+	}
+	if cfg.Log, err = f.LoadLog(); err != nil {
+		return nil, err
+	}
+	if err = f.LoadSection("admin", &cfg.Admin); err != nil {
+		return nil, err
+	}
+	if cfg.Admin.Addr == "" {
+		cfg.Admin.Addr = defaultAdminAddr
+	}
+
+	// 周期默认值需在品种配置之前读取
+	if _, err := f.LoadJSONEnv("TIMEFRAMES", &cfg.Timeframes); err != nil {
+		return nil, err
+	}
+	if len(cfg.Timeframes) == 0 {
+		cfg.Timeframes = DefaultTimeframes
+	}
+	if _, err := ParseTimeframes(cfg.Timeframes); err != nil {
+		return nil, fmt.Errorf("timeframes: %w", err)
+	}
+
+	if cfg.Upstream, err = loadSourceConfigs(f); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Upstream {
+		if src := &cfg.Upstream[i]; src.Type == "redis_stream" && src.Addr == "" {
+			src.Addr, src.Password = cfg.Redis.Addr, cfg.Redis.Password
+		}
+	}
+	if cfg.Symbols, err = loadSymbolConfigs(f, cfg.Timeframes); err != nil {
+		return nil, err
+	}
+	if cfg.SymbolMapping, err = loadSymbolMappingConfig(f); err != nil {
+		return nil, err
+	}
+	if cfg.Output, err = loadPublishConfig(f); err != nil {
+		return nil, err
+	}
+	if cfg.WorkerPool, err = loadWorkerPoolConfig(f); err != nil {
+		return nil, err
+	}
+	if cfg.TickArchive, err = loadTickArchiveConfig(f); err != nil {
+		return nil, err
+	}
+	shutdownTimeout, err := loadShutdownTimeout(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+	}
+	cfg.ShutdownTimeout = Duration(shutdownTimeout)
+	cfg.PersistOpenCandles = true
+	if _, err := f.LoadJSONEnv("PERSIST_OPEN_CANDLES", &cfg.PersistOpenCandles); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"shared/configfile"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadTestConfig 清空会覆盖配置文件的环境变量后读取配置
+func loadTestConfig(t *testing.T, path string) (*Config, error) {
+	t.Helper()
+	for _, key := range []string{"REDIS_ADDR", "REDIS_PASSWORD", "DATABASE_URL", "PG_HOST", "PG_PASSWORD", "ADMIN_TOKEN",
+		"TICK_SOURCES", "SYMBOL_CONFIG", "TIMEFRAMES", "SHUTDOWN_TIMEOUT"} {
+		t.Setenv(key, "")
+	}
+	return loadConfig(path)
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	cfg, err := loadTestConfig(t, "config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Redis.Addr != "localhost:6379" || len(cfg.Upstream) != 2 || cfg.Upstream[1].Group != "primary" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if cfg.Postgres.DSN() != "postgres://kline@localhost:5432/kline?sslmode=disable" {
+		t.Fatalf("unexpected dsn %s", cfg.Postgres.DSN())
+	}
+	if cfg.TickArchive.Retention.Std() != 30*24*time.Hour || cfg.ShutdownTimeout.Std() != 30*time.Second {
+		t.Fatalf("unexpected durations %+v", cfg)
+	}
+	if xau := cfg.Symbols.For("XAUUSD"); len(xau.PriceSeries) != 2 || len(xau.Timeframes) != 7 {
+		t.Fatalf("unexpected XAUUSD config %+v", xau)
+	}
+}
+
+func TestConfigEnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, "candle.toml", `
+timeframes = ["M1", "H1"]
+
+[redis]
+addr = "redis:6379"
+password = "${TEST_REDIS_SECRET}"
+
+[[upstream]]
+name = "bridge"
+type = "redis_stream"
+stream = "ticks"
+`)
+	t.Setenv("TEST_REDIS_SECRET", "s3cret")
+	cfg, err := loadTestConfig(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Redis.Password != "s3cret" || cfg.Upstream[0].Addr != "redis:6379" || cfg.Upstream[0].Password != "s3cret" {
+		t.Fatalf("want redis settings from the file, got %+v / %+v", cfg.Redis, cfg.Upstream[0])
+	}
+	if cfg.Symbols.For("EURUSD").Timeframes[1] != "H1" {
+		t.Fatal("want symbols to default to the configured timeframes")
+	}
+	if cfg.Postgres.Configured() {
+		t.Fatal("want no database unless configured")
+	}
+
+	// 环境变量优先: 单个字段覆盖, JSON 变量整节替换 (通用规则的测试见 shared/configfile)
+	t.Setenv("REDIS_ADDR", "10.0.0.5:6379")
+	t.Setenv("TICK_SOURCES", `[{"name": "csv", "type": "file", "path": "ticks.csv"}]`)
+	if cfg, err = loadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Redis.Addr != "10.0.0.5:6379" || cfg.Redis.Password != "s3cret" || cfg.Upstream[0].Name != "csv" {
+		t.Fatalf("want env overrides, got %+v / %+v", cfg.Redis, cfg.Upstream)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	const upstream = "upstream:\n  - {name: csv, type: file, path: ticks.csv}\n"
+	for name, content := range map[string]string{
+		"unknown section":            upstream + "reddis:\n  addr: localhost:6379\n",
+		"bad timeframe":              upstream + "timeframes: [M1, Q1]\n",
+		"bad symbol":                 upstream + "symbols:\n  XAUUSD:\n    gap_fill: guess\n",
+		"retention past compression": upstream + "tick_archive:\n  retention: 30d\n  compress_after: 1d\n  symbols:\n    XAUUSD: {retention: 7d}\n",
+		"no upstream":                "redis:\n  addr: localhost:6379\n",
+		"empty upstream":             "upstream: []\n",
+		"bad source type":            "upstream:\n  - {name: mt4, type: tcp}\n",
+		"source without url":         "upstream:\n  - {name: mt4, type: ws}\n",
+		"bad source timing":          "upstream:\n  - {name: mt4, type: ws, url: \"ws://bridge\", read_timeout: 5s, ping_interval: 10s}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadTestConfig(t, writeConfigFile(t, "candle.yaml", content)); err == nil {
+				t.Fatal("want error")
+			}
+		})
+	}
+}
+
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	path := writeConfigFile(t, "candle.yaml", `
+redis: {password: hunter2}
+postgres: {url: "postgres://kline:c75scFhGrbie@db:5432/kline"}
+admin: {token: admin-token}
+upstream:
+  - {name: mt4, type: ws, url: "ws://bridge:8088/event?id={account}&token=abc123", account: "6"}
+`)
+	cfg, err := loadTestConfig(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := configfile.Print(&out, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "c75scFhGrbie", "admin-token", "abc123"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("secret %q not redacted:\n%s", secret, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "url: postgres://kline:xxxxx@db:5432/kline\n") {
+		t.Errorf("want the database url with the password redacted:\n%s", out.String())
+	}
+
+	// 输出可以直接作为配置文件使用
+	printed, err := loadTestConfig(t, writeConfigFile(t, "printed.yaml", out.String()))
+	if err != nil {
+		t.Fatalf("printed config does not load: %v", err)
+	}
+	if printed.Upstream[0].Account != "6" || printed.Redis.Password != "xxxxx" {
+		t.Fatalf("unexpected round trip %+v", printed)
+	}
+}
+
+func TestTickArchiveRowRetentions(t *testing.T) {
+	cfg := TickArchiveConfig{
+		Retention:     Duration(30 * 24 * time.Hour),
+		CompressAfter: Duration(7 * 24 * time.Hour),
+		Symbols: map[string]SymbolArchivePolicy{
+			"XAUUSD": {Retention: Duration(3 * 24 * time.Hour)},
+			"EURUSD": {Retention: Duration(90 * 24 * time.Hour)},
+		},
+	}
+	// 表级策略取最长的 90 天, 默认的 30 天超过了压缩时间
+	rows := cfg.rowRetentions()
+	if len(rows) != 2 || rows["XAUUSD"] != 3*24*time.Hour || rows[defaultRetentionKey] != 30*24*time.Hour {
+		t.Fatalf("unexpected row retentions %v", rows)
+	}
+}
+
+func TestTimeframesDoNotLeakBetweenLoads(t *testing.T) {
+	const upstream = "upstream:\n  - {name: csv, type: file, path: ticks.csv}\n"
+	if _, err := loadTestConfig(t, writeConfigFile(t, "first.yaml", upstream+"timeframes: [M1]\n")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadTestConfig(t, writeConfigFile(t, "second.yaml", upstream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Symbols.For("XAUUSD").Timeframes; len(got) != len(DefaultTimeframes) {
+		t.Fatalf("want the default timeframes after reloading, got %v", got)
+	}
+}
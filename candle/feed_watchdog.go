@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const feedStatusChannel = "feed_status" // Redis Pub/Sub: 行情源状态变化
+
+// 行情源状态
+const (
+	FeedConnected    = "connected"
+	FeedDisconnected = "disconnected"
+	FeedStale        = "stale"     // 交易时段内超过 stale_after 没有报价
+	FeedLive         = "live"      // 过期的品种重新收到报价
+	FeedReconnecting = "reconnect" // 因报价过期强制重连
+)
+
+// 报价过期时是否强制重连 (SourceConfig.StaleReconnect)
+const (
+	StaleReconnectAll   = "all"   // 交易时段内的品种全部过期时重连 (默认)
+	StaleReconnectAny   = "any"   // 任一品种过期即重连 (如上游单独丢失了某个订阅)
+	StaleReconnectNever = "never" // 只发布状态事件
+)
+
+var errFeedStale = errors.New("no quotes within stale_after during market hours")
+
+// FeedStatus 行情源状态变化事件
+type FeedStatus struct {
+	Source   string    `json:"source"`
+	Symbol   string    `json:"symbol,omitempty"` // 为空表示整个行情源
+	Status   string    `json:"status"`
+	Reason   string    `json:"reason,omitempty"`
+	LastTick time.Time `json:"last_tick,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+func (s FeedStatus) ToJSON() []byte {
+	b, _ := json.Marshal(s)
+	return b
+}
+
+// FeedMonitor 行情源健康检查的外部依赖, 由 NewTickSources 传给每个源; 同时记录各个源的连接状态
+type FeedMonitor struct {
+	IsOpen func(symbol string, t time.Time) bool // 品种在 t 时刻是否处于交易时段, 为空表示全天候
+	Notify func(FeedStatus)                      // 可选: 状态变化通知
+
+	mu        sync.Mutex
+	connected map[string]bool // source -> 会话是否已连接
+}
+
+// setConnected 记录行情源的连接状态, 并同步到连接状态指标
+func (m *FeedMonitor) setConnected(source string, connected bool) {
+	m.mu.Lock()
+	if m.connected == nil {
+		m.connected = make(map[string]bool)
+	}
+	m.connected[source] = connected
+	m.mu.Unlock()
+	value := 0.0
+	if connected {
+		value = 1
+	}
+	metrics.sourceConnected.Set(value, source)
+}
+
+func (m *FeedMonitor) isConnected(source string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.connected[source]
+}
+
+// Connected 返回每个行情源当前是否已连接
+func (m *FeedMonitor) Connected() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make(map[string]bool, len(m.connected))
+	for source, connected := range m.connected {
+		result[source] = connected
+	}
+	return result
+}
+
+// feedWatchdog 单个行情源的报价过期检测
+//
+// 记录每个品种最近一次报价的时间, 交易时段内超过 staleAfter 没有报价的品种标记为过期;
+// 按 StaleReconnect 决定是否取消当前会话让 runWithReconnect 重连.
+// 交易时段内的品种全部过期时整个源视为不可用 (down), 故障转移组会切换到备用源,
+// 直到该源重新收到任意报价.
+type feedWatchdog struct {
+	source     string
+	staleAfter time.Duration // 0 表示不检测
+	reconnect  string
+	monitor    *FeedMonitor
+
+	mu           sync.Mutex
+	symbols      map[string]*symbolFeed
+	sessionStart time.Time
+	down         bool
+}
+
+type symbolFeed struct {
+	lastTick time.Time
+	stale    bool
+}
+
+func newFeedWatchdog(cfg SourceConfig, monitor *FeedMonitor) *feedWatchdog {
+	if monitor == nil {
+		monitor = &FeedMonitor{}
+	}
+	w := &feedWatchdog{
+		source:     cfg.Name,
+		staleAfter: cfg.StaleAfter.Std(),
+		reconnect:  cfg.StaleReconnect,
+		monitor:    monitor,
+		symbols:    make(map[string]*symbolFeed),
+	}
+	if w.reconnect == "" {
+		w.reconnect = StaleReconnectAll
+	}
+	// 订阅的品种从一开始就受检测, 即使从未收到过报价
+	if cfg.Subscription != nil {
+		for _, symbol := range cfg.Subscription.Symbols {
+			w.symbols[cleanSymbol(symbol)] = &symbolFeed{}
+		}
+	}
+	return w
+}
+
+func (w *feedWatchdog) notify(symbol, status, reason string, lastTick time.Time) {
+	if w == nil || w.monitor.Notify == nil {
+		return
+	}
+	w.monitor.Notify(FeedStatus{
+		Source: w.source, Symbol: symbol, Status: status, Reason: reason,
+		LastTick: lastTick, Time: time.Now().UTC(),
+	})
+}
+
+func (w *feedWatchdog) isOpen(symbol string, t time.Time) bool {
+	return w.monitor.IsOpen == nil || w.monitor.IsOpen(symbol, t)
+}
+
+// wrap 返回记录报价时间的 sink
+func (w *feedWatchdog) wrap(sink TickSink) TickSink {
+	if w == nil {
+		return sink
+	}
+	return func(tick CleanTick) {
+		w.seen(tick.Symbol, time.Now())
+		sink(tick)
+	}
+}
+
+func (w *feedWatchdog) seen(symbol string, now time.Time) {
+	w.mu.Lock()
+	w.down = false
+	f, ok := w.symbols[symbol]
+	if !ok {
+		f = &symbolFeed{}
+		w.symbols[symbol] = f
+	}
+	recovered := f.stale
+	last := f.lastTick
+	f.lastTick, f.stale = now, false
+	w.mu.Unlock()
+
+	if recovered {
+		log.Printf("✅ [%s] Quotes for %s resumed", w.source, symbol)
+		w.notify(symbol, FeedLive, "", last)
+	}
+}
+
+// setConnected 记录本源会话的连接状态
+func (w *feedWatchdog) setConnected(connected bool) {
+	if w != nil {
+		w.monitor.setConnected(w.source, connected)
+	}
+}
+
+// isConnected 本源当前是否有已连接的会话
+func (w *feedWatchdog) isConnected() bool {
+	return w != nil && w.monitor.isConnected(w.source)
+}
+
+// isDown 交易时段内的品种全部过期, 且之后还没有收到任何报价
+func (w *feedWatchdog) isDown() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.down
+}
+
+// check 标记过期的品种, 返回是否需要重连.
+// 只有 [now-staleAfter, now] 两端都在交易时段内的品种参与判断, 避免休市刚结束就误报;
+// 会话开始后的 staleAfter 内视为宽限期
+func (w *feedWatchdog) check(now time.Time) bool {
+	if w == nil || w.staleAfter <= 0 {
+		return false
+	}
+	type staleSymbol struct {
+		symbol   string
+		lastTick time.Time
+	}
+	var newlyStale []staleSymbol
+	open, stale := 0, 0
+
+	w.mu.Lock()
+	for symbol, f := range w.symbols {
+		if !w.isOpen(symbol, now) || !w.isOpen(symbol, now.Add(-w.staleAfter)) {
+			continue
+		}
+		open++
+		since := f.lastTick
+		if since.Before(w.sessionStart) {
+			since = w.sessionStart
+		}
+		if now.Sub(since) < w.staleAfter {
+			continue
+		}
+		stale++
+		if !f.stale {
+			f.stale = true
+			newlyStale = append(newlyStale, staleSymbol{symbol, f.lastTick})
+		}
+	}
+	allStale := open > 0 && stale == open
+	if allStale {
+		w.down = true
+	}
+	w.mu.Unlock()
+
+	for _, s := range newlyStale {
+		log.Printf("⚠️  [%s] No quotes for %s in %s", w.source, s.symbol, w.staleAfter)
+		w.notify(s.symbol, FeedStale, fmt.Sprintf("no quotes in %s", w.staleAfter), s.lastTick)
+	}
+	switch w.reconnect {
+	case StaleReconnectAny:
+		return stale > 0
+	case StaleReconnectNever:
+		return false
+	default:
+		return allStale
+	}
+}
+
+// watch 在会话期间定期检查, 需要重连时以 errFeedStale 取消会话; 返回的函数停止检查
+func (w *feedWatchdog) watch(ctx context.Context, cancel context.CancelCauseFunc) (stop func()) {
+	if w == nil || w.staleAfter <= 0 {
+		return func() {}
+	}
+	w.mu.Lock()
+	w.sessionStart = time.Now()
+	w.mu.Unlock()
+
+	period := w.staleAfter / 4
+	if period < time.Second {
+		period = time.Second
+	}
+	if period > 30*time.Second {
+		period = 30 * time.Second
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if w.check(now) {
+					w.notify("", FeedReconnecting, errFeedStale.Error(), time.Time{})
+					cancel(errFeedStale)
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestWatchdog(reconnect string, isOpen func(string, time.Time) bool) (*feedWatchdog, *[]FeedStatus) {
+	var events []FeedStatus
+	monitor := &FeedMonitor{IsOpen: isOpen, Notify: func(s FeedStatus) { events = append(events, s) }}
+	cfg := SourceConfig{Name: "wd-test", StaleAfter: Duration(time.Minute), StaleReconnect: reconnect}
+	return newFeedWatchdog(cfg, monitor), &events
+}
+
+func TestWatchdogMarksStaleSymbols(t *testing.T) {
+	w, events := newTestWatchdog(StaleReconnectAll, nil)
+	start := time.Now()
+	w.seen("XAUUSD", start)
+	w.seen("EURUSD", start)
+
+	if w.check(start.Add(30 * time.Second)) {
+		t.Fatal("want no reconnect before stale_after")
+	}
+	w.seen("EURUSD", start.Add(45*time.Second))
+	if w.check(start.Add(90*time.Second)) || w.isDown() {
+		t.Fatal("want no reconnect while EURUSD still quotes")
+	}
+	if len(*events) != 1 || (*events)[0].Symbol != "XAUUSD" || (*events)[0].Status != FeedStale {
+		t.Fatalf("want XAUUSD reported stale, got %+v", *events)
+	}
+	if !w.check(start.Add(2*time.Minute)) || !w.isDown() {
+		t.Fatal("want reconnect and feed down once every symbol is stale")
+	}
+
+	w.seen("XAUUSD", start.Add(3*time.Minute))
+	last := (*events)[len(*events)-1]
+	if w.isDown() || last.Symbol != "XAUUSD" || last.Status != FeedLive {
+		t.Fatalf("want XAUUSD live and feed up after a quote, got %+v", last)
+	}
+}
+
+func TestWatchdogIgnoresClosedMarket(t *testing.T) {
+	closedUntil := time.Now().Add(time.Hour)
+	w, events := newTestWatchdog(StaleReconnectAny, func(symbol string, t time.Time) bool {
+		return !t.Before(closedUntil)
+	})
+	w.seen("XAUUSD", time.Now().Add(-24*time.Hour))
+
+	if w.check(closedUntil.Add(30 * time.Second)) {
+		t.Fatal("want no reconnect right after the market opens")
+	}
+	if !w.check(closedUntil.Add(2 * time.Minute)) {
+		t.Fatal("want reconnect once the market has been open for stale_after")
+	}
+	if len(*events) != 1 {
+		t.Fatalf("want one stale event, got %+v", *events)
+	}
+}
+
+func TestRunWithReconnectOnStaleFeed(t *testing.T) {
+	w, _ := newTestWatchdog(StaleReconnectAll, nil)
+	w.staleAfter = 20 * time.Millisecond
+	w.seen("XAUUSD", time.Now())
+	cfg := SourceConfig{Name: "wd-test", RetryDelay: Duration(time.Millisecond), MaxRetryDelay: Duration(time.Millisecond), watchdog: w}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sessions := 0
+	runWithReconnect(ctx, cfg, func(CleanTick) {}, func(sessionCtx context.Context, sink TickSink) error {
+		sessions++
+		<-sessionCtx.Done()
+		if !errors.Is(context.Cause(sessionCtx), errFeedStale) {
+			t.Errorf("want session cancelled as stale, got %v", context.Cause(sessionCtx))
+		}
+		if sessions == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if sessions != 2 {
+		t.Fatalf("want the stale session retried, got %d sessions", sessions)
+	}
+}
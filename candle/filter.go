@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// 过滤规则名称 (计数和拒绝通知中使用)
+const (
+	RuleCrossed   = "crossed"    // 卖价低于买价
+	RuleJumpPct   = "jump_pct"   // 相对上一笔跳动超过百分比
+	RuleJumpSigma = "jump_sigma" // 跳动超过 N 倍标准差
+	RuleDuplicate = "duplicate"  // 与上一笔时间戳和价格完全相同
+	RuleStale     = "stale"      // 行情源时间落后于接收时间太多
+)
+
+const tickRejectedChannel = "tick_rejected" // Redis Pub/Sub: 被拒绝的Tick, 供人工复核
+
+// TickFilterConfig 品种的Tick质量过滤配置, 未配置时不过滤
+type TickFilterConfig struct {
+	Crossed      bool     `json:"crossed"`        // 拒绝 ask < bid 的报价
+	MaxJumpPct   float64  `json:"max_jump_pct"`   // 相对上一笔的最大跳动 (百分比, 如 0.5), 0 表示不检查
+	MaxJumpSigma float64  `json:"max_jump_sigma"` // 最大跳动 (收益率标准差的倍数), 0 表示不检查
+	SigmaWindow  int      `json:"sigma_window"`   // 计算标准差的Tick数, 默认 200
+	ConfirmTicks int      `json:"confirm_ticks"`  // 连续多少笔在新价位附近时确认为真实跳空, 默认 3
+	Duplicates   bool     `json:"duplicates"`     // 拒绝重复Tick
+	MaxAge       Duration `json:"max_age"`        // 行情源时间最多落后接收时间多久 (MT4 报价时间按交易时段时区换算), 0 表示不检查
+}
+
+func (c *TickFilterConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.MaxJumpPct < 0 || c.MaxJumpSigma < 0 || c.SigmaWindow < 0 || c.ConfirmTicks < 0 || c.MaxAge < 0 {
+		return fmt.Errorf("filters: values must not be negative")
+	}
+	return nil
+}
+
+// TickRejection 一次拒绝记录
+type TickRejection struct {
+	Symbol string    `json:"symbol"`
+	Rule   string    `json:"rule"`
+	Reason string    `json:"reason"`
+	Source string    `json:"source"`
+	Bid    float64   `json:"bid"`
+	Ask    float64   `json:"ask"`
+	Time   time.Time `json:"time"`
+}
+
+func (r TickRejection) ToJSON() []byte {
+	b, _ := json.Marshal(r)
+	return b
+}
+
+// TickFilter 单个品种的Tick质量过滤, 只在该品种的工人 goroutine 中调用 Accept
+type TickFilter struct {
+	symbol string
+	cfg    TickFilterConfig
+	loc    *time.Location      // 品种交易时段的时区, 用于换算 MT4 报价时间
+	notify func(TickRejection) // 可选: 拒绝通知
+
+	last      CleanTick // 上一笔通过的Tick
+	hasLast   bool
+	returns   []float64 // 最近的对数收益率 (环形缓冲)
+	next      int
+	pending   []float64 // 被跳动规则拒绝、等待确认的新价位
+	countLock sync.Mutex
+	counts    map[string]int64
+}
+
+// NewTickFilter 创建过滤器; session 为品种的交易时段, 为 nil 时按 UTC
+func NewTickFilter(symbol string, cfg TickFilterConfig, session *Session, notify func(TickRejection)) *TickFilter {
+	if cfg.SigmaWindow == 0 {
+		cfg.SigmaWindow = 200
+	}
+	if cfg.ConfirmTicks == 0 {
+		cfg.ConfirmTicks = 3
+	}
+	return &TickFilter{symbol: symbol, cfg: cfg, loc: session.Location(), notify: notify, counts: make(map[string]int64)}
+}
+
+// Accept 判断Tick是否可以进入聚合
+func (f *TickFilter) Accept(tick CleanTick) bool {
+	if rule, reason := f.check(tick); rule != "" {
+		f.reject(tick, rule, reason)
+		return false
+	}
+	f.accept(tick)
+	return true
+}
+
+func (f *TickFilter) check(tick CleanTick) (rule, reason string) {
+	bid := tickBid(tick)
+	if f.cfg.Crossed && tick.Ask > 0 && tick.Ask < bid {
+		return RuleCrossed, fmt.Sprintf("ask %.5f < bid %.5f", tick.Ask, bid)
+	}
+	if f.cfg.MaxAge > 0 && !tick.ReceivedAt.IsZero() {
+		if age := tick.ReceivedAt.Sub(f.sourceTime(tick)); age > f.cfg.MaxAge.Std() {
+			return RuleStale, fmt.Sprintf("quote is %s old", age.Round(time.Millisecond))
+		}
+	}
+	if !f.hasLast || bid <= 0 {
+		return "", ""
+	}
+	if f.cfg.Duplicates && tick.Timestamp.Equal(f.last.Timestamp) && bid == tickBid(f.last) && tick.Ask == f.last.Ask {
+		return RuleDuplicate, "same timestamp and prices as previous tick"
+	}
+
+	ret := math.Log(bid / tickBid(f.last))
+	if f.cfg.MaxJumpPct > 0 && math.Abs(ret) > math.Log1p(f.cfg.MaxJumpPct/100) {
+		if !f.confirmJump(bid) {
+			return RuleJumpPct, fmt.Sprintf("jump %.3f%% exceeds %.3f%%", (math.Exp(ret)-1)*100, f.cfg.MaxJumpPct)
+		}
+		return "", ""
+	}
+	if f.cfg.MaxJumpSigma > 0 && len(f.returns) >= f.cfg.SigmaWindow/2 {
+		if sigma := stddev(f.returns); sigma > 0 && math.Abs(ret) > f.cfg.MaxJumpSigma*sigma {
+			if !f.confirmJump(bid) {
+				return RuleJumpSigma, fmt.Sprintf("jump %.1fσ exceeds %.1fσ", math.Abs(ret)/sigma, f.cfg.MaxJumpSigma)
+			}
+			return "", ""
+		}
+	}
+	f.pending = f.pending[:0]
+	return "", ""
+}
+
+// sourceTime 返回Tick的行情源时间 (UTC); MT4 报价 (PlatformID 非0) 的时间是经纪商服务器时间,
+// 解析时按 UTC 标记, 这里按品种交易时段的时区重新解释
+func (f *TickFilter) sourceTime(tick CleanTick) time.Time {
+	ts := tick.Timestamp
+	if tick.PlatformID == 0 || f.loc == time.UTC {
+		return ts
+	}
+	return time.Date(ts.Year(), ts.Month(), ts.Day(),
+		ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), f.loc).UTC()
+}
+
+// confirmJump 记录跳动后的价位; 连续 ConfirmTicks 笔都落在该价位附近时视为真实跳空
+func (f *TickFilter) confirmJump(bid float64) bool {
+	if len(f.pending) > 0 {
+		ref := f.pending[len(f.pending)-1]
+		band := math.Max(f.cfg.MaxJumpPct/100, 0.0005) // 无百分比规则时按 5 个基点判断是否同一价位
+		if math.Abs(bid-ref)/ref > band {
+			f.pending = f.pending[:0]
+		}
+	}
+	f.pending = append(f.pending, bid)
+	if len(f.pending) < f.cfg.ConfirmTicks {
+		return false
+	}
+	f.pending = f.pending[:0]
+	return true
+}
+
+func (f *TickFilter) accept(tick CleanTick) {
+	bid := tickBid(tick)
+	if f.hasLast && bid > 0 && tickBid(f.last) > 0 {
+		ret := math.Log(bid / tickBid(f.last))
+		if len(f.returns) < f.cfg.SigmaWindow {
+			f.returns = append(f.returns, ret)
+		} else {
+			f.returns[f.next] = ret
+			f.next = (f.next + 1) % f.cfg.SigmaWindow
+		}
+	}
+	f.last = tick
+	f.hasLast = true
+}
+
+func (f *TickFilter) reject(tick CleanTick, rule, reason string) {
+	f.countLock.Lock()
+	f.counts[rule]++
+	f.countLock.Unlock()
+	if f.notify != nil {
+		f.notify(TickRejection{
+			Symbol: f.symbol, Rule: rule, Reason: reason, Source: tick.Source,
+			Bid: tickBid(tick), Ask: tick.Ask, Time: tick.Timestamp,
+		})
+	}
+}
+
+// Counts 返回每条规则拒绝的Tick数
+func (f *TickFilter) Counts() map[string]int64 {
+	f.countLock.Lock()
+	defer f.countLock.Unlock()
+	result := make(map[string]int64, len(f.counts))
+	for rule, n := range f.counts {
+		result[rule] = n
+	}
+	return result
+}
+
+func tickBid(tick CleanTick) float64 {
+	if tick.Bid != 0 {
+		return tick.Bid
+	}
+	return tick.Price
+}
+
+func stddev(xs []float64) float64 {
+	var sum, sq float64
+	for _, x := range xs {
+		sum += x
+	}
+	mean := sum / float64(len(xs))
+	for _, x := range xs {
+		sq += (x - mean) * (x - mean)
+	}
+	return math.Sqrt(sq / float64(len(xs)))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func filterTick(sec int, bid, ask float64) CleanTick {
+	ts := time.Date(2025, 11, 24, 10, 0, sec, 0, time.UTC)
+	return CleanTick{Symbol: "XAUUSD", Bid: bid, Ask: ask, Price: bid, Timestamp: ts, ReceivedAt: ts}
+}
+
+func TestTickFilterRules(t *testing.T) {
+	var rejected []TickRejection
+	f := NewTickFilter("XAUUSD", TickFilterConfig{
+		Crossed: true, MaxJumpPct: 1, Duplicates: true, MaxAge: Duration(5 * time.Second),
+	}, nil, func(r TickRejection) { rejected = append(rejected, r) })
+
+	stale := filterTick(1, 2000, 2000.5)
+	stale.ReceivedAt = stale.Timestamp.Add(10 * time.Second)
+
+	cases := []struct {
+		tick CleanTick
+		want bool
+	}{
+		{filterTick(0, 2000, 2000.5), true},
+		{filterTick(0, 2000, 2000.5), false}, // duplicate
+		{filterTick(1, 2001, 2000.5), false}, // crossed
+		{stale, false},
+		{filterTick(2, 2100, 2100.5), false}, // +5% spike
+		{filterTick(3, 2000.2, 2000.7), true},
+	}
+	for i, c := range cases {
+		if got := f.Accept(c.tick); got != c.want {
+			t.Errorf("case %d: Accept = %v, want %v", i, got, c.want)
+		}
+	}
+
+	counts := f.Counts()
+	for _, rule := range []string{RuleDuplicate, RuleCrossed, RuleStale, RuleJumpPct} {
+		if counts[rule] != 1 {
+			t.Errorf("want 1 rejection for %s, got %d", rule, counts[rule])
+		}
+	}
+	if len(rejected) != 4 || rejected[0].Rule != RuleDuplicate {
+		t.Errorf("unexpected rejections %+v", rejected)
+	}
+}
+
+func TestTickFilterConfirmsRealGap(t *testing.T) {
+	f := NewTickFilter("XAUUSD", TickFilterConfig{MaxJumpPct: 1, ConfirmTicks: 3}, nil, nil)
+	f.Accept(filterTick(0, 2000, 0))
+
+	results := []bool{
+		f.Accept(filterTick(1, 2050, 0)),
+		f.Accept(filterTick(2, 2051, 0)),
+		f.Accept(filterTick(3, 2050.5, 0)), // 第三笔确认新价位
+		f.Accept(filterTick(4, 2051.5, 0)),
+	}
+	want := []bool{false, false, true, true}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Fatalf("tick %d: Accept = %v, want %v", i, results[i], want[i])
+		}
+	}
+}
+
+func TestTickFilterSigma(t *testing.T) {
+	f := NewTickFilter("XAUUSD", TickFilterConfig{MaxJumpSigma: 6, SigmaWindow: 20}, nil, nil)
+	price := 2000.0
+	for i := 0; i < 20; i++ {
+		if i%2 == 0 {
+			price += 0.1
+		} else {
+			price -= 0.1
+		}
+		if !f.Accept(filterTick(i, price, 0)) {
+			t.Fatalf("normal tick %d rejected", i)
+		}
+	}
+	if f.Accept(filterTick(30, price+3, 0)) {
+		t.Fatal("want outlier rejected by sigma rule")
+	}
+}
+
+func TestTickFilterStaleUsesBrokerTime(t *testing.T) {
+	f := NewTickFilter("XAUUSD", TickFilterConfig{MaxAge: Duration(5 * time.Second)}, newYorkSession(t), nil)
+
+	// MT4 报价时间是纽约时间 05:00:00 (按 UTC 标记), 实际为 10:00:00Z
+	tick := filterTick(0, 2000, 2000.5)
+	tick.PlatformID = 1
+	tick.Timestamp = time.Date(2025, 11, 24, 5, 0, 0, 0, time.UTC)
+	tick.ReceivedAt = time.Date(2025, 11, 24, 10, 0, 2, 0, time.UTC)
+	if !f.Accept(tick) {
+		t.Fatal("want a fresh MT4 quote accepted")
+	}
+	tick.ReceivedAt = tick.ReceivedAt.Add(10 * time.Second)
+	if f.Accept(tick) {
+		t.Fatal("want a stale MT4 quote rejected")
+	}
+}
@@ -2,74 +2,169 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"log"
-	"time"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/gorilla/websocket"
-)
+	"github.com/jmoiron/sqlx"
 
-// --- 生产配置 ---
-const (
-	UPSTREAM_WS_URL = "ws://106.55.179.109:8088/event?id=6" // 您的真实上游数据源
-	REDIS_ADDR      = "localhost:6379"
+	"shared/configfile"
 )
 
 var rdb *redis.Client
 var ctx = context.Background()
 
 func main() {
-	rdb = redis.NewClient(&redis.Options{ Addr: REDIS_ADDR })
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "config file (.yaml, .toml or .json); environment variables take precedence")
+	printCfg := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	// 启动前读取并校验全部配置, 有错误时不连接任何服务
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	if *printCfg {
+		if err := configfile.Print(os.Stdout, cfg); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		return
+	}
+	if err := configfile.SetupLogging(cfg.Log); err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	// 回放模式: candle replay -file ...
+	if args := flag.Args(); len(args) > 0 && args[0] == "replay" {
+		if err := runReplay(args[1:], cfg); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		return
+	}
+
+	rdb = redis.NewClient(cfg.Redis.Options())
 	if _, err := rdb.Ping(ctx).Result(); err != nil {
 		log.Fatalf("FATAL: Could not connect to Redis: %v", err)
 	}
 	log.Println("Connected to Redis")
 
-	manager := NewAggregatorManager(rdb)
+	shutdownTimeout := cfg.ShutdownTimeout.Std()
 
-	go connectAndRead(manager) // 启动上游连接器
+	// SIGINT/SIGTERM 时先停止行情源; 定时关闭、状态落盘和Tick归档在队列排空后才停止
+	runCtx, stopRun := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopRun()
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
 
-	log.Println("Candle Aggregator service is running.")
-	select {} // 保持主程序运行
-}
-
-// 连接WS并处理自动重连
-func connectAndRead(manager *AggregatorManager) {
-	for { // 自动重连循环
-		log.Printf("Connecting to upstream WebSocket: %s", UPSTREAM_WS_URL)
-
-		c, _, err := websocket.DefaultDialer.Dial(UPSTREAM_WS_URL, nil)
-		if err != nil {
-			log.Printf("ERROR: Failed to connect to upstream: %v", err)
-			time.Sleep(5 * time.Second)
-			continue // 重试
+	publisher := NewCandlePublisher(rdb, cfg.Output)
+	notifier := NewRedisNotifier(rdb) // Tick拒绝和行情源状态通知, 不阻塞工人和行情源
+	manager := NewAggregatorManager(bgCtx, publisher, cfg.Symbols, cfg.WorkerPool)
+	manager.OnReject = func(r TickRejection) {
+		notifier.Notify(tickRejectedChannel, r.ToJSON()) // 通知复核
+	}
+
+	// (可选) TimescaleDB, 配置 postgres (或 DATABASE_URL) 后用于Tick归档 (只归档通过质量过滤的Tick) 和重启时核对K线
+	var db *sqlx.DB
+	var archiver *TickArchiver
+	if cfg.Postgres.Configured() {
+		if db, err = sqlx.Connect("pgx", cfg.Postgres.DSN()); err != nil {
+			log.Fatalf("FATAL: Failed to connect to TimescaleDB: %v", err)
+		}
+		archiver = NewTickArchiver(db, cfg.TickArchive)
+		if err := archiver.Start(bgCtx); err != nil {
+			log.Fatalf("FATAL: %v", err)
 		}
+		manager.Archiver = archiver
+		log.Println("Tick archiver enabled")
+	}
 
-		log.Println("Successfully connected to upstream WebSocket.")
-
-		// (可选) 发送订阅消息
-		// c.WriteMessage(...)
-
-		// 内部读取循环
-		func() {
-			defer c.Close()
-			for {
-				_, message, err := c.ReadMessage()
-				if err != nil {
-					log.Printf("ERROR: Upstream read error: %v. Connection lost.", err)
-					return // 退出内部函数, 触发重连
-				}
-
-				var quote UpstreamQuote
-				if err := json.Unmarshal(message, &quote); err != nil {
-					log.Printf("WARNING: Failed to unmarshal message: %v.", err)
-					continue
-				}
-
-				// 将解析后的数据交给聚合器
-				manager.HandleRawQuote(quote)
-			}
+	// 未闭合K线持久化到 Redis, 重启后恢复
+	stateStore := NewRedisStateStore(rdb)
+	manager.State = stateStore
+	var lookup KlineLookup
+	if db != nil {
+		lookup = dbKlineLookup(db)
+	}
+	if err := manager.RestoreState(ctx, lookup); err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	stateDone := goDone(func() { stateStore.Run(bgCtx) })
+	schedulerDone := goDone(func() { manager.RunCloseScheduler(bgCtx) }) // 定时关闭到期K线, 不等待下一个Tick
+
+	// 品种映射规则 (symbol_aliases 表, 由 db 服务迁移创建), 需要数据库; 未配置时沿用按 "." 截断后缀
+	sink := TickSink(manager.HandleTick)
+	if db != nil {
+		mapper := NewSymbolMapper(db, rdb, cfg.SymbolMapping)
+		if err := mapper.Start(runCtx); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+		sink = mapper.Wrap(sink)
+	}
+
+	// 启动所有行情源, 每个源独立重连
+	// 报价过期检测按品种交易时段判断, 状态变化发布到 Redis
+	monitor := &FeedMonitor{
+		IsOpen: manager.IsMarketOpen,
+		Notify: func(s FeedStatus) {
+			notifier.Notify(feedStatusChannel, s.ToJSON())
+		},
+	}
+	tickSources, err := NewTickSources(cfg.Upstream, monitor)
+	if err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+	var sources sync.WaitGroup
+	for _, source := range tickSources {
+		log.Printf("Starting tick source %s", source.Name())
+		sources.Add(1)
+		go func() {
+			defer sources.Done()
+			source.Run(runCtx, sink)
 		}()
 	}
+
+	// 管理接口: 健康检查、Prometheus 指标、品种和周期的运行时管理
+	// 设置 admin.token (ADMIN_TOKEN) 后, 修改类请求需要带 "Authorization: Bearer <token>"
+	pingRedis := func(ctx context.Context) error { return rdb.Ping(ctx).Err() }
+	adminServer := &http.Server{Addr: cfg.Admin.Addr, Handler: NewAdminHandler(manager, pingRedis, monitor.Connected, cfg.Admin.Token)}
+	go func() {
+		log.Printf("Admin API listening on %s", cfg.Admin.Addr)
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("ERROR: Admin server stopped: %v", err)
+		}
+	}()
+
+	log.Println("Candle Aggregator service is running.")
+	<-runCtx.Done()
+
+	// 优雅停机: 行情源 -> 品种队列 -> 定时关闭 -> 发布 -> 状态落盘 (或清除) -> Tick归档
+	log.Printf("🛑 Shutting down (timeout %s): stopping tick sources...", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	waitDone(shutdownCtx, "tick sources", goDone(sources.Wait))
+	log.Println("🛑 Draining symbol queues...")
+	manager.Drain(shutdownCtx)
+	adminServer.Shutdown(shutdownCtx)
+	stopBackground()
+	waitDone(shutdownCtx, "close scheduler", schedulerDone)
+	log.Println("🛑 Waiting for outstanding publishes...")
+	closeOutput(shutdownCtx, "candle publisher", publisher)
+	closeOutput(shutdownCtx, "notifications", notifier)
+	waitDone(shutdownCtx, "candle state flush", stateDone) // 未闭合的K线已保存, 重启后恢复
+	if !cfg.PersistOpenCandles {
+		if err := stateStore.Clear(shutdownCtx); err != nil {
+			log.Printf("ERROR: %v", err)
+		} else {
+			log.Println("🛑 Discarded in-progress candles (persist_open_candles is off)")
+		}
+	}
+	if archiver != nil {
+		closeOutput(shutdownCtx, "tick archiver", archiver)
+	}
+	log.Println("👋 Candle Aggregator stopped.")
 }
\ No newline at end of file
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 进程内的运行指标, 由 /metrics 以 Prometheus 文本格式输出
+var metrics = newCandleMetrics()
+
+type candleMetrics struct {
+	ticksReceived    *counterVec   // source, symbol: 进入 HandleTick 的Tick
+	ticksAggregated  *counterVec   // symbol: 通过过滤并参与聚合的Tick
+	ticksDropped     *counterVec   // symbol, reason: 队列满或品种数超限丢弃的Tick
+	parseErrors      *counterVec   // source: 无法解析的上游消息
+	sourceConnected  *gaugeVec     // source: 上游连接状态 (1 已连接)
+	sourceReconnects *counterVec   // source: 连接断开后重连的次数
+	sourceActive     *gaugeVec     // group, source: 故障转移组当前采用的源 (1)
+	publishDuration  *histogramVec // output: 单次发布耗时
+	publishFailures  *counterVec   // output: 发布失败次数
+	gapFilledBars    *counterVec   // symbol, timeframe: 缺口填充的K线数
+}
+
+func newCandleMetrics() *candleMetrics {
+	return &candleMetrics{
+		ticksReceived:    newCounterVec("candle_ticks_received_total", "Ticks handed to the aggregator.", "source", "symbol"),
+		ticksAggregated:  newCounterVec("candle_ticks_aggregated_total", "Ticks that passed filters and were aggregated.", "symbol"),
+		ticksDropped:     newCounterVec("candle_ticks_dropped_total", "Ticks dropped before aggregation.", "symbol", "reason"),
+		parseErrors:      newCounterVec("candle_source_parse_errors_total", "Upstream messages that could not be parsed.", "source"),
+		sourceConnected:  newGaugeVec("candle_source_connected", "Whether the upstream source is connected (1) or not (0).", "source"),
+		sourceReconnects: newCounterVec("candle_source_reconnects_total", "Upstream sessions that ended and were retried.", "source"),
+		sourceActive:     newGaugeVec("candle_source_active", "Whether the source is the one feeding its failover group (1) or not (0).", "group", "source"),
+		publishDuration: newHistogramVec("candle_publish_duration_seconds", "Time spent publishing candle events.",
+			[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}, "output"),
+		publishFailures: newCounterVec("candle_publish_failures_total", "Failed candle event publishes.", "output"),
+		gapFilledBars:   newCounterVec("candle_gap_filled_bars_total", "Missing bars filled from the previous close.", "symbol", "timeframe"),
+	}
+}
+
+// observePublish 记录一次发布的耗时和结果
+func (cm *candleMetrics) observePublish(output string, start time.Time, err error) {
+	cm.publishDuration.Observe(time.Since(start).Seconds(), output)
+	if err != nil {
+		cm.publishFailures.Inc(output)
+	}
+}
+
+func (cm *candleMetrics) write(w *bufio.Writer) {
+	cm.ticksReceived.write(w)
+	cm.ticksAggregated.write(w)
+	cm.ticksDropped.write(w)
+	cm.parseErrors.write(w)
+	cm.sourceConnected.write(w)
+	cm.sourceReconnects.write(w)
+	cm.sourceActive.write(w)
+	cm.publishDuration.write(w)
+	cm.publishFailures.write(w)
+	cm.gapFilledBars.write(w)
+}
+
+// writeMetrics 输出全局指标和聚合器当前状态 (队列深度、迟到和过滤计数)
+//
+// 只在持有 m.lock 时复制队列和过滤器的引用, 读取计数和格式化都在锁外进行
+func (m *AggregatorManager) writeMetrics(w *bufio.Writer) {
+	metrics.write(w)
+
+	m.lock.RLock()
+	symbols, dormant := len(m.queues), len(m.dormant)
+	queues := make(map[string]*tickQueue, len(m.queues))
+	for symbol, q := range m.queues {
+		queues[symbol] = q
+	}
+	filters := make(map[string]*TickFilter, len(m.filters))
+	for symbol, filter := range m.filters {
+		filters[symbol] = filter
+	}
+	m.lock.RUnlock()
+
+	depth := make([]gaugeSample, 0, len(queues))
+	capacity := make([]gaugeSample, 0, len(queues))
+	late := make([]gaugeSample, 0, len(queues))
+	for symbol, q := range queues {
+		labels := []string{symbol}
+		depth = append(depth, gaugeSample{labels, float64(q.len())})
+		capacity = append(capacity, gaugeSample{labels, float64(q.size)})
+		late = append(late, gaugeSample{labels, float64(q.agg.LateDropped())})
+	}
+	var rejected []gaugeSample
+	for symbol, filter := range filters {
+		for rule, n := range filter.Counts() {
+			rejected = append(rejected, gaugeSample{[]string{symbol, rule}, float64(n)})
+		}
+	}
+
+	writeGauges(w, "candle_queue_depth", "Ticks waiting in the symbol queue.", []string{"symbol"}, depth)
+	writeGauges(w, "candle_queue_capacity", "Capacity of the symbol queue.", []string{"symbol"}, capacity)
+	writeGauges(w, "candle_late_ticks_dropped", "Late ticks that could not amend a closed candle (resets on eviction).", []string{"symbol"}, late)
+	writeGauges(w, "candle_ticks_rejected", "Ticks rejected by quality filters (resets on eviction).", []string{"symbol", "rule"}, rejected)
+	writeSample(w, "candle_symbols_active", "gauge", "Symbols with a live aggregator.", float64(symbols))
+	writeSample(w, "candle_symbols_dormant", "gauge", "Evicted symbols with open candles kept in the state store.", float64(dormant))
+}
+
+// MetricsHandler 返回 /metrics 的处理函数
+func (m *AggregatorManager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		m.writeMetrics(bw)
+		bw.Flush()
+	})
+}
+
+// --- Prometheus 文本格式的最小实现 ---
+
+type labeledValue struct {
+	labels []string
+	bits   atomic.Uint64 // float64 的位表示
+}
+
+func (v *labeledValue) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *labeledValue) value() float64 { return math.Float64frombits(v.bits.Load()) }
+
+// metricVec 按标签值区分的一组时间序列
+type metricVec struct {
+	name, help, kind string
+	labelNames       []string
+	mu               sync.RWMutex
+	series           map[string]*labeledValue
+}
+
+func newMetricVec(name, help, kind string, labelNames []string) metricVec {
+	return metricVec{name: name, help: help, kind: kind, labelNames: labelNames, series: make(map[string]*labeledValue)}
+}
+
+func (v *metricVec) get(labelValues []string) *labeledValue {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s: want %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = &labeledValue{labels: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted 按标签值排序的序列, 输出稳定
+func (v *metricVec) sorted() []*labeledValue {
+	v.mu.RLock()
+	result := make([]*labeledValue, 0, len(v.series))
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result = append(result, v.series[key])
+	}
+	v.mu.RUnlock()
+	return result
+}
+
+func (v *metricVec) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+	for _, s := range v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labelNames, s.labels), formatFloat(s.value()))
+	}
+}
+
+type counterVec struct{ metricVec }
+
+func newCounterVec(name, help string, labelNames ...string) *counterVec {
+	return &counterVec{newMetricVec(name, help, "counter", labelNames)}
+}
+
+func (c *counterVec) Inc(labelValues ...string) { c.get(labelValues).add(1) }
+
+func (c *counterVec) Add(delta float64, labelValues ...string) { c.get(labelValues).add(delta) }
+
+type gaugeVec struct{ metricVec }
+
+func newGaugeVec(name, help string, labelNames ...string) *gaugeVec {
+	return &gaugeVec{newMetricVec(name, help, "gauge", labelNames)}
+}
+
+func (g *gaugeVec) Set(value float64, labelValues ...string) {
+	g.get(labelValues).bits.Store(math.Float64bits(value))
+}
+
+func (g *gaugeVec) Value(labelValues ...string) float64 { return g.get(labelValues).value() }
+
+// histogramVec 固定桶的直方图
+type histogramVec struct {
+	name, help string
+	buckets    []float64
+	labelNames []string
+	mu         sync.Mutex
+	series     map[string]*histogram
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64 // 每个桶 (不累计), 最后一个为 +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labelNames ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, buckets: buckets, labelNames: labelNames, series: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, value)]++
+	s.sum += value
+	s.count++
+}
+
+func (h *histogramVec) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	leNames := append(append([]string(nil), h.labelNames...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(leNames, append(s.labels[:len(s.labels):len(s.labels)], formatFloat(upper))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(leNames, append(s.labels[:len(s.labels):len(s.labels)], "+Inf")), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labelNames, s.labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labelNames, s.labels), s.count)
+	}
+}
+
+// gaugeSample 抓取时才计算的一个 gauge 值
+type gaugeSample struct {
+	labels []string
+	value  float64
+}
+
+// writeGauges 按标签值排序输出一组抓取时计算的 gauge
+func writeGauges(w *bufio.Writer, name, help string, labelNames []string, samples []gaugeSample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].labels, "\xff") < strings.Join(samples[j].labels, "\xff")
+	})
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labelNames, s.labels), formatFloat(s.value))
+	}
+}
+
+func writeSample(w *bufio.Writer, name, kind, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterAndGaugeExposition(t *testing.T) {
+	c := newCounterVec("test_total", "Test counter.", "symbol")
+	c.Inc("XAUUSD")
+	c.Add(2, "XAUUSD")
+	c.Inc(`EUR"USD`)
+	g := newGaugeVec("test_gauge", "Test gauge.")
+	g.Set(1.5)
+
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	c.write(w)
+	g.write(w)
+	w.Flush()
+
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{symbol="EUR\"USD"} 1
+test_total{symbol="XAUUSD"} 3
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge 1.5
+`
+	if b.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Test histogram.", []float64{0.1, 1}, "output")
+	h.Observe(0.05, "stream")
+	h.Observe(0.1, "stream")
+	h.Observe(3, "stream")
+
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	h.write(w)
+	w.Flush()
+
+	for _, line := range []string{
+		`test_seconds_bucket{output="stream",le="0.1"} 2`,
+		`test_seconds_bucket{output="stream",le="1"} 2`,
+		`test_seconds_bucket{output="stream",le="+Inf"} 3`,
+		`test_seconds_sum{output="stream"} 3.15`,
+		`test_seconds_count{output="stream"} 3`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestMetricsHandlerReportsQueues(t *testing.T) {
+	m := newPoolTestManager(t, nil, &recordingPublisher{}, WorkerPoolConfig{Workers: 1, IdleTimeout: -1})
+	m.HandleTick(testTick(t, "2025-11-24T10:00:00Z", 2000))
+	waitIdle(t, m)
+
+	rec := httptest.NewRecorder()
+	m.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`candle_queue_depth{symbol="XAUUSD"} 0`,
+		`candle_queue_capacity{symbol="XAUUSD"} 5000`,
+		`candle_symbols_active 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, body)
+		}
+	}
+	if !strings.Contains(body, `candle_ticks_aggregated_total{symbol="XAUUSD"}`) {
+		t.Fatalf("missing aggregated tick counter in:\n%s", body)
+	}
+}
+
+// 文本格式 0.0.4 的样本行: 名称{标签="值",...} 数值
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\.)*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\.)*")*\})? (\S+)$`)
+
+// TestMetricsExpositionFormat 按 Prometheus 文本格式逐行校验 /metrics 的完整输出
+func TestMetricsExpositionFormat(t *testing.T) {
+	m := newPoolTestManager(t, nil, &recordingPublisher{}, WorkerPoolConfig{Workers: 1, IdleTimeout: -1})
+	m.symbolConfigs["XAUUSD"] = SymbolConfig{Filters: &TickFilterConfig{Crossed: true}}
+	m.HandleTick(testTick(t, "2025-11-24T10:00:00Z", 2000))
+	crossed := testTick(t, "2025-11-24T10:00:01Z", 2000)
+	crossed.Ask = 1999
+	m.HandleTick(crossed)
+	waitIdle(t, m)
+	metrics.observePublish(`out"put`, time.Now(), errors.New("test"))
+	metrics.sourceConnected.Set(1, "line\nbreak")
+
+	rec := httptest.NewRecorder()
+	m.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	if !strings.HasSuffix(body, "\n") {
+		t.Fatal("want output to end with a newline")
+	}
+
+	types := make(map[string]string)
+	helps := make(map[string]bool)
+	for i, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			name, _, _ := strings.Cut(strings.TrimPrefix(line, "# HELP "), " ")
+			if helps[name] {
+				t.Errorf("line %d: duplicate HELP for %s", i+1, name)
+			}
+			helps[name] = true
+		case strings.HasPrefix(line, "# TYPE "):
+			name, kind, _ := strings.Cut(strings.TrimPrefix(line, "# TYPE "), " ")
+			if _, dup := types[name]; dup {
+				t.Errorf("line %d: duplicate TYPE for %s", i+1, name)
+			}
+			if kind != "counter" && kind != "gauge" && kind != "histogram" {
+				t.Errorf("line %d: unknown type %q", i+1, kind)
+			}
+			types[name] = kind
+		default:
+			match := sampleLine.FindStringSubmatch(line)
+			if match == nil {
+				t.Errorf("line %d: malformed sample %q", i+1, line)
+				continue
+			}
+			if _, err := strconv.ParseFloat(match[3], 64); err != nil {
+				t.Errorf("line %d: bad value %q", i+1, match[3])
+			}
+			family := match[1]
+			if _, ok := types[family]; !ok {
+				for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+					if base := strings.TrimSuffix(family, suffix); base != family && types[base] == "histogram" {
+						family = base
+					}
+				}
+			}
+			if _, ok := types[family]; !ok {
+				t.Errorf("line %d: sample %s before its TYPE line", i+1, match[1])
+			}
+		}
+	}
+	for _, name := range []string{"candle_ticks_rejected", "candle_publish_duration_seconds", "candle_source_connected"} {
+		if types[name] == "" || !helps[name] {
+			t.Errorf("missing family %s in:\n%s", name, body)
+		}
+	}
+	if !strings.Contains(body, `candle_ticks_rejected{symbol="XAUUSD",rule="crossed"} 1`+"\n") {
+		t.Errorf("missing filter rejection in:\n%s", body)
+	}
+}
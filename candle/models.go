@@ -27,10 +27,17 @@ type UpstreamQuote struct {
 
 // 处理Tick数据
 type CleanTick struct {
-	Symbol    string
-	Price     float64
-	Volume    int64
-	Timestamp time.Time
+	Symbol     string    // 规范化后的品种名
+	RawSymbol  string    // 上游原始品种名 (含经纪商后缀)
+	PlatformID int       // 上游平台 (UpstreamQuote.Data.PlatformId), 非MT4源为0
+	Price      float64
+	Volume     int64
+	Timestamp  time.Time // 行情源时间 (UpstreamQuote.Data.Args.Time)
+	Source     string    // 来源行情源名称 (见 SourceConfig.Name)
+	Bid        float64
+	Ask        float64
+	Spread     float64   // 上游给出的点差 (点数, 只用于归档), 未知时为0
+	ReceivedAt time.Time // 本服务收到该Tick的时间
 }
 
 // Candle K线结构
@@ -43,12 +50,66 @@ type Candle struct {
 	Low       float64   `json:"low"`
 	Close     float64   `json:"close"`
 	Volume    int64     `json:"volume"`
+
+	// 以下为扩展字段, 旧的消费方可以忽略
+	PriceType string  `json:"price_type"` // "bid" | "ask" | "mid"
+	SpreadMin float64 `json:"spread_min"` // 点差统计均为 ask-bid, 价格单位 (不是点数)
+	SpreadAvg float64 `json:"spread_avg"`
+	SpreadMax float64 `json:"spread_max"`
+	TickCount int64   `json:"tick_count"`
+	Synthetic bool    `json:"synthetic,omitempty"` // 由缺口填充生成, 并非真实成交
+
+	spreadSum float64   // 用于计算 SpreadAvg
+	firstTick time.Time // 决定 Open 的Tick时间 (迟到Tick可能更早)
+	lastTick  time.Time // 决定 Close 的Tick时间 (迟到Tick可能更晚)
+}
+
+// newCandleFromTick 以一个Tick开启新K线
+func newCandleFromTick(symbol, tfName, series string, start time.Time, price float64, tick CleanTick) *Candle {
+	return &Candle{
+		Symbol: symbol, Timeframe: tfName, StartTime: start, PriceType: series,
+		Open: price, High: price, Low: price, Close: price, Volume: tick.Volume,
+		SpreadMin: tickSpread(tick), SpreadAvg: tickSpread(tick), SpreadMax: tickSpread(tick),
+		TickCount: 1, spreadSum: tickSpread(tick),
+		firstTick: tick.Timestamp, lastTick: tick.Timestamp,
+	}
+}
+
+// apply 将Tick合并到K线; 乱序到达的Tick按时间戳决定是否更新 Open/Close
+func (c *Candle) apply(price float64, tick CleanTick) {
+	c.High = max(c.High, price)
+	c.Low = min(c.Low, price)
+	if tick.Timestamp.Before(c.firstTick) {
+		c.Open = price
+		c.firstTick = tick.Timestamp
+	}
+	if !tick.Timestamp.Before(c.lastTick) {
+		c.Close = price
+		c.lastTick = tick.Timestamp
+	}
+	c.Volume += tick.Volume
+
+	spread := tickSpread(tick)
+	c.SpreadMin = min(c.SpreadMin, spread)
+	c.SpreadMax = max(c.SpreadMax, spread)
+	c.spreadSum += spread
+	c.TickCount++
+	c.SpreadAvg = c.spreadSum / float64(c.TickCount)
+}
+
+// tickSpread Tick的点差 ask-bid (价格单位); 上游的 Spread 是点数, 各经纪商的位数不同, 不参与统计
+func tickSpread(tick CleanTick) float64 {
+	if tick.Bid > 0 && tick.Ask > 0 {
+		return tick.Ask - tick.Bid
+	}
+	return 0
 }
 
 // 发布到Redis
 type PublishEvent struct {
-	Status string `json:"status"` // "UPDATE" (K线跳动) 或 "CLOSE" (K线闭合)
+	Status string `json:"status"` // "UPDATE" (K线跳动), "CLOSE" (K线闭合) 或 "AMEND" (迟到Tick修正已闭合K线)
 	Candle Candle `json:"candle"`
+	Seq    int64  `json:"seq,omitempty"` // 品种内递增的序号 (仅 Redis Streams 输出)
 }
 
 // ToJSON 辅助函数
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"shared/configfile"
+)
+
+// 品种队列满时的处理方式
+const (
+	BackpressureDropOldest = "drop_oldest" // 丢弃最早的Tick
+	BackpressureCoalesce   = "coalesce"    // 新Tick覆盖队尾的Tick, 只保留最新价格 (成交量累加)
+	BackpressureBlock      = "block"       // 阻塞行情源直到有空位
+)
+
+const (
+	defaultQueueSize   = 5000
+	defaultIdleTimeout = time.Hour
+)
+
+// WorkerPoolConfig 聚合工人池配置 (环境变量 WORKER_POOL, JSON; 或配置节 worker_pool)
+type WorkerPoolConfig struct {
+	Workers     int      `json:"workers"`      // 分片工人数, 默认 CPU 核数
+	MaxSymbols  int      `json:"max_symbols"`  // 同时聚合的品种上限, 超出的新品种Tick被丢弃; 0 表示不限制
+	IdleTimeout Duration `json:"idle_timeout"` // 品种多久没有Tick后回收, 默认 1h; 负数表示不回收
+}
+
+func loadWorkerPoolConfig(f *configfile.File) (WorkerPoolConfig, error) {
+	cfg := WorkerPoolConfig{}
+	if _, err := f.LoadJSONEnv("WORKER_POOL", &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.Workers < 0 || cfg.MaxSymbols < 0 {
+		return cfg, fmt.Errorf("WORKER_POOL: workers and max_symbols must not be negative")
+	}
+	return cfg.withDefaults(), nil
+}
+
+func (c WorkerPoolConfig) withDefaults() WorkerPoolConfig {
+	if c.Workers == 0 {
+		c.Workers = runtime.NumCPU()
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = Duration(defaultIdleTimeout)
+	}
+	return c
+}
+
+// tickQueue 单个品种的有界Tick队列
+//
+// 队列不绑定 goroutine: 有Tick时把自己挂到所属分片的就绪列表上,
+// 由分片工人批量取出处理. scheduled 保证同一品种同时只被一个工人处理, Tick顺序不变.
+type tickQueue struct {
+	symbol   string
+	agg      *SymbolAggregator
+	filter   *TickFilter   // 为空时不过滤
+	archiver *TickArchiver // 为空时不归档
+	policy   string
+	size     int
+	shard    *poolShard
+
+	mu        sync.Mutex
+	notFull   *sync.Cond
+	ticks     []CleanTick
+	scheduled bool      // 已在就绪列表中或正在被处理
+	evicted   bool      // 已被回收, 不再接受Tick
+	lastTick  time.Time // 最近一次入队的本机时间
+}
+
+func newTickQueue(agg *SymbolAggregator, filter *TickFilter, cfg SymbolConfig, shard *poolShard) *tickQueue {
+	q := &tickQueue{
+		symbol:   agg.Symbol,
+		agg:      agg,
+		filter:   filter,
+		policy:   cfg.Backpressure,
+		size:     cfg.QueueSize,
+		shard:    shard,
+		lastTick: time.Now(),
+	}
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// push 入队; 队列已被回收时返回 ok=false, 队列满时按策略处理, 有Tick被丢弃或合并时 dropped=true
+func (q *tickQueue) push(tick CleanTick) (ok, dropped bool) {
+	q.mu.Lock()
+	for q.policy == BackpressureBlock && len(q.ticks) >= q.size && !q.evicted {
+		q.notFull.Wait()
+	}
+	if q.evicted {
+		q.mu.Unlock()
+		return false, false
+	}
+
+	switch {
+	case len(q.ticks) < q.size:
+		q.ticks = append(q.ticks, tick)
+	case q.policy == BackpressureCoalesce:
+		last := &q.ticks[len(q.ticks)-1]
+		tick.Volume += last.Volume
+		*last = tick
+		dropped = true
+	default:
+		q.ticks = append(q.ticks[1:], tick)
+		dropped = true
+	}
+	q.lastTick = time.Now()
+	schedule := !q.scheduled
+	q.scheduled = true
+	q.mu.Unlock()
+
+	if schedule && q.shard != nil {
+		q.shard.schedule(q)
+	}
+	return true, dropped
+}
+
+// take 取出队列中的全部Tick
+func (q *tickQueue) take() []CleanTick {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ticks := q.ticks
+	q.ticks = nil
+	q.notFull.Broadcast()
+	return ticks
+}
+
+// finish 处理完一批后调用; 期间又有新Tick时返回 true, 需要重新调度
+func (q *tickQueue) finish() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.ticks) > 0 {
+		return true
+	}
+	q.scheduled = false
+	q.notFull.Broadcast() // 唤醒等待处理结束的 stop
+	return false
+}
+
+// stop 回收队列并丢弃未处理的Tick, 等待工人处理完正在进行的一批后返回
+func (q *tickQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.evicted = true
+	q.ticks = nil
+	q.notFull.Broadcast()
+	for q.scheduled {
+		q.notFull.Wait()
+	}
+}
+
+func (q *tickQueue) lastTickAt() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.lastTick
+}
+
+func (q *tickQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ticks)
+}
+
+// evictIfIdle 队列为空且自 since 起没有新Tick时标记为已回收
+func (q *tickQueue) evictIfIdle(since time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.scheduled || len(q.ticks) > 0 || q.lastTick.After(since) {
+		return false
+	}
+	q.evicted = true
+	q.notFull.Broadcast()
+	return true
+}
+
+// poolShard 一个分片工人及其就绪列表
+type poolShard struct {
+	mu    sync.Mutex
+	ready []*tickQueue
+	wake  chan struct{}
+}
+
+func newPoolShard() *poolShard {
+	return &poolShard{wake: make(chan struct{}, 1)}
+}
+
+func (s *poolShard) schedule(q *tickQueue) {
+	s.mu.Lock()
+	s.ready = append(s.ready, q)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next 阻塞直到有就绪的队列; ctx 结束时返回 nil
+func (s *poolShard) next(ctx context.Context) *tickQueue {
+	for {
+		s.mu.Lock()
+		if len(s.ready) > 0 {
+			q := s.ready[0]
+			s.ready[0] = nil
+			s.ready = s.ready[1:]
+			s.mu.Unlock()
+			return q
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.wake:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// run 分片工人: 轮流处理就绪的品种, 每次处理一个品种当前积压的全部Tick
+// filter 不为空时, 未通过质量过滤的Tick不进入聚合, 也不归档 (db 服务由归档的Tick补齐缺失的K线)
+func (s *poolShard) run(ctx context.Context) {
+	for {
+		q := s.next(ctx)
+		if q == nil {
+			return
+		}
+		aggregated := 0
+		for _, tick := range q.take() {
+			if q.filter != nil && !q.filter.Accept(tick) {
+				continue
+			}
+			if q.archiver != nil {
+				q.archiver.Archive(tick)
+			}
+			q.agg.ProcessTick(tick)
+			aggregated++
+		}
+		metrics.ticksAggregated.Add(float64(aggregated), q.symbol)
+		if q.finish() {
+			s.schedule(q) // 排到队尾, 避免繁忙品种饿死同分片的其它品种
+		}
+	}
+}
+
+func (m *AggregatorManager) shardFor(symbol string) *poolShard {
+	if len(m.shards) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(symbol))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// runEviction 定期回收空闲品种, 阻塞直到 ctx 结束
+func (m *AggregatorManager) runEviction(ctx context.Context) {
+	period := m.pool.IdleTimeout.Std() / 4
+	if period < time.Second {
+		period = time.Second
+	}
+	if period > time.Minute {
+		period = time.Minute
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.EvictIdle(now)
+		}
+	}
+}
+
+// EvictIdle 回收超过 IdleTimeout 没有Tick的品种, 释放其队列、过滤器和聚合器
+//
+// 仍有未闭合K线的品种只在启用状态持久化时回收: K线保留在 StateStore 中,
+// 品种再次出现或K线到期时 (见 CloseDue) 重新创建并恢复. 未启用持久化时保留不回收.
+func (m *AggregatorManager) EvictIdle(now time.Time) int {
+	if m.pool.IdleTimeout <= 0 {
+		return 0
+	}
+	since := now.Add(-m.pool.IdleTimeout.Std())
+	resumable := m.State != nil && m.resume.Load()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	evicted := 0
+	for symbol, q := range m.queues {
+		due, open := q.agg.nextCloseAt()
+		if open && !resumable {
+			continue
+		}
+		if !q.evictIfIdle(since) {
+			continue
+		}
+		delete(m.queues, symbol)
+		delete(m.Aggregators, symbol)
+		delete(m.filters, symbol)
+		if open {
+			m.dormant[symbol] = due
+		}
+		evicted++
+	}
+	if evicted > 0 {
+		log.Printf("🧹 Evicted %d idle symbols (%d active, %d dormant with open candles)",
+			evicted, len(m.queues), len(m.dormant))
+	}
+	return evicted
+}
+
+// wakeDormant 为未闭合K线已到期的已回收品种重新创建聚合器, 使其能被定时关闭
+func (m *AggregatorManager) wakeDormant(now time.Time) {
+	var due []string
+	m.lock.RLock()
+	for symbol, at := range m.dormant {
+		if !now.Before(at) {
+			due = append(due, symbol)
+		}
+	}
+	m.lock.RUnlock()
+
+	for _, symbol := range due {
+		if _, err := m.symbolQueue(symbol); err != nil {
+			continue // 品种数已达上限, 下次再试
+		}
+		log.Printf("⏰ Woke dormant symbol %s to close due candles", symbol)
+	}
+}
+
+// resumeSymbol 从 StateStore 恢复被回收品种的未闭合K线
+func (m *AggregatorManager) resumeSymbol(sa *SymbolAggregator) {
+	fields, err := m.State.LoadSymbol(ctx, sa.Symbol)
+	if err != nil {
+		log.Printf("ERROR: Failed to resume candle state of %s: %v", sa.Symbol, err)
+		return
+	}
+	if restored, _ := m.restoreSymbol(ctx, sa, fields); restored > 0 {
+		log.Printf("♻️  Resumed %d in-progress candles for %s", restored, sa.Symbol)
+	}
+}
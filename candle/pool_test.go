@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestQueue(policy string, size int) *tickQueue {
+	cfg := SymbolConfigs{"*": {Timeframes: []string{"M1"}, Backpressure: policy, QueueSize: size}}.For("XAUUSD")
+	return newTickQueue(NewSymbolAggregator("XAUUSD", cfg, &recordingPublisher{}), nil, cfg, nil)
+}
+
+func newPoolTestManager(t *testing.T, store StateStore, pub CandlePublisher, pool WorkerPoolConfig) *AggregatorManager {
+	m := NewAggregatorManager(t.Context(), pub, SymbolConfigs{"*": {Timeframes: []string{"M1"}}}, pool)
+	m.State = store
+	return m
+}
+
+// waitIdle 等待所有品种队列处理完毕
+func waitIdle(t *testing.T, m *AggregatorManager) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		busy := false
+		m.lock.RLock()
+		for _, q := range m.queues {
+			q.mu.Lock()
+			busy = busy || q.scheduled
+			q.mu.Unlock()
+		}
+		m.lock.RUnlock()
+		if !busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("queues not drained in time")
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	q := newTestQueue(BackpressureDropOldest, 3)
+	for i := 0; i < 5; i++ {
+		_, dropped := q.push(testTick(t, fmt.Sprintf("2025-11-24T10:00:0%dZ", i), 2000+float64(i)))
+		if dropped != (i >= 3) {
+			t.Fatalf("tick %d: dropped=%v", i, dropped)
+		}
+	}
+	ticks := q.take()
+	if len(ticks) != 3 || ticks[0].Bid != 2002 || ticks[2].Bid != 2004 {
+		t.Fatalf("want the 3 newest ticks, got %+v", ticks)
+	}
+}
+
+func TestQueueCoalesce(t *testing.T) {
+	q := newTestQueue(BackpressureCoalesce, 3)
+	for i := 0; i < 5; i++ {
+		q.push(testTick(t, fmt.Sprintf("2025-11-24T10:00:0%dZ", i), 2000+float64(i)))
+	}
+	ticks := q.take()
+	if len(ticks) != 3 || ticks[0].Bid != 2000 || ticks[2].Bid != 2004 || ticks[2].Volume != 3 {
+		t.Fatalf("want oldest ticks kept and latest price coalesced, got %+v", ticks)
+	}
+}
+
+func TestQueueBlockWaitsForSpace(t *testing.T) {
+	q := newTestQueue(BackpressureBlock, 1)
+	q.push(testTick(t, "2025-11-24T10:00:00Z", 2000))
+
+	pushed := make(chan struct{})
+	go func() {
+		q.push(testTick(t, "2025-11-24T10:00:01Z", 2001))
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push on a full blocking queue returned immediately")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.take()
+	<-pushed
+	if ticks := q.take(); len(ticks) != 1 || ticks[0].Bid != 2001 {
+		t.Fatalf("want blocked tick queued after space freed, got %+v", ticks)
+	}
+}
+
+func TestPoolKeepsTickOrderPerSymbol(t *testing.T) {
+	pub := &recordingPublisher{}
+	m := newPoolTestManager(t, nil, pub, WorkerPoolConfig{Workers: 2, IdleTimeout: -1})
+	for i := 0; i < 50; i++ {
+		m.HandleTick(testTick(t, fmt.Sprintf("2025-11-24T10:00:%02dZ", i), 2000+float64(i)))
+	}
+	waitIdle(t, m)
+
+	last := pub.events[len(pub.events)-1].Candle
+	if last.Close != 2049 || last.High != 2049 || last.Open != 2000 || last.TickCount != 50 {
+		t.Fatalf("want all ticks applied in order, got %+v", last)
+	}
+}
+
+func TestSymbolLimit(t *testing.T) {
+	m := newPoolTestManager(t, nil, &recordingPublisher{}, WorkerPoolConfig{Workers: 1, MaxSymbols: 1, IdleTimeout: -1})
+	m.HandleTick(testTick(t, "2025-11-24T10:00:00Z", 2000))
+	tick := testTick(t, "2025-11-24T10:00:00Z", 1.1)
+	tick.Symbol = "EURUSD"
+	m.HandleTick(tick)
+	waitIdle(t, m)
+
+	if _, ok := m.Aggregators["EURUSD"]; ok || m.droppedTicks["EURUSD"] != 1 {
+		t.Fatalf("want EURUSD rejected by symbol limit, dropped=%d", m.droppedTicks["EURUSD"])
+	}
+}
+
+func TestEvictIdleResumesOpenCandles(t *testing.T) {
+	store := newMemStateStore()
+	pub := &recordingPublisher{}
+	m := newPoolTestManager(t, store, pub, WorkerPoolConfig{Workers: 1, IdleTimeout: Duration(time.Hour)})
+	if err := m.RestoreState(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	m.HandleTick(testTick(t, "2025-11-24T10:00:10Z", 2000))
+	m.HandleTick(testTick(t, "2025-11-24T10:00:20Z", 2010))
+	waitIdle(t, m)
+
+	if n := m.EvictIdle(time.Now().Add(30 * time.Minute)); n != 0 {
+		t.Fatalf("evicted %d symbols before idle timeout", n)
+	}
+	if n := m.EvictIdle(time.Now().Add(2 * time.Hour)); n != 1 {
+		t.Fatalf("want XAUUSD evicted, got %d", n)
+	}
+	if _, ok := m.dormant["XAUUSD"]; !ok {
+		t.Fatal("want evicted symbol with open candle kept as dormant")
+	}
+
+	m.HandleTick(testTick(t, "2025-11-24T10:00:30Z", 1990))
+	waitIdle(t, m)
+	last := pub.events[len(pub.events)-1].Candle
+	if last.Open != 2000 || last.High != 2010 || last.Low != 1990 || last.TickCount != 3 {
+		t.Fatalf("want candle resumed from state, got %+v", last)
+	}
+	if _, ok := m.dormant["XAUUSD"]; ok {
+		t.Fatal("want dormant entry cleared after resume")
+	}
+}
+
+func TestCloseDueWakesDormantSymbol(t *testing.T) {
+	store := newMemStateStore()
+	pub := &recordingPublisher{}
+	m := newPoolTestManager(t, store, pub, WorkerPoolConfig{Workers: 1, IdleTimeout: Duration(time.Hour)})
+	if err := m.RestoreState(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	m.HandleTick(testTick(t, "2025-11-24T10:00:10Z", 2000))
+	waitIdle(t, m)
+	m.EvictIdle(time.Now().Add(2 * time.Hour))
+
+	m.CloseDue(time.Now())
+	closed := pub.closed()
+	if len(closed) != 1 || !closed[0].StartTime.Equal(mustTime(t, "2025-11-24T10:00:00Z")) {
+		t.Fatalf("want dormant M1 bar closed by scheduler, got %+v", closed)
+	}
+}
+
+func TestPoolShardStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := goDone(func() { newPoolShard().run(ctx) })
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("want the shard worker to exit when its context ends")
+	}
+}
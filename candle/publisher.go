@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+)
+
+// CandlePublisher K线事件的输出端
+type CandlePublisher interface {
+	Publish(channel string, event PublishEvent)
+}
+
+// RedisPublisher 发布到 Redis Pub/Sub
+// async=true 时经有序队列由单个 goroutine 批量发布, 不阻塞K线聚合且 UPDATE/CLOSE 顺序不变 (实时模式);
+// 否则同步发布 (回放). prefix 不为空时加在频道名前 (回放输出不进入实时频道, 见 replayKeyPrefix)
+type RedisPublisher struct {
+	client *redis.Client
+	prefix string
+	queue  *publishQueue // 为空时同步发布
+}
+
+func NewRedisPublisher(client *redis.Client, async bool, prefix string) *RedisPublisher {
+	p := &RedisPublisher{client: client, prefix: prefix}
+	if async {
+		p.queue = newPublishQueue(OutputPubSub, pubsubQueueSize, p.write)
+	}
+	return p
+}
+
+func (p *RedisPublisher) Publish(channel string, event PublishEvent) {
+	if p.queue != nil {
+		p.queue.Publish(channel, event)
+		return
+	}
+	p.write([]queuedEvent{{channel: channel, event: event}})
+}
+
+// write 按顺序 PUBLISH 一批事件; Pub/Sub 没有订阅方时本就会丢失, 失败不重试
+func (p *RedisPublisher) write(batch []queuedEvent) {
+	pipe := p.client.Pipeline()
+	for _, e := range batch {
+		pipe.Publish(context.Background(), p.prefix+e.channel, e.event.ToJSON())
+	}
+	start := time.Now()
+	_, err := pipe.Exec(context.Background())
+	metrics.observePublish(OutputPubSub, start, err)
+	if err != nil {
+		log.Printf("ERROR: Redis Publish of %d events failed: %v", len(batch), err)
+	}
+}
+
+// Close 等待队列中的消息发布完成
+func (p *RedisPublisher) Close() error {
+	if p.queue != nil {
+		return p.queue.Close()
+	}
+	return nil
+}
+
+// Notifier 异步发布管理类通知 (Tick拒绝、行情源状态), 不阻塞调用方;
+// Close 之后的通知被丢弃, Close 等待已发出的通知完成
+type Notifier struct {
+	publish  func(channel string, payload []byte) error
+	mu       sync.Mutex
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+func NewRedisNotifier(client *redis.Client) *Notifier {
+	return &Notifier{publish: func(channel string, payload []byte) error {
+		return client.Publish(context.Background(), channel, payload).Err()
+	}}
+}
+
+func (n *Notifier) Notify(channel string, payload []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	n.inflight.Add(1)
+	go func() {
+		defer n.inflight.Done()
+		if err := n.publish(channel, payload); err != nil {
+			log.Printf("ERROR: Redis Publish to %s failed: %v", channel, err)
+		}
+	}()
+}
+
+func (n *Notifier) Close() error {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+	n.inflight.Wait()
+	return nil
+}
+
+// publishQueue 有序的发布队列: Publish 入队, 由单个 goroutine 按入队顺序分批交给 write
+//
+// 队列满时 Publish 最多等待 publishBlockTimeout, 超时后丢弃并计数, Redis 长时间不可用时
+// 不会卡住品种工人. Close 之后 (例如停机时排空超时, 仍有品种在处理) 到达或仍在等待的事件同样丢弃.
+type publishQueue struct {
+	output  string
+	entries chan queuedEvent
+	write   func([]queuedEvent)
+	stop    chan struct{} // Close 时关闭, 唤醒等待入队的 Publish
+	done    chan struct{} // run 结束 (队列已写完) 时关闭
+
+	closeLock sync.RWMutex // Publish 持读锁入队, Close 持写锁关闭队列
+	closed    bool
+	stopOnce  sync.Once
+	dropped   atomic.Int64
+}
+
+type queuedEvent struct {
+	channel string
+	event   PublishEvent
+}
+
+const (
+	pubsubQueueSize     = 100000
+	publishBatchSize    = 500
+	publishBlockTimeout = 5 * time.Second // 队列满时 Publish 的最长等待
+)
+
+func newPublishQueue(output string, size int, write func([]queuedEvent)) *publishQueue {
+	q := &publishQueue{
+		output:  output,
+		entries: make(chan queuedEvent, size),
+		write:   write,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *publishQueue) Publish(channel string, event PublishEvent) {
+	q.closeLock.RLock()
+	defer q.closeLock.RUnlock()
+	if q.closed {
+		q.drop("publisher closed")
+		return
+	}
+	entry := queuedEvent{channel: channel, event: event}
+	select {
+	case q.entries <- entry:
+		return
+	default:
+	}
+	timer := time.NewTimer(publishBlockTimeout)
+	defer timer.Stop()
+	select {
+	case q.entries <- entry:
+	case <-q.stop:
+		q.drop("publisher closed")
+	case <-timer.C:
+		q.drop("queue full")
+	}
+}
+
+func (q *publishQueue) drop(reason string) {
+	if n := q.dropped.Add(1); n%1000 == 1 {
+		log.Printf("⚠️  %s publisher: %s, dropped %d events so far", q.output, reason, n)
+	}
+	metrics.publishFailures.Inc(q.output)
+}
+
+// Close 停止接收新事件, 写完队列中剩余的事件后返回
+func (q *publishQueue) Close() error {
+	q.stopOnce.Do(func() { close(q.stop) }) // 先唤醒等待中的 Publish, 再取写锁
+	q.closeLock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.entries)
+	}
+	q.closeLock.Unlock()
+	<-q.done
+	if n := q.dropped.Load(); n > 0 {
+		log.Printf("⚠️  %s publisher: %d events were dropped", q.output, n)
+	}
+	return nil
+}
+
+func (q *publishQueue) run() {
+	defer close(q.done)
+	batch := make([]queuedEvent, 0, publishBatchSize)
+	for entry := range q.entries {
+		batch = append(batch[:0], entry)
+	drain:
+		for len(batch) < publishBatchSize {
+			select {
+			case next, ok := <-q.entries:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		q.write(batch)
+	}
+}
+
+// FilePublisher 以 NDJSON 格式追加写入文件
+type FilePublisher struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file %s: %w", path, err)
+	}
+	return &FilePublisher{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (p *FilePublisher) Publish(channel string, event PublishEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.w.Write(event.ToJSON())
+	p.w.WriteByte('\n')
+}
+
+func (p *FilePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.w.Flush(); err != nil {
+		return err
+	}
+	return p.f.Close()
+}
+
+// DBPublisher 将 CLOSE/AMEND 事件直接写入 klines 表, 其它事件忽略
+// overwrite=true 时 CLOSE 覆盖已存在的行 (用于修复后重建), 否则只补缺; AMEND 总是覆盖
+type DBPublisher struct {
+	db        *sqlx.DB
+	overwrite bool
+}
+
+func NewDBPublisher(db *sqlx.DB, overwrite bool) *DBPublisher {
+	return &DBPublisher{db: db, overwrite: overwrite}
+}
+
+func (p *DBPublisher) Publish(channel string, event PublishEvent) {
+	if event.Status != "CLOSE" && event.Status != "AMEND" {
+		return
+	}
+
+	conflict := "DO NOTHING"
+	if p.overwrite || event.Status == "AMEND" {
+		conflict = `DO UPDATE SET
+			open = EXCLUDED.open, high = EXCLUDED.high, low = EXCLUDED.low,
+			close = EXCLUDED.close, volume = EXCLUDED.volume,
+			spread_min = EXCLUDED.spread_min, spread_avg = EXCLUDED.spread_avg,
+			spread_max = EXCLUDED.spread_max, tick_count = EXCLUDED.tick_count,
+			synthetic = EXCLUDED.synthetic`
+	}
+	query := `
+		INSERT INTO klines
+			(start_time, symbol, timeframe, price_type, open, high, low, close, volume,
+			 spread_min, spread_avg, spread_max, tick_count, synthetic)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (symbol, timeframe, price_type, start_time) ` + conflict
+
+	c := event.Candle
+	start := time.Now()
+	_, err := p.db.Exec(query, c.StartTime, c.Symbol, c.Timeframe, c.PriceType, c.Open, c.High, c.Low, c.Close, c.Volume,
+		c.SpreadMin, c.SpreadAvg, c.SpreadMax, c.TickCount, c.Synthetic)
+	metrics.observePublish("db", start, err)
+	if err != nil {
+		log.Printf("ERROR: Failed to write %s %s %s to klines: %v",
+			c.Symbol, c.Timeframe, c.StartTime.Format("2006-01-02 15:04:05"), err)
+	}
+}
+
+// closedOnlyPublisher 只转发 CLOSE/AMEND 事件 (回放时跳过大量 UPDATE)
+type closedOnlyPublisher struct {
+	next CandlePublisher
+}
+
+func (p closedOnlyPublisher) Publish(channel string, event PublishEvent) {
+	if event.Status == "CLOSE" || event.Status == "AMEND" {
+		p.next.Publish(channel, event)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"shared/configfile"
+)
+
+// Redis Streams 约定 (db 写入服务和 API Hub 使用相同的名称)
+const (
+	streamKeyPrefix = "kline_stream:" // 每个品种一个 Stream: kline_stream:{symbol}
+	streamIndexKey  = "kline_streams" // Set: 所有已创建的 Stream, 供消费方发现新品种
+	streamQueueSize = 100000
+	lastSeqScan     = 100 // 重启时向前查找带 seq 的条目的最大条数
+)
+
+// 输出方式
+const (
+	OutputStream = "stream" // Redis Streams, 有序且可靠
+	OutputPubSub = "pubsub" // Redis Pub/Sub, 兼容旧的订阅方
+)
+
+// PublishConfig K线事件输出配置 (环境变量 KLINE_OUTPUT, JSON; 或配置节 output)
+type PublishConfig struct {
+	Outputs      []string `json:"outputs"`        // 默认 ["stream", "pubsub"]
+	StreamMaxLen int64    `json:"stream_max_len"` // 每个 Stream 保留的大致条数, 默认 100000
+}
+
+func loadPublishConfig(f *configfile.File) (PublishConfig, error) {
+	cfg := PublishConfig{}
+	if _, err := f.LoadJSONEnv("KLINE_OUTPUT", &cfg); err != nil {
+		return cfg, err
+	}
+	if len(cfg.Outputs) == 0 {
+		cfg.Outputs = []string{OutputStream, OutputPubSub}
+	}
+	for _, output := range cfg.Outputs {
+		if output != OutputStream && output != OutputPubSub {
+			return cfg, fmt.Errorf("KLINE_OUTPUT: unknown output %q", output)
+		}
+	}
+	if cfg.StreamMaxLen <= 0 {
+		cfg.StreamMaxLen = 100000
+	}
+	return cfg, nil
+}
+
+func streamKey(symbol string) string {
+	return streamKeyPrefix + symbol
+}
+
+// StreamPublisher 将事件按顺序追加到每个品种的 Redis Stream
+// (keyPrefix 不为空时 Stream 和索引的键名都加上前缀, 用于回放)
+//
+// Publish 只入队 (见 publishQueue), 由单个 goroutine 按入队顺序批量 XADD, 因此同一品种的
+// UPDATE/CLOSE 顺序与聚合顺序一致. 每个品种的事件带有递增的 seq,
+// 重启后从 Stream 最后一条继续编号. Redis 不可用时不断重试已出队的事件.
+type StreamPublisher struct {
+	*publishQueue
+	client    *redis.Client
+	maxLen    int64
+	keyPrefix string
+	seq       map[string]int64 // 仅由队列的 goroutine 访问
+}
+
+func NewStreamPublisher(client *redis.Client, maxLen int64, keyPrefix string) *StreamPublisher {
+	p := &StreamPublisher{
+		client:    client,
+		maxLen:    maxLen,
+		keyPrefix: keyPrefix,
+		seq:       make(map[string]int64),
+	}
+	p.publishQueue = newPublishQueue(OutputStream, streamQueueSize, p.write)
+	return p
+}
+
+// write 分配序号后 XADD 一批事件, 失败时退避重试直到成功
+// (部分写入后重试可能产生重复条目, 消费方可按 seq 去重)
+func (p *StreamPublisher) write(batch []queuedEvent) {
+	for i := range batch {
+		batch[i].event.Seq = p.nextSeq(batch[i].event.Candle.Symbol)
+	}
+
+	for attempt := 1; ; attempt++ {
+		pipe := p.client.Pipeline()
+		for _, entry := range batch {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: p.keyPrefix + streamKey(entry.event.Candle.Symbol),
+				MaxLen: p.maxLen,
+				Approx: true,
+				Values: map[string]interface{}{
+					"channel": entry.channel,
+					"seq":     entry.event.Seq,
+					"event":   entry.event.ToJSON(),
+				},
+			})
+		}
+		start := time.Now()
+		_, err := pipe.Exec(ctx)
+		metrics.observePublish(OutputStream, start, err)
+		if err == nil {
+			return
+		}
+		delay := time.Duration(min(float64(attempt), 10)) * time.Second
+		log.Printf("ERROR: Redis Stream XADD failed (attempt %d, %d events): %v. Retrying in %s...",
+			attempt, len(batch), err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// nextSeq 返回品种的下一个序号; 首次遇到品种时从 Stream 最后一条恢复并登记到索引
+func (p *StreamPublisher) nextSeq(symbol string) int64 {
+	if _, ok := p.seq[symbol]; !ok {
+		p.seq[symbol] = p.lastSeq(symbol)
+	}
+	p.seq[symbol]++
+	return p.seq[symbol]
+}
+
+func (p *StreamPublisher) lastSeq(symbol string) int64 {
+	key := p.keyPrefix + streamKey(symbol)
+	for attempt := 1; ; attempt++ {
+		msgs, err := p.client.XRevRangeN(ctx, key, "+", "-", lastSeqScan).Result()
+		if err == nil {
+			err = p.client.SAdd(ctx, p.keyPrefix+streamIndexKey, key).Err()
+		}
+		if err == nil {
+			// db 服务发布的 AMEND (M1 汇总修正) 不带 seq, 取最后一条带 seq 的
+			for _, msg := range msgs {
+				if v, ok := msg.Values["seq"]; ok {
+					seq, _ := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+					return seq
+				}
+			}
+			return 0
+		}
+		delay := time.Duration(min(float64(attempt), 10)) * time.Second
+		log.Printf("ERROR: Failed to read last seq of %s: %v. Retrying in %s...", key, err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// multiPublisher 同时输出到多个 CandlePublisher
+type multiPublisher []CandlePublisher
+
+func (m multiPublisher) Publish(channel string, event PublishEvent) {
+	for _, p := range m {
+		p.Publish(channel, event)
+	}
+}
+
+// Close 依次关闭所有实现了 io.Closer 的输出端
+func (m multiPublisher) Close() error {
+	var firstErr error
+	for _, p := range m {
+		if c, ok := p.(io.Closer); ok {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// NewCandlePublisher 按配置组合实时模式的输出
+func NewCandlePublisher(client *redis.Client, cfg PublishConfig) CandlePublisher {
+	var pubs multiPublisher
+	for _, output := range cfg.Outputs {
+		switch output {
+		case OutputStream:
+			pubs = append(pubs, NewStreamPublisher(client, cfg.StreamMaxLen, ""))
+		case OutputPubSub:
+			pubs = append(pubs, NewRedisPublisher(client, true, ""))
+		}
+	}
+	if len(pubs) == 1 {
+		return pubs[0]
+	}
+	return pubs
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+const replayKeyPrefix = "replay:" // 回放输出到 Redis 时的键名前缀 (Stream、索引和 Pub/Sub 频道)
+
+// runReplay 回放模式: 从Tick归档文件确定性地重建K线; Redis、数据库和品种配置取自 cfg
+//
+// 与实时模式相同, Tick先经过品种映射 (配置了数据库时) 和品种的质量过滤再聚合.
+// 默认输出到文件. -output stream / redis 写入 replay: 前缀下的 Stream / Pub/Sub 频道,
+// 避免历史K线进入 API Hub 的实时缓冲和指标计算; 只有加 -live 时才写入实时的键名.
+//
+//	candle [-config candle.yaml] replay -file ticks.ndjson [-speed 10] [-output file|stream|redis|db] [-out candles.ndjson] [-live]
+func runReplay(args []string, cfg *Config) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "", "tick archive file (csv or ndjson)")
+	format := fs.String("format", "", "file format: csv | ndjson (default: by extension)")
+	speed := fs.Float64("speed", 0, "speed multiplier relative to recorded time (0 = max speed)")
+	output := fs.String("output", "file", "output: file | stream | redis (pubsub only) | db")
+	outPath := fs.String("out", "candles.ndjson", "output file for -output file")
+	var defaultDB string
+	if cfg.Postgres.Configured() {
+		defaultDB = cfg.Postgres.DSN()
+	}
+	dbURL := fs.String("db", defaultDB, "database url for -output db (default: from postgres config)")
+	overwrite := fs.Bool("overwrite", false, "with -output db: overwrite existing klines rows instead of only filling gaps")
+	closedOnly := fs.Bool("closed-only", true, "only emit CLOSE events")
+	flush := fs.Bool("flush", false, "emit the last in-progress candles as CLOSE at end of file")
+	live := fs.Bool("live", false, "with -output stream|redis: write to the live streams and channels instead of the "+replayKeyPrefix+" prefix")
+	fs.Parse(args)
+
+	if *file == "" {
+		return fmt.Errorf("-file is required")
+	}
+	if *format == "" {
+		*format = formatFromPath(*file)
+	}
+
+	keyPrefix := replayKeyPrefix
+	if *live {
+		keyPrefix = ""
+		log.Printf("⚠️  Replay writes to the live Redis keys, subscribers will receive historical candles")
+	}
+
+	var pub CandlePublisher
+	switch *output {
+	case "redis":
+		client := redis.NewClient(cfg.Redis.Options())
+		defer client.Close()
+		if err := client.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("could not connect to Redis: %w", err)
+		}
+		pub = NewRedisPublisher(client, false, keyPrefix) // 同步发布, 保证顺序
+	case "stream":
+		client := redis.NewClient(cfg.Redis.Options())
+		defer client.Close()
+		if err := client.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("could not connect to Redis: %w", err)
+		}
+		sp := NewStreamPublisher(client, 0, keyPrefix)
+		defer sp.Close() // 在关闭 client 之前写完队列
+		pub = sp
+	case "file":
+		fp, err := NewFilePublisher(*outPath)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		pub = fp
+	case "db":
+		if *dbURL == "" {
+			return fmt.Errorf("-db or postgres config is required for -output db")
+		}
+		db, err := sqlx.Connect("pgx", *dbURL)
+		if err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer db.Close()
+		pub = NewDBPublisher(db, *overwrite)
+	default:
+		return fmt.Errorf("unknown output %q", *output)
+	}
+	if *closedOnly {
+		pub = closedOnlyPublisher{next: pub}
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", *file, err)
+	}
+	defer f.Close()
+
+	log.Printf("Replaying %s (format=%s, speed=%v, output=%s)", *file, *format, *speed, *output)
+
+	aggregators := make(map[string]*SymbolAggregator)
+	filters := make(map[string]*TickFilter)
+	count, rejected := 0, 0
+	sink := TickSink(func(tick CleanTick) {
+		agg, ok := aggregators[tick.Symbol]
+		if !ok {
+			symbolCfg := cfg.Symbols.For(tick.Symbol)
+			agg = NewSymbolAggregator(tick.Symbol, symbolCfg, pub)
+			aggregators[tick.Symbol] = agg
+			if symbolCfg.Filters != nil {
+				filters[tick.Symbol] = NewTickFilter(tick.Symbol, *symbolCfg.Filters, symbolCfg.session, nil)
+			}
+		}
+		if filter := filters[tick.Symbol]; filter != nil && !filter.Accept(tick) {
+			rejected++
+			return
+		}
+		agg.ProcessTick(tick)
+		count++
+	})
+
+	// 品种映射规则与实时模式一致, 只在开始时加载一次
+	if cfg.Postgres.Configured() {
+		db, err := sqlx.Connect("pgx", cfg.Postgres.DSN())
+		if err != nil {
+			return fmt.Errorf("failed to connect to database for symbol mapping: %w", err)
+		}
+		defer db.Close()
+		mapper := NewSymbolMapper(db, nil, cfg.SymbolMapping)
+		if err := mapper.Reload(ctx); err != nil {
+			return err
+		}
+		sink = mapper.Wrap(sink)
+	}
+
+	reader := newTickFileReader(f, *format, "replay")
+	var prevTs time.Time
+	bad := 0
+	started := time.Now()
+
+	for {
+		tick, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+			bad++
+			continue
+		}
+
+		// 按倍速还原Tick间隔
+		if *speed > 0 && !prevTs.IsZero() && tick.Timestamp.After(prevTs) {
+			time.Sleep(time.Duration(float64(tick.Timestamp.Sub(prevTs)) / *speed))
+		}
+		prevTs = tick.Timestamp
+
+		tick.ReceivedAt = time.Time{} // 归档中没有原始接收时间, 不做过期检查
+		sink(tick)
+	}
+
+	if *flush {
+		for _, agg := range aggregators {
+			agg.Flush()
+		}
+	}
+
+	log.Printf("✅ Replay finished: %d ticks, %d rejected, %d bad lines, %d symbols in %s",
+		count, rejected, bad, len(aggregators), time.Since(started).Round(time.Millisecond))
+	return nil
+}
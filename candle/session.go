@@ -0,0 +1,74 @@
+package main
+
+import (
+	"time"
+
+	"shared/calendar"
+)
+
+// SessionConfig 品种的交易时段配置, 定义见 shared/calendar (db 服务的缺口审计使用同一写法)
+type SessionConfig = calendar.SessionConfig
+
+// Session 编译后的交易时段, nil 表示 UTC 自然日且全天交易
+type Session struct {
+	hours *calendar.Hours
+}
+
+// compileSession 校验并编译配置
+func compileSession(c *SessionConfig) (*Session, error) {
+	hours, err := c.Compile()
+	if err != nil || hours == nil {
+		return nil, err
+	}
+	return &Session{hours: hours}, nil
+}
+
+// Location 返回交易时段的时区, nil 表示 UTC
+func (s *Session) Location() *time.Location {
+	if s == nil {
+		return time.UTC
+	}
+	return s.hours.Location()
+}
+
+// toWall 转换到交易日坐标下的"墙上时间", 见 calendar.Hours.ToWall
+func (s *Session) toWall(t time.Time) time.Time { return s.hours.ToWall(t) }
+
+// fromWall toWall 的逆变换
+func (s *Session) fromWall(wall time.Time) time.Time { return s.hours.FromWall(wall) }
+
+// WindowStart 返回 t 在该时段规则下所属K线的开始时间 (UTC)
+func (s *Session) WindowStart(tf Timeframe, t time.Time) time.Time {
+	if s == nil {
+		return tf.WindowStart(t)
+	}
+	return s.fromWall(tf.WindowStart(s.toWall(t)))
+}
+
+// Next 返回下一根K线的开始时间 (UTC)
+func (s *Session) Next(tf Timeframe, start time.Time) time.Time {
+	if s == nil {
+		return tf.Next(start)
+	}
+	return s.fromWall(tf.Next(s.toWall(start)))
+}
+
+// BarsBetween 返回两根K线开始时间之间相隔的周期数
+func (s *Session) BarsBetween(tf Timeframe, from, to time.Time) int {
+	if s == nil {
+		return tf.BarsBetween(from, to)
+	}
+	n := 0
+	for t := from; t.Before(to); t = s.Next(tf, t) {
+		n++
+	}
+	return n
+}
+
+// IsOpen 判断 t 时刻是否处于交易时段
+func (s *Session) IsOpen(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	return s.hours.IsOpen(t)
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func newYorkSession(t *testing.T) *Session {
+	t.Helper()
+	s, err := compileSession(&SessionConfig{
+		Timezone:     "America/New_York",
+		DayRoll:      "17:00",
+		WeekendClose: "Fri 17:00",
+		WeekendOpen:  "Sun 17:00",
+	})
+	if err != nil {
+		t.Fatalf("compileSession: %v", err)
+	}
+	return s
+}
+
+func TestSessionWindowStart(t *testing.T) {
+	s := newYorkSession(t)
+	d1, _ := ParseTimeframe("D1")
+	h4, _ := ParseTimeframe("H4")
+
+	cases := []struct {
+		tf   Timeframe
+		tick string
+		want string
+	}{
+		// 冬令时 (EST, UTC-5): 交易日从 22:00Z 开始
+		{d1, "2025-11-24T23:30:00Z", "2025-11-24T22:00:00Z"},
+		{d1, "2025-11-24T21:59:59Z", "2025-11-23T22:00:00Z"},
+		{h4, "2025-11-24T23:30:00Z", "2025-11-24T22:00:00Z"},
+		{h4, "2025-11-25T03:10:00Z", "2025-11-25T02:00:00Z"},
+		// 夏令时 (EDT, UTC-4): 交易日从 21:00Z 开始
+		{d1, "2025-07-01T21:30:00Z", "2025-07-01T21:00:00Z"},
+		{h4, "2025-07-02T01:30:00Z", "2025-07-02T01:00:00Z"},
+	}
+	for _, c := range cases {
+		got := s.WindowStart(c.tf, mustTime(t, c.tick))
+		if want := mustTime(t, c.want); !got.Equal(want) {
+			t.Errorf("%s WindowStart(%s) = %s, want %s", c.tf.Name, c.tick, got, want)
+		}
+	}
+}
+
+func TestSessionNextAcrossDST(t *testing.T) {
+	s := newYorkSession(t)
+	d1, _ := ParseTimeframe("D1")
+
+	// 2025-03-09 美国进入夏令时, 日切仍在纽约 17:00
+	start := mustTime(t, "2025-03-07T22:00:00Z")
+	want := []string{"2025-03-08T22:00:00Z", "2025-03-09T21:00:00Z", "2025-03-10T21:00:00Z"}
+	for _, w := range want {
+		start = s.Next(d1, start)
+		if !start.Equal(mustTime(t, w)) {
+			t.Fatalf("Next = %s, want %s", start, w)
+		}
+	}
+	if n := s.BarsBetween(d1, mustTime(t, "2025-03-07T22:00:00Z"), start); n != 3 {
+		t.Errorf("BarsBetween = %d, want 3", n)
+	}
+}
+
+func TestSessionIsOpen(t *testing.T) {
+	s := newYorkSession(t)
+	cases := map[string]bool{
+		"2025-11-28T21:59:00Z": true,  // 周五 16:59 纽约
+		"2025-11-28T22:00:00Z": false, // 周五 17:00 收盘
+		"2025-11-29T12:00:00Z": false, // 周六
+		"2025-11-30T21:59:00Z": false, // 周日 16:59
+		"2025-11-30T22:00:00Z": true,  // 周日 17:00 开盘
+		"2025-12-02T12:00:00Z": true,
+	}
+	for ts, want := range cases {
+		if got := s.IsOpen(mustTime(t, ts)); got != want {
+			t.Errorf("IsOpen(%s) = %v, want %v", ts, got, want)
+		}
+	}
+
+	var utc *Session
+	if !utc.IsOpen(mustTime(t, "2025-11-29T12:00:00Z")) {
+		t.Errorf("nil session should always be open")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"shared/configfile"
+)
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// loadShutdownTimeout 读取停机时等待排空的最长时间 (环境变量 SHUTDOWN_TIMEOUT 或配置节 shutdown_timeout, 如 "30s")
+func loadShutdownTimeout(f *configfile.File) (time.Duration, error) {
+	raw, ok := f.Lookup("SHUTDOWN_TIMEOUT")
+	if !ok {
+		return defaultShutdownTimeout, nil
+	}
+	return configfile.ParseDuration(raw)
+}
+
+// Drain 等待所有品种队列中的Tick处理完毕; 行情源应已停止, 否则可能一直等不到
+func (m *AggregatorManager) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if m.pending() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("⚠️  Drain timed out with %d symbols still busy", m.pending())
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// pending 返回仍有Tick待处理或正在处理的品种数
+func (m *AggregatorManager) pending() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	busy := 0
+	for _, q := range m.queues {
+		q.mu.Lock()
+		if q.scheduled || len(q.ticks) > 0 {
+			busy++
+		}
+		q.mu.Unlock()
+	}
+	return busy
+}
+
+// goDone 在 goroutine 中执行 fn, 返回 fn 结束时关闭的通道
+func goDone(fn func()) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	return done
+}
+
+// waitDone 等待 done 关闭, ctx 超时则放弃并记录
+func waitDone(ctx context.Context, what string, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("⚠️  Gave up waiting for %s: %v", what, ctx.Err())
+	}
+}
+
+// closeOutput 关闭实现了 io.Closer 的输出端 (等待排队和进行中的发布完成)
+func closeOutput(ctx context.Context, what string, v interface{}) {
+	c, ok := v.(io.Closer)
+	if !ok {
+		return
+	}
+	waitDone(ctx, what, goDone(func() {
+		if err := c.Close(); err != nil {
+			log.Printf("ERROR: Failed to close %s: %v", what, err)
+		}
+	}))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// blockingPublisher 在 release 关闭前阻塞每次发布
+type blockingPublisher struct {
+	release chan struct{}
+	mu      sync.Mutex
+	events  int
+}
+
+func (p *blockingPublisher) Publish(channel string, event PublishEvent) {
+	<-p.release
+	p.mu.Lock()
+	p.events++
+	p.mu.Unlock()
+}
+
+func TestDrainWaitsForQueuedTicks(t *testing.T) {
+	pub := &blockingPublisher{release: make(chan struct{})}
+	m := newPoolTestManager(t, nil, pub, WorkerPoolConfig{Workers: 1, IdleTimeout: -1})
+	for i := 0; i < 10; i++ {
+		m.HandleTick(testTick(t, fmt.Sprintf("2025-11-24T10:00:%02dZ", i), 2000+float64(i)))
+	}
+
+	short, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := m.Drain(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want drain to time out while publishes are blocked, got %v", err)
+	}
+
+	close(pub.release)
+	if err := m.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m.pending() != 0 || pub.events != 10 {
+		t.Fatalf("want every queued tick processed, pending=%d events=%d", m.pending(), pub.events)
+	}
+}
+
+// closingPublisher 记录是否被关闭
+type closingPublisher struct {
+	recordingPublisher
+	closed bool
+}
+
+func (p *closingPublisher) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestMultiPublisherClosesOutputs(t *testing.T) {
+	closer := &closingPublisher{}
+	pub := multiPublisher{&recordingPublisher{}, closer}
+	closeOutput(context.Background(), "test", pub)
+	if !closer.closed {
+		t.Fatal("want closable outputs closed")
+	}
+}
+
+func TestStreamPublisherDropsAfterClose(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	p := NewStreamPublisher(client, 0, "")
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 排空超时后仍在处理的品种可能继续发布, 不能因向已关闭的队列发送而 panic
+	p.Publish("kline:XAUUSD:M1", PublishEvent{Status: "UPDATE", Candle: Candle{Symbol: "XAUUSD"}})
+	if n := p.dropped.Load(); n != 1 {
+		t.Fatalf("want the late event dropped and counted, got %d", n)
+	}
+}
+
+func TestPublishQueueReleasesBlockedPublishOnClose(t *testing.T) {
+	writing, release := make(chan struct{}, 1), make(chan struct{})
+	var written []string
+	q := newPublishQueue(OutputPubSub, 1, func(batch []queuedEvent) {
+		writing <- struct{}{}
+		<-release
+		for _, e := range batch {
+			written = append(written, e.event.Status)
+		}
+	})
+	q.Publish("c", PublishEvent{Status: "1"})
+	<-writing // 第一条已出队, 写入被 Redis 卡住
+	q.Publish("c", PublishEvent{Status: "2"})
+
+	// 队列已满, 第三条等待入队; Close 应立即唤醒它而不是等到超时
+	blocked := goDone(func() { q.Publish("c", PublishEvent{Status: "3"}) })
+	closed := goDone(func() { q.Close() })
+	select {
+	case <-blocked:
+	case <-time.After(publishBlockTimeout / 2):
+		t.Fatal("want the blocked publish released by Close")
+	}
+	close(release)
+	<-closed
+	if n := q.dropped.Load(); n != 1 || len(written) != 2 || written[0] != "1" || written[1] != "2" {
+		t.Fatalf("want 1 and 2 written in order and 3 dropped, got %v (dropped %d)", written, n)
+	}
+}
+
+func TestNotifierCloseWaitsForInflight(t *testing.T) {
+	release := make(chan struct{})
+	var sent []string
+	n := &Notifier{publish: func(channel string, payload []byte) error {
+		<-release
+		sent = append(sent, channel)
+		return nil
+	}}
+	n.Notify("feed_status", []byte("{}"))
+
+	closed := goDone(func() { n.Close() })
+	select {
+	case <-closed:
+		t.Fatal("want Close to wait for the outstanding notification")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+	n.Notify("feed_status", []byte("{}")) // 停机后的通知被丢弃
+	if len(sent) != 1 {
+		t.Fatalf("want exactly the notification sent before Close, got %v", sent)
+	}
+}
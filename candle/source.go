@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/configfile"
+)
+
+// TickSink 接收清洗后的Tick (通常是 AggregatorManager.HandleTick)
+type TickSink func(tick CleanTick)
+
+// TickSource 行情源: 每个源独立连接、独立重连, 产出的Tick带上自己的名称
+type TickSource interface {
+	Name() string
+	Run(ctx context.Context, sink TickSink) // 阻塞直到 ctx 结束
+}
+
+// SourceConfig 单个行情源的配置
+type SourceConfig struct {
+	Name     string `json:"name"`     // 来源标签, 写入 CleanTick.Source
+	Type     string `json:"type"`     // "ws" | "redis_stream" | "fix" | "file"
+	URL      string `json:"url"`      // ws: 上游WebSocket地址
+	Addr     string `json:"addr"`     // redis_stream: Redis地址 (默认与 redis 配置相同); fix: TCP地址
+	Password string `json:"password"` // redis_stream: Redis密码
+	Stream   string `json:"stream"`   // redis_stream: Stream key
+	Path     string `json:"path"`     // file: 文件路径
+	Format   string `json:"format"`   // file: "csv" | "ndjson" (默认按扩展名判断)
+	Loop     bool   `json:"loop"`     // file: 读完后从头重放
+
+	Account      string              `json:"account"`      // ws: 上游账户, 替换 url 中的 {account}
+	Subscription *SubscriptionConfig `json:"subscription"` // ws: 连接后发送的订阅命令
+
+	Group    string `json:"group"`    // 同组的源互为备份, 同一时刻只采用其中一个 (见 FailoverSource)
+	Priority int    `json:"priority"` // 组内优先级, 数值小的优先; 相同时按配置顺序
+
+	RetryDelay    Duration `json:"retry_delay"`     // 首次重连间隔, 默认1秒, 之后指数退避
+	MaxRetryDelay Duration `json:"max_retry_delay"` // 重连间隔上限, 默认1分钟
+
+	ReadTimeout    Duration `json:"read_timeout"`    // ws/fix: 超过该时长没有收到任何数据 (含心跳/pong) 时断开重连, 默认30秒
+	PingInterval   Duration `json:"ping_interval"`   // ws: 发送 ping 的间隔, 默认 read_timeout/3
+	StaleAfter     Duration `json:"stale_after"`     // 交易时段内品种超过该时长没有报价视为过期, 0 表示不检测
+	StaleReconnect string   `json:"stale_reconnect"` // 报价过期时: all(默认) | any | never
+
+	watchdog *feedWatchdog // 由 NewTickSources 创建
+}
+
+// loadSourceConfigs 从环境变量 TICK_SOURCES (JSON数组) 或配置节 upstream 读取并校验行情源列表, 必须至少配置一个
+func loadSourceConfigs(f *configfile.File) ([]SourceConfig, error) {
+	var configs []SourceConfig
+	found, err := f.LoadJSONEnv("TICK_SOURCES", &configs)
+	if err != nil {
+		return nil, err
+	}
+	if !found || len(configs) == 0 {
+		return nil, fmt.Errorf("no tick sources configured, set upstream in the config file or TICK_SOURCES")
+	}
+
+	seen := make(map[string]bool)
+	for i := range configs {
+		if configs[i].Name == "" {
+			configs[i].Name = fmt.Sprintf("%s-%d", configs[i].Type, i)
+		}
+		if seen[configs[i].Name] {
+			return nil, fmt.Errorf("duplicate source name: %s", configs[i].Name)
+		}
+		seen[configs[i].Name] = true
+	}
+	for i := range configs {
+		cfg := &configs[i]
+		if cfg.Group != "" && seen[cfg.Group] {
+			return nil, fmt.Errorf("source group %s has the same name as a source", cfg.Group)
+		}
+		if err := cfg.normalize(); err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+// NewTickSources 创建全部行情源; 配置了 group 的源按组合并为 FailoverSource.
+// monitor 为报价过期检测提供交易时段和状态通知, 可以为空
+func NewTickSources(configs []SourceConfig, monitor *FeedMonitor) ([]TickSource, error) {
+	var result []TickSource
+	groups := make(map[string]*FailoverSource)
+	for _, cfg := range configs {
+		cfg.watchdog = newFeedWatchdog(cfg, monitor)
+		source, err := NewTickSource(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Group == "" {
+			result = append(result, source)
+			continue
+		}
+		group, ok := groups[cfg.Group]
+		if !ok {
+			group = NewFailoverSource(cfg.Group)
+			groups[cfg.Group] = group
+			result = append(result, group)
+		}
+		group.add(source, cfg.Priority, cfg.watchdog)
+	}
+	for _, group := range groups {
+		sort.SliceStable(group.members, func(i, j int) bool {
+			return group.members[i].priority < group.members[j].priority
+		})
+	}
+	return result, nil
+}
+
+// NewTickSource 根据配置创建行情源
+func NewTickSource(cfg SourceConfig) (TickSource, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	if cfg.watchdog == nil {
+		cfg.watchdog = newFeedWatchdog(cfg, nil) // 连接状态记录在 watchdog 上
+	}
+	switch cfg.Type {
+	case "ws":
+		cfg.URL = strings.ReplaceAll(cfg.URL, "{account}", cfg.Account)
+		return NewWSSource(cfg), nil
+	case "redis_stream":
+		return NewRedisStreamSource(cfg), nil
+	case "fix":
+		return NewFIXLineSource(cfg), nil
+	default:
+		return NewFileSource(cfg), nil
+	}
+}
+
+// normalize 填充默认值并校验配置; loadConfig 读取配置时即调用, 不必等到连接行情源
+func (cfg *SourceConfig) normalize() error {
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = Duration(time.Second)
+	}
+	if cfg.MaxRetryDelay <= 0 {
+		cfg.MaxRetryDelay = Duration(time.Minute)
+	}
+	if cfg.MaxRetryDelay < cfg.RetryDelay {
+		return fmt.Errorf("source %s: max_retry_delay must not be less than retry_delay", cfg.Name)
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = Duration(30 * time.Second)
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = cfg.ReadTimeout / 3
+	}
+	if cfg.PingInterval >= cfg.ReadTimeout {
+		return fmt.Errorf("source %s: ping_interval must be less than read_timeout", cfg.Name)
+	}
+	if cfg.StaleAfter < 0 {
+		return fmt.Errorf("source %s: stale_after must not be negative", cfg.Name)
+	}
+	switch cfg.StaleReconnect {
+	case "", StaleReconnectAll, StaleReconnectAny, StaleReconnectNever:
+	default:
+		return fmt.Errorf("source %s: unknown stale_reconnect %q", cfg.Name, cfg.StaleReconnect)
+	}
+	if cfg.Subscription != nil && cfg.Type != "ws" {
+		return fmt.Errorf("source %s: subscription is only supported by ws sources", cfg.Name)
+	}
+
+	switch cfg.Type {
+	case "ws":
+		if cfg.URL == "" {
+			return fmt.Errorf("source %s: url is required", cfg.Name)
+		}
+		if err := cfg.Subscription.validate(); err != nil {
+			return fmt.Errorf("source %s: %w", cfg.Name, err)
+		}
+	case "redis_stream":
+		if cfg.Stream == "" {
+			return fmt.Errorf("source %s: stream is required", cfg.Name)
+		}
+	case "fix":
+		if cfg.Addr == "" {
+			return fmt.Errorf("source %s: addr is required", cfg.Name)
+		}
+	case "file":
+		if cfg.Path == "" {
+			return fmt.Errorf("source %s: path is required", cfg.Name)
+		}
+	default:
+		return fmt.Errorf("source %s: unknown type %q", cfg.Name, cfg.Type)
+	}
+	return nil
+}
+
+// runWithReconnect 反复执行 session, 断开后按指数退避 (带随机抖动) 重试, 直到 ctx 结束.
+// 会话曾经连接成功时, 退避从 RetryDelay 重新开始; 报价过期检测要求重连时取消会话
+func runWithReconnect(ctx context.Context, cfg SourceConfig, sink TickSink, session func(ctx context.Context, sink TickSink) error) {
+	name := cfg.Name
+	watchdog := cfg.watchdog
+	sink = watchdog.wrap(sink)
+	retry := newBackoff(cfg.RetryDelay.Std(), cfg.MaxRetryDelay.Std())
+	watchdog.setConnected(false)
+	for ctx.Err() == nil {
+		sessionCtx, cancel := context.WithCancelCause(ctx)
+		stopWatch := watchdog.watch(sessionCtx, cancel)
+		err := session(sessionCtx, sink)
+		stopWatch()
+		if errors.Is(context.Cause(sessionCtx), errFeedStale) {
+			err = errFeedStale
+		}
+		cancel(nil)
+
+		connected := watchdog.isConnected()
+		if connected {
+			retry.reset()
+		}
+		watchdog.setConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			reason := ""
+			if err != nil {
+				reason = err.Error()
+			}
+			watchdog.notify("", FeedDisconnected, reason, time.Time{})
+		}
+		metrics.sourceReconnects.Inc(name)
+		delay := retry.next()
+		if err != nil {
+			log.Printf("ERROR: [%s] %v. Reconnecting in %s...", name, err, delay.Round(time.Millisecond))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// markConnected 会话建立连接后调用, 更新连接状态并发布状态事件
+func markConnected(cfg SourceConfig) {
+	cfg.watchdog.setConnected(true)
+	cfg.watchdog.notify("", FeedConnected, "", time.Time{})
+}
+
+// backoff 重连间隔: 每次失败翻倍直到上限, 实际等待取 [d/2, d) 内的随机值,
+// 避免多个源 (或多个实例) 在上游恢复时同时重连
+type backoff struct {
+	initial, max time.Duration
+	current      time.Duration
+}
+
+func newBackoff(initial, max time.Duration) *backoff {
+	return &backoff{initial: initial, max: max}
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.initial
+	} else if b.current *= 2; b.current > b.max {
+		b.current = b.max
+	}
+	half := b.current / 2
+	return half + rand.N(b.current-half)
+}
+
+func (b *backoff) reset() { b.current = 0 }
+
+// --- 通用解析工具 ---
+
+const upstreamTimeLayout = "2006-01-02T15:04:05"
+
+// parseTickTime 兼容上游格式、RFC3339 和 Unix毫秒时间戳
+func parseTickTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if ts, err := time.ParseInLocation(upstreamTimeLayout, s, time.UTC); err == nil {
+		return ts, nil
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return ts.UTC(), nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time format: %s", s)
+}
+
+// cleanSymbol 去掉经纪商后缀 (按第一个 "." 分割)
+func cleanSymbol(symbol string) string {
+	if strings.Contains(symbol, ".") {
+		parts := strings.SplitN(symbol, ".", 2)
+		return parts[0]
+	}
+	return symbol
+}
+
+// flatTick 非MT4源使用的扁平Tick格式 (ndjson / redis stream)
+type flatTick struct {
+	Symbol string  `json:"symbol"`
+	Bid    float64 `json:"bid"`
+	Ask    float64 `json:"ask"`
+	Time   string  `json:"time"`
+	Volume int64   `json:"volume"`
+	Spread float64 `json:"spread"`
+}
+
+func (f flatTick) toCleanTick(source string) (CleanTick, error) {
+	if f.Symbol == "" {
+		return CleanTick{}, fmt.Errorf("missing symbol")
+	}
+	ts, err := parseTickTime(f.Time)
+	if err != nil {
+		return CleanTick{}, err
+	}
+	volume := f.Volume
+	if volume <= 0 {
+		volume = 1
+	}
+	return CleanTick{
+		Symbol:     cleanSymbol(f.Symbol),
+		RawSymbol:  f.Symbol,
+		Price:      f.Bid,
+		Volume:     volume,
+		Timestamp:  ts,
+		Source:     source,
+		Bid:        f.Bid,
+		Ask:        f.Ask,
+		Spread:     f.Spread,
+		ReceivedAt: time.Now().UTC(),
+	}, nil
+}
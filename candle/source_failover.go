@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// FailoverSource 一组互为备份的行情源 (同一平台的多个账户, 或报同一批品种的不同平台)
+//
+// 成员同时连接 (热备), 但只采用可用 (已连接且报价未全部过期) 且优先级最高的成员的Tick;
+// 该成员不可用后立即切换到下一个可用的成员, 它恢复后再切回.
+type FailoverSource struct {
+	name    string
+	members []failoverMember // 按优先级排序
+
+	mu     sync.Mutex
+	active string // 当前采用的成员, 没有已连接的成员时为空
+}
+
+type failoverMember struct {
+	source   TickSource
+	priority int
+	watchdog *feedWatchdog // 可以为空
+}
+
+func NewFailoverSource(name string) *FailoverSource {
+	return &FailoverSource{name: name}
+}
+
+func (f *FailoverSource) Name() string { return f.name }
+
+func (f *FailoverSource) add(source TickSource, priority int, watchdog *feedWatchdog) {
+	f.members = append(f.members, failoverMember{source: source, priority: priority, watchdog: watchdog})
+	metrics.sourceActive.Set(0, f.name, source.Name())
+}
+
+func (f *FailoverSource) Run(ctx context.Context, sink TickSink) {
+	var wg sync.WaitGroup
+	for _, m := range f.members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.source.Run(ctx, f.gate(m.source.Name(), sink))
+		}()
+	}
+	wg.Wait()
+}
+
+// gate 只放行当前采用的成员的Tick
+func (f *FailoverSource) gate(name string, sink TickSink) TickSink {
+	return func(tick CleanTick) {
+		if f.current() == name {
+			sink(tick)
+		}
+	}
+}
+
+// current 返回可用的成员中优先级最高的一个, 发生切换时记录日志
+func (f *FailoverSource) current() string {
+	active := ""
+	for _, m := range f.members {
+		if m.watchdog.isConnected() && !m.watchdog.isDown() {
+			active = m.source.Name()
+			break
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if active != f.active {
+		if f.active != "" {
+			metrics.sourceActive.Set(0, f.name, f.active)
+		}
+		if active != "" {
+			metrics.sourceActive.Set(1, f.name, active)
+		}
+		log.Printf("🔀 [%s] Failover: %q -> %q", f.name, f.active, active)
+		f.active = active
+	}
+	return active
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FIXLineSource FIX风格的行协议 (TCP, 每行一条报价)
+// 字段以 SOH(0x01) 或 '|' 分隔, 例如:
+//
+//	35=W|55=XAUUSD|132=2650.12|133=2650.45|52=20251124-19:45:19.123
+//
+// 使用的tag: 55=Symbol, 132=BidPx, 133=OfferPx, 52=SendingTime, 271=MDEntrySize(可选)
+type FIXLineSource struct {
+	cfg SourceConfig
+}
+
+func NewFIXLineSource(cfg SourceConfig) *FIXLineSource {
+	return &FIXLineSource{cfg: cfg}
+}
+
+func (s *FIXLineSource) Name() string { return s.cfg.Name }
+
+func (s *FIXLineSource) Run(ctx context.Context, sink TickSink) {
+	runWithReconnect(ctx, s.cfg, sink, s.session)
+}
+
+func (s *FIXLineSource) session(ctx context.Context, sink TickSink) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", s.cfg.Addr, err)
+	}
+	defer conn.Close()
+	log.Printf("[%s] Connected to FIX line feed %s", s.cfg.Name, s.cfg.Addr)
+	markConnected(s.cfg)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// 上游至少按心跳间隔发送数据 (35=0), 超过 read_timeout 没有数据视为连接已失效
+	readTimeout := s.cfg.ReadTimeout.Std()
+	scanner := bufio.NewScanner(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		tick, ok, err := parseFIXLine(line, s.cfg.Name)
+		if err != nil {
+			log.Printf("WARNING: [%s] Bad FIX line: %v", s.cfg.Name, err)
+			metrics.parseErrors.Inc(s.cfg.Name)
+			continue
+		}
+		if ok {
+			sink(tick)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read error: %w", err)
+	}
+	return fmt.Errorf("connection closed by peer")
+}
+
+// parseFIXLine 解析一行报价; 非行情消息(如心跳 35=0)返回 ok=false
+func parseFIXLine(line, source string) (CleanTick, bool, error) {
+	sep := "|"
+	if strings.Contains(line, "\x01") {
+		sep = "\x01"
+	}
+
+	fields := make(map[string]string)
+	for _, kv := range strings.Split(line, sep) {
+		if k, v, found := strings.Cut(kv, "="); found {
+			fields[k] = v
+		}
+	}
+
+	if msgType, ok := fields["35"]; ok && msgType != "W" && msgType != "X" {
+		return CleanTick{}, false, nil
+	}
+
+	symbol := fields["55"]
+	if symbol == "" {
+		return CleanTick{}, false, fmt.Errorf("missing tag 55 (Symbol)")
+	}
+	bid, err := strconv.ParseFloat(fields["132"], 64)
+	if err != nil {
+		return CleanTick{}, false, fmt.Errorf("invalid tag 132 (BidPx): %q", fields["132"])
+	}
+
+	var ask float64
+	if v := fields["133"]; v != "" {
+		if ask, err = strconv.ParseFloat(v, 64); err != nil {
+			return CleanTick{}, false, fmt.Errorf("invalid tag 133 (OfferPx): %q", v)
+		}
+	}
+
+	now := time.Now().UTC()
+	ts := now
+	if v := fields["52"]; v != "" {
+		if ts, err = parseFIXTime(v); err != nil {
+			return CleanTick{}, false, err
+		}
+	}
+
+	volume := int64(1)
+	if v := fields["271"]; v != "" {
+		if size, err := strconv.ParseFloat(v, 64); err == nil && size > 0 {
+			volume = int64(size)
+		}
+	}
+
+	return CleanTick{
+		Symbol:     cleanSymbol(symbol),
+		RawSymbol:  symbol,
+		Price:      bid,
+		Volume:     volume,
+		Timestamp:  ts,
+		Source:     source,
+		Bid:        bid,
+		Ask:        ask,
+		ReceivedAt: now,
+	}, true, nil
+}
+
+// parseFIXTime 解析 UTCTimestamp: YYYYMMDD-HH:MM:SS[.sss]
+func parseFIXTime(s string) (time.Time, error) {
+	for _, layout := range []string{"20060102-15:04:05.000", "20060102-15:04:05"} {
+		if ts, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return ts, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid tag 52 (SendingTime): %q", s)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"shared/configfile"
+)
+
+// RedisStreamSource 从 Redis Stream 读取Tick
+// 每条消息的字段: symbol, bid, ask, time, spread(可选), volume(可选)
+type RedisStreamSource struct {
+	cfg    SourceConfig
+	lastID string // 记录已读位置, 重连后继续
+}
+
+func NewRedisStreamSource(cfg SourceConfig) *RedisStreamSource {
+	if cfg.Addr == "" {
+		cfg.Addr = configfile.DefaultRedisAddr
+	}
+	return &RedisStreamSource{cfg: cfg, lastID: "$"}
+}
+
+func (s *RedisStreamSource) Name() string { return s.cfg.Name }
+
+func (s *RedisStreamSource) Run(ctx context.Context, sink TickSink) {
+	runWithReconnect(ctx, s.cfg, sink, s.session)
+}
+
+func (s *RedisStreamSource) session(ctx context.Context, sink TickSink) error {
+	client := redis.NewClient(&redis.Options{Addr: s.cfg.Addr, Password: s.cfg.Password})
+	defer client.Close()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to connect to redis %s: %w", s.cfg.Addr, err)
+	}
+	log.Printf("[%s] Reading Redis stream %s from %s", s.cfg.Name, s.cfg.Stream, s.cfg.Addr)
+	markConnected(s.cfg)
+
+	for {
+		streams, err := client.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{s.cfg.Stream, s.lastID},
+			Count:   500,
+			Block:   5 * time.Second,
+		}).Result()
+		if err == redis.Nil {
+			continue // 阻塞超时, 没有新消息
+		}
+		if err != nil {
+			return fmt.Errorf("stream read error: %w", err)
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				s.lastID = msg.ID
+				tick, err := streamMessageToTick(msg.Values, s.cfg.Name)
+				if err != nil {
+					log.Printf("WARNING: [%s] Bad stream entry %s: %v", s.cfg.Name, msg.ID, err)
+					metrics.parseErrors.Inc(s.cfg.Name)
+					continue
+				}
+				sink(tick)
+			}
+		}
+	}
+}
+
+func streamMessageToTick(values map[string]interface{}, source string) (CleanTick, error) {
+	str := func(key string) string {
+		if v, ok := values[key]; ok {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+
+	f := flatTick{Symbol: str("symbol"), Time: str("time")}
+	var err error
+	if f.Bid, err = strconv.ParseFloat(str("bid"), 64); err != nil {
+		return CleanTick{}, fmt.Errorf("invalid bid: %w", err)
+	}
+	if v := str("ask"); v != "" {
+		if f.Ask, err = strconv.ParseFloat(v, 64); err != nil {
+			return CleanTick{}, fmt.Errorf("invalid ask: %w", err)
+		}
+	}
+	if v := str("spread"); v != "" {
+		if f.Spread, err = strconv.ParseFloat(v, 64); err != nil {
+			return CleanTick{}, fmt.Errorf("invalid spread: %w", err)
+		}
+	}
+	if v := str("volume"); v != "" {
+		if f.Volume, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return CleanTick{}, fmt.Errorf("invalid volume: %w", err)
+		}
+	}
+	return f.toCleanTick(source)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsWithJitter(t *testing.T) {
+	b := newBackoff(time.Second, 8*time.Second)
+	for _, want := range []time.Duration{1, 2, 4, 8, 8} {
+		want *= time.Second
+		if d := b.next(); d < want/2 || d >= want {
+			t.Fatalf("want delay in [%s, %s), got %s", want/2, want, d)
+		}
+	}
+	b.reset()
+	if d := b.next(); d >= time.Second {
+		t.Fatalf("want delay reset to initial, got %s", d)
+	}
+}
+
+func TestSubscriptionMessages(t *testing.T) {
+	sub := &SubscriptionConfig{
+		Symbols:     []string{"XAUUSD.s", "EURUSD.s"},
+		Subscribe:   `{"type":"Subscribe","symbols":{symbols}}`,
+		Unsubscribe: `{"action":"unsub","symbol":"{symbol}"}`,
+	}
+	if msgs := sub.messages(sub.Subscribe); len(msgs) != 1 || string(msgs[0]) != `{"type":"Subscribe","symbols":["XAUUSD.s","EURUSD.s"]}` {
+		t.Fatalf("unexpected subscribe messages: %q", msgs)
+	}
+	msgs := sub.messages(sub.Unsubscribe)
+	if len(msgs) != 2 || string(msgs[1]) != `{"action":"unsub","symbol":"EURUSD.s"}` {
+		t.Fatalf("unexpected unsubscribe messages: %q", msgs)
+	}
+}
+
+func TestNewTickSourcesGroupsFailover(t *testing.T) {
+	sources, err := NewTickSources([]SourceConfig{
+		{Name: "acct-7", Type: "ws", URL: "ws://upstream/event?id={account}", Account: "7", Group: "mt4", Priority: 2},
+		{Name: "acct-6", Type: "ws", URL: "ws://upstream/event?id={account}", Account: "6", Group: "mt4", Priority: 1},
+		{Name: "replay", Type: "file", Path: "ticks.csv"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, ok := sources[0].(*FailoverSource)
+	if len(sources) != 2 || !ok || len(group.members) != 2 {
+		t.Fatalf("want one failover group and one plain source, got %+v", sources)
+	}
+	primary := group.members[0].source.(*WSSource)
+	if primary.Name() != "acct-6" || primary.cfg.URL != "ws://upstream/event?id=6" {
+		t.Fatalf("want acct-6 first with account in url, got %s %s", primary.Name(), primary.cfg.URL)
+	}
+}
+
+// stubSource 只有名称, 用于测试组内切换
+type stubSource struct{ name string }
+
+func (s stubSource) Name() string                           { return s.name }
+func (s stubSource) Run(ctx context.Context, sink TickSink) {}
+
+func TestFailoverGate(t *testing.T) {
+	f := NewFailoverSource("failover-test")
+	primaryWatch := newFeedWatchdog(SourceConfig{Name: "fo-primary"}, nil)
+	backupWatch := newFeedWatchdog(SourceConfig{Name: "fo-backup"}, nil)
+	f.add(stubSource{"fo-primary"}, 0, primaryWatch)
+	f.add(stubSource{"fo-backup"}, 1, backupWatch)
+
+	var got []string
+	sink := func(tick CleanTick) { got = append(got, tick.Source) }
+	primary, backup := f.gate("fo-primary", sink), f.gate("fo-backup", sink)
+	send := func() {
+		primary(CleanTick{Source: "fo-primary"})
+		backup(CleanTick{Source: "fo-backup"})
+	}
+
+	primaryWatch.setConnected(true)
+	backupWatch.setConnected(true)
+	send()
+	primaryWatch.setConnected(false)
+	send()
+	primaryWatch.setConnected(true)
+	send()
+	if metrics.sourceConnected.Value("fo-primary") != 1 {
+		t.Fatal("want the connection gauge set from the tracked state")
+	}
+
+	want := []string{"fo-primary", "fo-backup", "fo-primary"}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
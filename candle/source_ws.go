@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const wsWriteTimeout = 5 * time.Second
+
+// SubscriptionConfig 上游订阅协议: 连接建立后发送订阅命令, 停机时发送退订命令
+//
+// 命令为文本模板: {symbols} 替换为品种的JSON数组, 整组发送一条;
+// 模板含 {symbol} 时每个品种发送一条, {symbol} 替换为品种名. 例如:
+//
+//	{"type":"Subscribe","symbols":{symbols}}
+//	{"action":"unsub","symbol":"{symbol}"}
+type SubscriptionConfig struct {
+	Symbols     []string `json:"symbols"`     // 订阅的上游品种 (含经纪商后缀)
+	Subscribe   string   `json:"subscribe"`   // 订阅命令模板
+	Unsubscribe string   `json:"unsubscribe"` // 退订命令模板, 为空时停机不发送
+}
+
+func (c *SubscriptionConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Symbols) == 0 || c.Subscribe == "" {
+		return fmt.Errorf("subscription: symbols and subscribe are required")
+	}
+	return nil
+}
+
+// messages 按模板生成发送给上游的命令
+func (c *SubscriptionConfig) messages(template string) [][]byte {
+	if template == "" {
+		return nil
+	}
+	if strings.Contains(template, "{symbol}") {
+		result := make([][]byte, 0, len(c.Symbols))
+		for _, symbol := range c.Symbols {
+			result = append(result, []byte(strings.ReplaceAll(template, "{symbol}", symbol)))
+		}
+		return result
+	}
+	symbols, _ := json.Marshal(c.Symbols)
+	return [][]byte{[]byte(strings.ReplaceAll(template, "{symbols}", string(symbols)))}
+}
+
+// WSSource MT4 桥接 WebSocket 行情源 (UpstreamQuote 格式)
+type WSSource struct {
+	cfg SourceConfig
+}
+
+func NewWSSource(cfg SourceConfig) *WSSource {
+	return &WSSource{cfg: cfg}
+}
+
+func (s *WSSource) Name() string { return s.cfg.Name }
+
+func (s *WSSource) Run(ctx context.Context, sink TickSink) {
+	runWithReconnect(ctx, s.cfg, sink, s.session)
+}
+
+// session 建立一次连接并持续读取, 连接断开时返回
+func (s *WSSource) session(ctx context.Context, sink TickSink) error {
+	log.Printf("[%s] Connecting to upstream WebSocket: %s", s.cfg.Name, s.cfg.URL)
+
+	c, _, err := websocket.DefaultDialer.DialContext(ctx, s.cfg.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to upstream: %w", err)
+	}
+	defer c.Close()
+
+	log.Printf("[%s] Successfully connected to upstream WebSocket.", s.cfg.Name)
+	if sub := s.cfg.Subscription; sub != nil {
+		if err := s.send(c, sub.messages(sub.Subscribe)); err != nil {
+			return fmt.Errorf("failed to subscribe: %w", err)
+		}
+		log.Printf("[%s] Subscribed to %d symbols.", s.cfg.Name, len(sub.Symbols))
+	}
+	markConnected(s.cfg)
+
+	// 收到任何消息、ping 或 pong 都顺延读超时; 半开连接在 read_timeout 后读失败并重连
+	readTimeout := s.cfg.ReadTimeout.Std()
+	extend := func() { c.SetReadDeadline(time.Now().Add(readTimeout)) }
+	extend()
+	c.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+	c.SetPingHandler(func(data string) error {
+		extend()
+		return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(wsWriteTimeout))
+	})
+
+	// 定期 ping; ctx 结束时先退订 (仅停机时, 报价过期重连不退订), 再关闭连接让 ReadMessage 立即返回
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ping := time.NewTicker(s.cfg.PingInterval.Std())
+		defer ping.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if sub := s.cfg.Subscription; sub != nil && context.Cause(ctx) != errFeedStale {
+					if err := s.send(c, sub.messages(sub.Unsubscribe)); err != nil {
+						log.Printf("WARNING: [%s] Failed to unsubscribe: %v", s.cfg.Name, err)
+					}
+				}
+				c.Close()
+				return
+			case <-done:
+				return
+			case <-ping.C:
+				// 失败时不必处理: 连接已断开的话读循环会随之报错
+				c.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			}
+		}
+	}()
+
+	for {
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("upstream read error: %w", err)
+		}
+		extend()
+
+		var quote UpstreamQuote
+		if err := json.Unmarshal(message, &quote); err != nil {
+			log.Printf("WARNING: [%s] Failed to unmarshal message: %v.", s.cfg.Name, err)
+			metrics.parseErrors.Inc(s.cfg.Name)
+			continue
+		}
+		if quote.Type != "Quote" {
+			continue // 非行情消息 (心跳/回执等)
+		}
+
+		tick, err := parseQuote(quote)
+		if err != nil {
+			log.Printf("[%s] Failed to parse quote: %v", s.cfg.Name, err)
+			metrics.parseErrors.Inc(s.cfg.Name)
+			continue
+		}
+		tick.Source = s.cfg.Name
+		sink(tick)
+	}
+}
+
+// send 依次发送命令; 只在读循环开始前和停机时调用, 不会与其它数据帧写操作并发
+// (WriteControl 可以与 WriteMessage 并发)
+func (s *WSSource) send(c *websocket.Conn, messages [][]byte) error {
+	for _, msg := range messages {
+		c.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	stateKeyPrefix     = "kline_state:"         // Redis Hash: kline_state:{symbol}, field 为周期键 ("M1" / "M1:ask")
+	stateFlushInterval = 100 * time.Millisecond // 合并写入的间隔
+)
+
+// CandleState 未闭合K线的持久化状态, 进程重启后据此恢复
+type CandleState struct {
+	Candle
+	FirstTick   time.Time `json:"first_tick"`
+	LastTick    time.Time `json:"last_tick"`
+	SpreadSum   float64   `json:"spread_sum"`
+	ClockOffset Duration  `json:"clock_offset"` // 保存时行情源相对本机的时钟偏移
+}
+
+func newCandleState(c *Candle, clockOffset time.Duration) CandleState {
+	return CandleState{
+		Candle:      *c,
+		FirstTick:   c.firstTick,
+		LastTick:    c.lastTick,
+		SpreadSum:   c.spreadSum,
+		ClockOffset: Duration(clockOffset),
+	}
+}
+
+// candle 还原为可继续聚合的K线
+func (s CandleState) candle() *Candle {
+	c := s.Candle
+	c.firstTick = s.FirstTick
+	c.lastTick = s.LastTick
+	c.spreadSum = s.SpreadSum
+	return &c
+}
+
+// StateStore 未闭合K线的存储
+type StateStore interface {
+	Save(symbol, key string, state CandleState)
+	Delete(symbol, key string)
+	Load(ctx context.Context) (map[string]map[string]CandleState, error) // symbol -> 周期键 -> 状态
+	LoadSymbol(ctx context.Context, symbol string) (map[string]CandleState, error)
+}
+
+// RedisStateStore 将状态写入每个品种一个 Redis Hash
+//
+// 每次K线更新都会调用 Save, 为避免每个Tick多次往返 Redis,
+// 写入先合并到内存中, 由 Run 每 100ms 通过 pipeline 批量落盘.
+type RedisStateStore struct {
+	client  *redis.Client
+	mu      sync.Mutex
+	pending map[string]map[string]*CandleState // nil 表示删除
+}
+
+func NewRedisStateStore(client *redis.Client) *RedisStateStore {
+	return &RedisStateStore{client: client, pending: make(map[string]map[string]*CandleState)}
+}
+
+func (s *RedisStateStore) Save(symbol, key string, state CandleState) {
+	s.set(symbol, key, &state)
+}
+
+func (s *RedisStateStore) Delete(symbol, key string) {
+	s.set(symbol, key, nil)
+}
+
+func (s *RedisStateStore) set(symbol, key string, state *CandleState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields, ok := s.pending[symbol]
+	if !ok {
+		fields = make(map[string]*CandleState)
+		s.pending[symbol] = fields
+	}
+	fields[key] = state
+}
+
+// Run 定期落盘, ctx 结束时再落盘一次
+func (s *RedisStateStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(stateFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.flush(context.Background())
+			return
+		case <-ticker.C:
+			s.flush(ctx)
+		}
+	}
+}
+
+func (s *RedisStateStore) flush(ctx context.Context) {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = make(map[string]map[string]*CandleState)
+	s.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	pipe := s.client.Pipeline()
+	for symbol, fields := range pending {
+		for key, state := range fields {
+			if state == nil {
+				pipe.HDel(ctx, stateKeyPrefix+symbol, key)
+				continue
+			}
+			b, _ := json.Marshal(state)
+			pipe.HSet(ctx, stateKeyPrefix+symbol, key, b)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("ERROR: Failed to persist candle state: %v", err)
+	}
+}
+
+func (s *RedisStateStore) Load(ctx context.Context) (map[string]map[string]CandleState, error) {
+	// 用 SCAN 分批遍历, 避免 KEYS 在键很多时阻塞 Redis
+	result := make(map[string]map[string]CandleState)
+	iter := s.client.Scan(ctx, 0, stateKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		symbol := strings.TrimPrefix(iter.Val(), stateKeyPrefix)
+		if _, seen := result[symbol]; seen {
+			continue // SCAN 可能重复返回同一个键
+		}
+		fields, err := s.load(ctx, symbol)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			result[symbol] = fields
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list candle state: %w", err)
+	}
+	return result, nil
+}
+
+// Clear 删除所有品种保存的状态 (停机时不保留未闭合K线)
+func (s *RedisStateStore) Clear(ctx context.Context) error {
+	s.mu.Lock()
+	s.pending = make(map[string]map[string]*CandleState)
+	s.mu.Unlock()
+	iter := s.client.Scan(ctx, 0, stateKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		if err := s.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return fmt.Errorf("failed to clear candle state: %w", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to clear candle state: %w", err)
+	}
+	return nil
+}
+
+// LoadSymbol 读取单个品种的状态; 先落盘尚未写入的更新, 保证读到最新值
+func (s *RedisStateStore) LoadSymbol(ctx context.Context, symbol string) (map[string]CandleState, error) {
+	s.flush(ctx)
+	return s.load(ctx, symbol)
+}
+
+func (s *RedisStateStore) load(ctx context.Context, symbol string) (map[string]CandleState, error) {
+	hashKey := stateKeyPrefix + symbol
+	fields, err := s.client.HGetAll(ctx, hashKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", hashKey, err)
+	}
+	result := make(map[string]CandleState, len(fields))
+	for key, raw := range fields {
+		var state CandleState
+		if err := json.Unmarshal([]byte(raw), &state); err != nil {
+			log.Printf("WARNING: Ignoring corrupt candle state %s %s: %v", hashKey, key, err)
+			continue
+		}
+		result[key] = state
+	}
+	return result, nil
+}
+
+// KlineLookup 返回 klines 表中某序列最后一根K线的开始时间
+type KlineLookup func(ctx context.Context, symbol, timeframe, priceType string) (time.Time, bool, error)
+
+// dbKlineLookup 从 klines 表查询
+func dbKlineLookup(db *sqlx.DB) KlineLookup {
+	return func(ctx context.Context, symbol, timeframe, priceType string) (time.Time, bool, error) {
+		var last sql.NullTime
+		err := db.GetContext(ctx, &last,
+			"SELECT max(start_time) FROM klines WHERE symbol = $1 AND timeframe = $2 AND price_type = $3",
+			symbol, timeframe, priceType)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		return last.Time, last.Valid, nil
+	}
+}
+
+// RestoreState 启动时恢复未闭合的K线; lookup 不为空时与 klines 表核对,
+// 已经写入数据库的K线 (进程停止期间被其它途径闭合) 不再恢复
+func (m *AggregatorManager) RestoreState(ctx context.Context, lookup KlineLookup) error {
+	if m.State == nil {
+		return nil
+	}
+	states, err := m.State.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.lookup = lookup
+	restored, stale := 0, 0
+	for symbol, fields := range states {
+		sa := m.symbolAggregator(symbol)
+		if sa == nil {
+			log.Printf("WARNING: Symbol limit reached, %s state kept until it is seen again", symbol)
+			continue
+		}
+		r, s := m.restoreSymbol(ctx, sa, fields)
+		restored += r
+		stale += s
+	}
+	m.resume.Store(true)
+	log.Printf("♻️  Restored %d in-progress candles (%d stale discarded)", restored, stale)
+	return nil
+}
+
+// restoreSymbol 恢复一个品种保存的K线, 返回恢复和丢弃的数量
+func (m *AggregatorManager) restoreSymbol(ctx context.Context, sa *SymbolAggregator, fields map[string]CandleState) (restored, stale int) {
+	sa.mu.RLock() // 周期可能同时被管理接口增删
+	defer sa.mu.RUnlock()
+	for key, state := range fields {
+		tfAgg, ok := sa.Timeframes[key]
+		if !ok || !tfAgg.canRestore(state) {
+			m.State.Delete(sa.Symbol, key) // 周期或时段配置已变化
+			stale++
+			continue
+		}
+		if m.lookup != nil {
+			last, found, err := m.lookup(ctx, sa.Symbol, tfAgg.TfName, tfAgg.PriceType)
+			if err != nil {
+				log.Printf("WARNING: Failed to reconcile %s %s with klines, restoring anyway: %v", sa.Symbol, key, err)
+			} else if found && !last.Before(state.StartTime) {
+				m.State.Delete(sa.Symbol, key)
+				stale++
+				continue
+			}
+		}
+		tfAgg.restore(state)
+		sa.restoreClock(state)
+		restored++
+	}
+	return restored, stale
+}
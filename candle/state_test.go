@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStateStore 内存中的 StateStore
+type memStateStore struct {
+	mu     sync.Mutex
+	states map[string]map[string]CandleState
+}
+
+func newMemStateStore() *memStateStore {
+	return &memStateStore{states: make(map[string]map[string]CandleState)}
+}
+
+func (s *memStateStore) Save(symbol, key string, state CandleState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states[symbol] == nil {
+		s.states[symbol] = make(map[string]CandleState)
+	}
+	s.states[symbol][key] = state
+}
+
+func (s *memStateStore) Delete(symbol, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states[symbol], key)
+}
+
+func (s *memStateStore) Load(ctx context.Context) (map[string]map[string]CandleState, error) {
+	return s.states, nil
+}
+
+func (s *memStateStore) LoadSymbol(ctx context.Context, symbol string) (map[string]CandleState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields := make(map[string]CandleState, len(s.states[symbol]))
+	for key, state := range s.states[symbol] {
+		fields[key] = state
+	}
+	return fields, nil
+}
+
+func newStateTestManager(store StateStore, pub CandlePublisher) *AggregatorManager {
+	m := &AggregatorManager{
+		Aggregators:   make(map[string]*SymbolAggregator),
+		queues:        make(map[string]*tickQueue),
+		dormant:       make(map[string]time.Time),
+		publisher:     pub,
+		symbolConfigs: SymbolConfigs{"*": {Timeframes: []string{"M1", "M5"}}},
+		State:         store,
+	}
+	return m
+}
+
+func TestStateSavedAndDeleted(t *testing.T) {
+	store := newMemStateStore()
+	sa := newStateTestManager(store, &recordingPublisher{}).symbolAggregator("XAUUSD")
+
+	sa.ProcessTick(testTick(t, "2025-11-24T10:00:30Z", 2000))
+	sa.ProcessTick(testTick(t, "2025-11-24T10:00:40Z", 2004))
+	m1, ok := store.states["XAUUSD"]["M1"]
+	if !ok || m1.High != 2004 || m1.TickCount != 2 {
+		t.Fatalf("want saved M1 state, got %+v", store.states)
+	}
+
+	sa.ProcessTick(testTick(t, "2025-11-24T10:01:10Z", 2001))
+	m1 = store.states["XAUUSD"]["M1"]
+	if !m1.StartTime.Equal(mustTime(t, "2025-11-24T10:01:00Z")) || m1.Open != 2001 {
+		t.Fatalf("want state of new M1 bar, got %+v", m1)
+	}
+
+	sa.Flush()
+	if n := len(store.states["XAUUSD"]); n != 0 {
+		t.Fatalf("want state deleted after close, got %d entries", n)
+	}
+}
+
+func TestRestoreStateContinuesCandle(t *testing.T) {
+	store := newMemStateStore()
+	sa := newStateTestManager(store, &recordingPublisher{}).symbolAggregator("XAUUSD")
+	sa.ProcessTick(testTick(t, "2025-11-24T10:00:30Z", 2000))
+	sa.ProcessTick(testTick(t, "2025-11-24T10:00:40Z", 2010))
+
+	// 模拟重启: M5 已被写入 klines, M1 尚未
+	pub := &recordingPublisher{}
+	restarted := newStateTestManager(store, pub)
+	lookup := func(ctx context.Context, symbol, timeframe, priceType string) (time.Time, bool, error) {
+		if timeframe == "M5" {
+			return mustTime(t, "2025-11-24T10:00:00Z"), true, nil
+		}
+		return time.Time{}, false, nil
+	}
+	if err := restarted.RestoreState(context.Background(), lookup); err != nil {
+		t.Fatalf("RestoreState: %v", err)
+	}
+	if _, ok := store.states["XAUUSD"]["M5"]; ok {
+		t.Fatalf("want stale M5 state discarded")
+	}
+
+	restarted.Aggregators["XAUUSD"].ProcessTick(testTick(t, "2025-11-24T10:00:50Z", 1990))
+	var last Candle
+	for _, e := range pub.events {
+		if e.Candle.Timeframe == "M1" {
+			last = e.Candle
+		}
+	}
+	if last.Open != 2000 || last.High != 2010 || last.Low != 1990 || last.TickCount != 3 {
+		t.Fatalf("want M1 continued from restored state, got %+v", last)
+	}
+}
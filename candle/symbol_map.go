@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgconn"
+	"github.com/jmoiron/sqlx"
+
+	"shared/configfile"
+)
+
+// 未匹配任何规则的品种的处理方式
+const (
+	UnknownReject     = "reject"     // 丢弃并计数
+	UnknownQuarantine = "quarantine" // 丢弃, 并记录到 Redis 供人工补充规则
+)
+
+const symbolQuarantineKey = "symbol_quarantine" // Redis Hash: "{platform}:{raw}" -> 最近一次报价
+
+// SymbolMappingConfig 品种映射配置 (环境变量 SYMBOL_MAPPING, JSON; 或配置节 symbol_mapping)
+type SymbolMappingConfig struct {
+	Unknown        string   `json:"unknown"`         // reject | quarantine (默认)
+	ReloadInterval Duration `json:"reload_interval"` // 重新加载 symbol_aliases 表的间隔, 默认 1m
+}
+
+func loadSymbolMappingConfig(f *configfile.File) (SymbolMappingConfig, error) {
+	cfg := SymbolMappingConfig{}
+	if _, err := f.LoadJSONEnv("SYMBOL_MAPPING", &cfg); err != nil {
+		return cfg, err
+	}
+	switch cfg.Unknown {
+	case "":
+		cfg.Unknown = UnknownQuarantine
+	case UnknownReject, UnknownQuarantine:
+	default:
+		return cfg, fmt.Errorf("SYMBOL_MAPPING: unknown policy %q", cfg.Unknown)
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = Duration(time.Minute)
+	}
+	return cfg, nil
+}
+
+// SymbolRule symbol_aliases 表中的一条映射规则 (表由 db 服务的迁移创建)
+//
+// kind=alias 时 pattern 为上游名称 (不区分大小写);
+// kind=regex 时 pattern 需完整匹配上游名称, symbol 中可以用 $1 引用分组,
+// 例如 pattern "^([A-Z]{6})(\.m|pro|\.ecn)?$" + symbol "$1".
+// platform_id 为 0 的规则对所有平台生效, 平台专属规则优先.
+type SymbolRule struct {
+	ID         int64  `db:"id"`
+	PlatformID int    `db:"platform_id"`
+	Kind       string `db:"kind"`
+	Pattern    string `db:"pattern"`
+	Symbol     string `db:"symbol"`
+	Priority   int    `db:"priority"`
+
+	re *regexp.Regexp
+}
+
+// symbolRules 一次加载的规则集, 加载后只读
+type symbolRules struct {
+	rules []SymbolRule
+	cache sync.Map // "{platform}:{raw}" -> symbolMatch
+}
+
+type symbolMatch struct {
+	symbol string
+	ok     bool
+}
+
+func compileSymbolRules(rules []SymbolRule) (*symbolRules, error) {
+	for i := range rules {
+		r := &rules[i]
+		switch r.Kind {
+		case "alias":
+		case "regex":
+			re, err := regexp.Compile("^(?:" + r.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("symbol_aliases rule %d: invalid regex %q: %w", r.ID, r.Pattern, err)
+			}
+			r.re = re
+		default:
+			return nil, fmt.Errorf("symbol_aliases rule %d: unknown kind %q", r.ID, r.Kind)
+		}
+		if r.Symbol == "" {
+			return nil, fmt.Errorf("symbol_aliases rule %d: symbol is required", r.ID)
+		}
+	}
+	// 平台专属规则在前, 然后按优先级从高到低
+	sort.SliceStable(rules, func(i, j int) bool {
+		if (rules[i].PlatformID != 0) != (rules[j].PlatformID != 0) {
+			return rules[i].PlatformID != 0
+		}
+		return rules[i].Priority > rules[j].Priority
+	})
+	return &symbolRules{rules: rules}, nil
+}
+
+// resolve 返回上游品种对应的规范名称
+func (rs *symbolRules) resolve(platformID int, raw string) (string, bool) {
+	key := strconv.Itoa(platformID) + ":" + raw
+	if m, ok := rs.cache.Load(key); ok {
+		return m.(symbolMatch).symbol, m.(symbolMatch).ok
+	}
+
+	m := symbolMatch{}
+	for _, r := range rs.rules {
+		if r.PlatformID != 0 && r.PlatformID != platformID {
+			continue
+		}
+		if r.re == nil {
+			if strings.EqualFold(r.Pattern, raw) {
+				m = symbolMatch{symbol: r.Symbol, ok: true}
+				break
+			}
+			continue
+		}
+		if idx := r.re.FindStringSubmatchIndex(raw); idx != nil {
+			m = symbolMatch{symbol: string(r.re.ExpandString(nil, r.Symbol, raw, idx)), ok: true}
+			break
+		}
+	}
+	rs.cache.Store(key, m)
+	return m.symbol, m.ok
+}
+
+// SymbolMapper 在Tick进入聚合前将上游品种名映射为规范名称
+//
+// symbol_aliases 表为空或尚未创建时沿用按 "." 截断后缀的旧规则, 不拦截任何品种.
+type SymbolMapper struct {
+	db      *sqlx.DB
+	rdb     *redis.Client
+	cfg     SymbolMappingConfig
+	rules   atomic.Pointer[symbolRules]
+	unknown sync.Map    // "{platform}:{raw}" -> *int64, 未知品种计数
+	missing atomic.Bool // symbol_aliases 表不存在 (db 服务尚未迁移)
+}
+
+func NewSymbolMapper(db *sqlx.DB, rdb *redis.Client, cfg SymbolMappingConfig) *SymbolMapper {
+	return &SymbolMapper{db: db, rdb: rdb, cfg: cfg}
+}
+
+// Start 加载规则, 之后按 ReloadInterval 定期重新加载
+func (m *SymbolMapper) Start(ctx context.Context) error {
+	if err := m.Reload(ctx); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(m.cfg.ReloadInterval.Std())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.Reload(ctx); err != nil {
+					log.Printf("ERROR: Failed to reload symbol rules, keeping previous set: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Reload 从 symbol_aliases 表加载规则; 表还不存在时保持不映射, 等下次重新加载
+func (m *SymbolMapper) Reload(ctx context.Context) error {
+	var rules []SymbolRule
+	err := m.db.SelectContext(ctx, &rules,
+		"SELECT id, platform_id, kind, pattern, symbol, priority FROM symbol_aliases WHERE enabled ORDER BY id")
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P01" /* undefined_table */ {
+		if !m.missing.Swap(true) {
+			log.Printf("⚠️  symbol_aliases table not found (run the db service migrations), symbol mapping disabled")
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load symbol_aliases: %w", err)
+	}
+	m.missing.Store(false)
+	compiled, err := compileSymbolRules(rules)
+	if err != nil {
+		return err
+	}
+	if old := m.rules.Swap(compiled); old == nil || len(old.rules) != len(compiled.rules) {
+		log.Printf("🔤 Loaded %d symbol mapping rules", len(compiled.rules))
+	}
+	return nil
+}
+
+// Wrap 返回先做品种映射再交给 next 的 TickSink
+func (m *SymbolMapper) Wrap(next TickSink) TickSink {
+	return func(tick CleanTick) {
+		if m.Map(&tick) {
+			next(tick)
+		}
+	}
+}
+
+// Map 改写 tick.Symbol; 未知品种按配置拒绝或隔离, 返回 false
+func (m *SymbolMapper) Map(tick *CleanTick) bool {
+	rules := m.rules.Load()
+	if rules == nil || len(rules.rules) == 0 || tick.RawSymbol == "" {
+		return true
+	}
+	if symbol, ok := rules.resolve(tick.PlatformID, tick.RawSymbol); ok {
+		tick.Symbol = symbol
+		return true
+	}
+	m.handleUnknown(*tick)
+	return false
+}
+
+func (m *SymbolMapper) handleUnknown(tick CleanTick) {
+	key := strconv.Itoa(tick.PlatformID) + ":" + tick.RawSymbol
+	counter, _ := m.unknown.LoadOrStore(key, new(int64))
+	n := atomic.AddInt64(counter.(*int64), 1)
+	if n%1000 == 1 {
+		log.Printf("⚠️  Unknown symbol %q from platform %d (%s, %d ticks so far)",
+			tick.RawSymbol, tick.PlatformID, m.cfg.Unknown, n)
+	}
+	if m.cfg.Unknown != UnknownQuarantine || m.rdb == nil {
+		return
+	}
+	if n%100 != 1 {
+		return // 只抽样记录, 避免每个Tick都写 Redis
+	}
+	record, _ := json.Marshal(map[string]interface{}{
+		"platform_id": tick.PlatformID,
+		"raw_symbol":  tick.RawSymbol,
+		"source":      tick.Source,
+		"bid":         tick.Bid,
+		"ask":         tick.Ask,
+		"time":        tick.Timestamp,
+		"count":       n,
+	})
+	go func() {
+		if err := m.rdb.HSet(ctx, symbolQuarantineKey, key, record).Err(); err != nil {
+			log.Printf("ERROR: Failed to quarantine symbol %s: %v", key, err)
+		}
+	}()
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestSymbolRulesResolve(t *testing.T) {
+	rules, err := compileSymbolRules([]SymbolRule{
+		{ID: 1, Kind: "regex", Pattern: `([A-Z]{6})(\.m|pro|\.ecn)?`, Symbol: "$1"},
+		{ID: 2, Kind: "alias", Pattern: "GOLD", Symbol: "XAUUSD"},
+		{ID: 3, PlatformID: 7, Kind: "alias", Pattern: "XAUUSDpro", Symbol: "XAUUSD_PRO"},
+		{ID: 4, Kind: "regex", Pattern: `US30.*`, Symbol: "DJI", Priority: 10},
+	})
+	if err != nil {
+		t.Fatalf("compileSymbolRules: %v", err)
+	}
+
+	cases := []struct {
+		platform int
+		raw      string
+		want     string
+		ok       bool
+	}{
+		{1, "XAUUSD.m", "XAUUSD", true},
+		{1, "XAUUSDpro", "XAUUSD", true},
+		{7, "XAUUSDpro", "XAUUSD_PRO", true}, // 平台专属规则优先
+		{1, "gold", "XAUUSD", true},
+		{1, "US30.cash", "DJI", true},
+		{1, "XAUUSD.raw", "", false},
+		{1, "BTC", "", false},
+	}
+	for _, c := range cases {
+		got, ok := rules.resolve(c.platform, c.raw)
+		if got != c.want || ok != c.ok {
+			t.Errorf("resolve(%d, %q) = %q, %v; want %q, %v", c.platform, c.raw, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestSymbolMapperUnknown(t *testing.T) {
+	rules, _ := compileSymbolRules([]SymbolRule{{ID: 1, Kind: "alias", Pattern: "XAUUSD.m", Symbol: "XAUUSD"}})
+	m := NewSymbolMapper(nil, nil, SymbolMappingConfig{Unknown: UnknownReject})
+
+	tick := CleanTick{Symbol: "EURUSD", RawSymbol: "EURUSD.m"}
+	if !m.Map(&tick) || tick.Symbol != "EURUSD" {
+		t.Fatalf("without rules the legacy symbol should pass through, got %+v", tick)
+	}
+
+	m.rules.Store(rules)
+	if m.Map(&tick) {
+		t.Fatalf("unknown symbol should be rejected once rules are loaded")
+	}
+	tick = CleanTick{Symbol: "XAUUSD", RawSymbol: "XAUUSD.m"}
+	if !m.Map(&tick) || tick.Symbol != "XAUUSD" {
+		t.Fatalf("want XAUUSD, got %+v", tick)
+	}
+}
+
+func TestCompileSymbolRulesRejectsBadRegex(t *testing.T) {
+	if _, err := compileSymbolRules([]SymbolRule{{ID: 1, Kind: "regex", Pattern: "(", Symbol: "X"}}); err == nil {
+		t.Fatal("want error for invalid regex")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+
+	"shared/configfile"
+)
+
+// 价格序列
+const (
+	PriceBid = "bid"
+	PriceAsk = "ask"
+	PriceMid = "mid"
+)
+
+// SymbolConfig 单个品种的聚合配置
+type SymbolConfig struct {
+	PriceSeries  []string          `json:"price_series"` // 需要生成K线的价格序列, 默认 ["bid"]
+	Timeframes   []string          `json:"timeframes"`   // 周期列表, 默认取配置节 timeframes (见 loadSymbolConfigs)
+	Session      *SessionConfig    `json:"session"`      // 交易时段, 默认 UTC 自然日
+	Calendar     *CalendarConfig   `json:"calendar"`     // 节假日与每日休市
+	GapFill      string            `json:"gap_fill"`     // 缺失K线处理: fill(默认) | skip | synthetic
+	CloseGrace   *Duration         `json:"close_grace"`  // 窗口结束后等待多久由定时器关闭K线, 默认 2s; 0 表示窗口结束即关闭
+	LateTicks    string            `json:"late_ticks"`   // 已关闭K线收到迟到Tick: amend(默认) | drop
+	MaxLateness  *Duration         `json:"max_lateness"` // K线结束后仍可修正的时长, 默认 1m; 0 表示不修正
+	Filters      *TickFilterConfig `json:"filters"`      // Tick质量过滤, 默认不过滤
+	Backpressure string            `json:"backpressure"` // 队列满时: drop_oldest(默认) | coalesce | block
+	QueueSize    int               `json:"queue_size"`   // 品种Tick队列容量, 默认 5000
+
+	timeframes []Timeframe      // 由 Timeframes 解析而来
+	session    *Session         // 由 Session 编译而来
+	calendar   *TradingCalendar // 由 Session + Calendar 组合而来
+}
+
+// SymbolConfigs 品种配置表 (环境变量 SYMBOL_CONFIG, JSON; 或配置节 symbols)
+// key 为品种名, "*" 为默认配置, 品种配置中未设置的字段沿用默认值
+type SymbolConfigs map[string]SymbolConfig
+
+// loadSymbolConfigs 读取并校验品种配置; "*" 未设置周期时使用 timeframes (配置节 timeframes)
+func loadSymbolConfigs(f *configfile.File, timeframes []string) (SymbolConfigs, error) {
+	configs := SymbolConfigs{}
+	if _, err := f.LoadJSONEnv("SYMBOL_CONFIG", &configs); err != nil {
+		return nil, err
+	}
+	if defaults := configs["*"]; len(defaults.Timeframes) == 0 && len(timeframes) > 0 {
+		defaults.Timeframes = timeframes
+		configs["*"] = defaults
+	}
+	for symbol, cfg := range configs {
+		for _, series := range cfg.PriceSeries {
+			if series != PriceBid && series != PriceAsk && series != PriceMid {
+				return nil, fmt.Errorf("symbol %s: unknown price series %q", symbol, series)
+			}
+		}
+		if _, err := ParseTimeframes(cfg.Timeframes); err != nil {
+			return nil, fmt.Errorf("symbol %s: %w", symbol, err)
+		}
+		session, err := compileSession(cfg.Session)
+		if err != nil {
+			return nil, fmt.Errorf("symbol %s: session: %w", symbol, err)
+		}
+		if _, err := NewTradingCalendar(session, cfg.Calendar); err != nil {
+			return nil, fmt.Errorf("symbol %s: calendar: %w", symbol, err)
+		}
+		switch cfg.GapFill {
+		case "", GapFill, GapSkip, GapSynthetic:
+		default:
+			return nil, fmt.Errorf("symbol %s: unknown gap_fill mode %q", symbol, cfg.GapFill)
+		}
+		if cfg.CloseGrace != nil && *cfg.CloseGrace < 0 {
+			return nil, fmt.Errorf("symbol %s: close_grace must not be negative", symbol)
+		}
+		if cfg.MaxLateness != nil && *cfg.MaxLateness < 0 {
+			return nil, fmt.Errorf("symbol %s: max_lateness must not be negative", symbol)
+		}
+		if err := cfg.Filters.validate(); err != nil {
+			return nil, fmt.Errorf("symbol %s: %w", symbol, err)
+		}
+		switch cfg.LateTicks {
+		case "", LateAmend, LateDrop:
+		default:
+			return nil, fmt.Errorf("symbol %s: unknown late_ticks policy %q", symbol, cfg.LateTicks)
+		}
+		switch cfg.Backpressure {
+		case "", BackpressureDropOldest, BackpressureCoalesce, BackpressureBlock:
+		default:
+			return nil, fmt.Errorf("symbol %s: unknown backpressure policy %q", symbol, cfg.Backpressure)
+		}
+		if cfg.QueueSize < 0 {
+			return nil, fmt.Errorf("symbol %s: queue_size must not be negative", symbol)
+		}
+	}
+	return configs, nil
+}
+
+// For 返回品种的生效配置
+func (c SymbolConfigs) For(symbol string) SymbolConfig {
+	cfg := c["*"]
+	if override, ok := c[symbol]; ok {
+		if len(override.PriceSeries) > 0 {
+			cfg.PriceSeries = override.PriceSeries
+		}
+		if len(override.Timeframes) > 0 {
+			cfg.Timeframes = override.Timeframes
+		}
+		if override.Session != nil {
+			cfg.Session = override.Session
+		}
+		if override.Calendar != nil {
+			cfg.Calendar = override.Calendar
+		}
+		if override.GapFill != "" {
+			cfg.GapFill = override.GapFill
+		}
+		if override.CloseGrace != nil {
+			cfg.CloseGrace = override.CloseGrace
+		}
+		if override.LateTicks != "" {
+			cfg.LateTicks = override.LateTicks
+		}
+		if override.MaxLateness != nil {
+			cfg.MaxLateness = override.MaxLateness
+		}
+		if override.Filters != nil {
+			cfg.Filters = override.Filters
+		}
+		if override.Backpressure != "" {
+			cfg.Backpressure = override.Backpressure
+		}
+		if override.QueueSize > 0 {
+			cfg.QueueSize = override.QueueSize
+		}
+	}
+	if len(cfg.PriceSeries) == 0 {
+		cfg.PriceSeries = []string{PriceBid}
+	}
+	if len(cfg.Timeframes) == 0 {
+		cfg.Timeframes = DefaultTimeframes
+	}
+	if cfg.GapFill == "" {
+		cfg.GapFill = GapFill
+	}
+	if cfg.CloseGrace == nil {
+		grace := Duration(defaultCloseGrace)
+		cfg.CloseGrace = &grace
+	}
+	if cfg.LateTicks == "" {
+		cfg.LateTicks = LateAmend
+	}
+	if cfg.MaxLateness == nil {
+		lateness := Duration(defaultMaxLateness)
+		cfg.MaxLateness = &lateness
+	}
+	if cfg.Backpressure == "" {
+		cfg.Backpressure = BackpressureDropOldest
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	// 以下均已在 loadSymbolConfigs 中校验
+	cfg.timeframes, _ = ParseTimeframes(cfg.Timeframes)
+	cfg.session, _ = compileSession(cfg.Session)
+	cfg.calendar, _ = NewTradingCalendar(cfg.session, cfg.Calendar)
+	return cfg
+}
+
+// seriesPrice 从Tick中取出指定序列的价格; ask/mid 缺少卖价时返回 false
+func seriesPrice(tick CleanTick, series string) (float64, bool) {
+	bid := tick.Bid
+	if bid == 0 {
+		bid = tick.Price
+	}
+	switch series {
+	case PriceAsk:
+		return tick.Ask, tick.Ask > 0
+	case PriceMid:
+		if tick.Ask <= 0 || bid <= 0 {
+			return 0, false
+		}
+		return (bid + tick.Ask) / 2, true
+	default:
+		return bid, true
+	}
+}
+
+// klineChannel Redis频道名; bid 序列保持原有格式以兼容现有订阅方
+func klineChannel(symbol, tfName, series string) string {
+	if series == "" || series == PriceBid {
+		return fmt.Sprintf("kline:%s:%s", symbol, tfName)
+	}
+	return fmt.Sprintf("kline:%s:%s:%s", symbol, tfName, series)
+}
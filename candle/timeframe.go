@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeframes 未配置时每个品种生成的周期
+var DefaultTimeframes = []string{"M1", "M5", "M15", "M30", "H1", "H4", "D1"}
+
+// Timeframe K线周期
+//
+// 固定长度的周期 (S5, M1, H4, D1 ...) 直接按时长对齐;
+// W1 从周一 00:00 开始, MN1 从每月1日 00:00 开始, 长度随日历变化
+type Timeframe struct {
+	Name     string
+	Duration time.Duration // 固定长度周期的时长; W1/MN1 为近似值, 仅用于排序和展示
+	calendar string        // "" | "week" | "month"
+}
+
+// ParseTimeframe 解析周期名称: S<n>, M<n>, H<n>, D1, W1, MN1
+func ParseTimeframe(name string) (Timeframe, error) {
+	switch name {
+	case "W1":
+		return Timeframe{Name: name, Duration: 7 * 24 * time.Hour, calendar: "week"}, nil
+	case "MN1":
+		return Timeframe{Name: name, Duration: 30 * 24 * time.Hour, calendar: "month"}, nil
+	case "D1":
+		return Timeframe{Name: name, Duration: 24 * time.Hour}, nil
+	}
+
+	if len(name) < 2 || strings.HasPrefix(name, "MN") {
+		return Timeframe{}, fmt.Errorf("unsupported timeframe %q", name)
+	}
+	n, err := strconv.Atoi(name[1:])
+	if err != nil || n <= 0 {
+		return Timeframe{}, fmt.Errorf("unsupported timeframe %q", name)
+	}
+
+	var unit time.Duration
+	switch name[0] {
+	case 'S':
+		unit = time.Second
+	case 'M':
+		unit = time.Minute
+	case 'H':
+		unit = time.Hour
+	default:
+		return Timeframe{}, fmt.Errorf("unsupported timeframe %q", name)
+	}
+
+	d := time.Duration(n) * unit
+	if (24*time.Hour)%d != 0 {
+		return Timeframe{}, fmt.Errorf("timeframe %q does not divide a day evenly", name)
+	}
+	return Timeframe{Name: name, Duration: d}, nil
+}
+
+// ParseTimeframes 解析并校验周期列表
+func ParseTimeframes(names []string) ([]Timeframe, error) {
+	seen := make(map[string]bool)
+	result := make([]Timeframe, 0, len(names))
+	for _, name := range names {
+		tf, err := ParseTimeframe(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate timeframe %q", name)
+		}
+		seen[name] = true
+		result = append(result, tf)
+	}
+	return result, nil
+}
+
+// WindowStart 返回 t 所在K线的开始时间
+func (tf Timeframe) WindowStart(t time.Time) time.Time {
+	switch tf.calendar {
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		offset := (int(day.Weekday()) + 6) % 7 // 周一为 0
+		return day.AddDate(0, 0, -offset)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	default:
+		return t.Truncate(tf.Duration)
+	}
+}
+
+// Next 返回下一根K线的开始时间
+func (tf Timeframe) Next(start time.Time) time.Time {
+	switch tf.calendar {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.Add(tf.Duration)
+	}
+}
+
+// BarsBetween 返回 from 与 to 两根K线之间相隔的周期数 (from 和 to 都是K线开始时间)
+func (tf Timeframe) BarsBetween(from, to time.Time) int {
+	if !to.After(from) {
+		return 0
+	}
+	if tf.calendar == "" {
+		return int(to.Sub(from) / tf.Duration)
+	}
+	n := 0
+	for t := from; t.Before(to); t = tf.Next(t) {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	ts, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatalf("bad test time %q: %v", s, err)
+	}
+	return ts
+}
+
+func TestParseTimeframe(t *testing.T) {
+	valid := map[string]time.Duration{
+		"S5":  5 * time.Second,
+		"S30": 30 * time.Second,
+		"M1":  time.Minute,
+		"M15": 15 * time.Minute,
+		"H4":  4 * time.Hour,
+		"D1":  24 * time.Hour,
+	}
+	for name, want := range valid {
+		tf, err := ParseTimeframe(name)
+		if err != nil {
+			t.Errorf("ParseTimeframe(%q) error: %v", name, err)
+			continue
+		}
+		if tf.Duration != want {
+			t.Errorf("ParseTimeframe(%q).Duration = %v, want %v", name, tf.Duration, want)
+		}
+	}
+
+	for _, name := range []string{"", "X1", "M0", "M7", "MN2", "H5"} {
+		if _, err := ParseTimeframe(name); err == nil {
+			t.Errorf("ParseTimeframe(%q) expected error", name)
+		}
+	}
+}
+
+func TestTimeframeWindowStart(t *testing.T) {
+	cases := []struct {
+		tf   string
+		tick string
+		want string
+		next string
+	}{
+		{"S15", "2025-11-24T19:45:19Z", "2025-11-24T19:45:15Z", "2025-11-24T19:45:30Z"},
+		{"M5", "2025-11-24T19:47:59Z", "2025-11-24T19:45:00Z", "2025-11-24T19:50:00Z"},
+		{"H4", "2025-11-24T19:47:59Z", "2025-11-24T16:00:00Z", "2025-11-24T20:00:00Z"},
+		// 2025-11-24 是周一, 2025-11-30 是周日
+		{"W1", "2025-11-24T00:00:00Z", "2025-11-24T00:00:00Z", "2025-12-01T00:00:00Z"},
+		{"W1", "2025-11-30T23:59:59Z", "2025-11-24T00:00:00Z", "2025-12-01T00:00:00Z"},
+		{"MN1", "2025-02-28T12:00:00Z", "2025-02-01T00:00:00Z", "2025-03-01T00:00:00Z"},
+		{"MN1", "2025-12-31T23:59:59Z", "2025-12-01T00:00:00Z", "2026-01-01T00:00:00Z"},
+	}
+	for _, c := range cases {
+		tf, err := ParseTimeframe(c.tf)
+		if err != nil {
+			t.Fatalf("ParseTimeframe(%q): %v", c.tf, err)
+		}
+		start := tf.WindowStart(mustTime(t, c.tick))
+		if want := mustTime(t, c.want); !start.Equal(want) {
+			t.Errorf("%s.WindowStart(%s) = %s, want %s", c.tf, c.tick, start, want)
+		}
+		if next, want := tf.Next(start), mustTime(t, c.next); !next.Equal(want) {
+			t.Errorf("%s.Next(%s) = %s, want %s", c.tf, start, next, want)
+		}
+	}
+}
+
+func TestTimeframeBarsBetween(t *testing.T) {
+	m1, _ := ParseTimeframe("M1")
+	if n := m1.BarsBetween(mustTime(t, "2025-11-24T19:45:00Z"), mustTime(t, "2025-11-24T19:49:00Z")); n != 4 {
+		t.Errorf("M1 bars = %d, want 4", n)
+	}
+
+	mn1, _ := ParseTimeframe("MN1")
+	if n := mn1.BarsBetween(mustTime(t, "2025-01-01T00:00:00Z"), mustTime(t, "2025-04-01T00:00:00Z")); n != 3 {
+		t.Errorf("MN1 bars = %d, want 3", n)
+	}
+	if n := mn1.BarsBetween(mustTime(t, "2025-04-01T00:00:00Z"), mustTime(t, "2025-01-01T00:00:00Z")); n != 0 {
+		t.Errorf("MN1 reversed bars = %d, want 0", n)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"shared/configfile"
+)
+
+// 配置文件的格式、${NAME} 引用和环境变量覆盖规则见 shared/configfile, 与 candle 服务相同
+type (
+	Duration       = configfile.Duration
+	RedisConfig    = configfile.RedisConfig
+	PostgresConfig = configfile.PostgresConfig
+	LogConfig      = configfile.LogConfig
+)
+
+// configSections 配置文件的节名 -> 对应的环境变量; 为空表示只能用字段级的变量覆盖 (env 标签)
+var configSections = map[string]string{
+	"redis":            "",
+	"postgres":         "",
+	"log":              "",
+	"transport":        "KLINE_TRANSPORT",
+	"batching":         "DB_WRITER",
+	"sinks":            "CANDLE_SINKS",
+	"migrate":          "DB_MIGRATE",
+	"policies":         "KLINE_POLICIES",
+	"gap_audit":        "GAP_AUDIT",
+	"rollup":           "KLINE_ROLLUP",
+	"shutdown_timeout": "SHUTDOWN_TIMEOUT",
+}
+
+// K线事件来源
+const (
+	TransportStream = "stream" // Redis Streams 消费组 (默认)
+	TransportPubSub = "pubsub" // 旧的 Pub/Sub 订阅, 停机期间的事件会丢失
+)
+
+const defaultShutdownTimeout = 30 * time.Second // 停机时等待写完进行中K线的最长时间
+
+// Config db 服务的全部配置, 启动时一次性读取并校验
+type Config struct {
+	Redis           RedisConfig       `json:"redis"`
+	Postgres        PostgresConfig    `json:"postgres"` // 默认 kline@localhost:5432/kline, 密码见 PG_PASSWORD
+	Log             LogConfig         `json:"log"`
+	Transport       string            `json:"transport"` // stream (默认) | pubsub
+	Batching        KlineWriterConfig `json:"batching"`
+	Sinks           []SinkConfig      `json:"sinks"`
+	Migrate         string            `json:"migrate"` // auto (默认) | manual
+	Policies        KlinePolicies     `json:"policies"`
+	GapAudit        GapAuditConfig    `json:"gap_audit"`
+	Rollup          RollupConfig      `json:"rollup"`
+	ShutdownTimeout Duration          `json:"shutdown_timeout"` // 默认 30s
+}
+
+// loadConfig 读取配置文件 (path 为空时只用环境变量) 并校验全部配置
+func loadConfig(path string) (*Config, error) {
+	f, err := configfile.Load(path, configSections)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if cfg.Redis, err = f.LoadRedis(); err != nil {
+		return nil, err
+	}
+	if cfg.Postgres, err = f.LoadPostgres(); err != nil {
+		return nil, err
+	}
+	if cfg.Postgres.URL == "" {
+		if cfg.Postgres.Host == "" {
+			cfg.Postgres.Host = "localhost"
+		}
+		if cfg.Postgres.User == "" {
+			cfg.Postgres.User = "kline"
+		}
+		if cfg.Postgres.DBName == "" {
+			cfg.Postgres.DBName = "kline"
+		}
+	}
+	if cfg.Log, err = f.LoadLog(); err != nil {
+		return nil, err
+	}
+
+	cfg.Transport, _ = f.Lookup("KLINE_TRANSPORT")
+	switch cfg.Transport {
+	case "":
+		cfg.Transport = TransportStream
+	case TransportStream, TransportPubSub:
+	default:
+		return nil, fmt.Errorf("invalid KLINE_TRANSPORT %q, want stream or pubsub", cfg.Transport)
+	}
+	cfg.Migrate, _ = f.Lookup("DB_MIGRATE")
+	switch cfg.Migrate {
+	case "":
+		cfg.Migrate = MigrateAuto
+	case MigrateAuto, MigrateManual:
+	default:
+		return nil, fmt.Errorf("invalid DB_MIGRATE %q, want auto or manual", cfg.Migrate)
+	}
+	cfg.ShutdownTimeout = Duration(defaultShutdownTimeout)
+	if raw, ok := f.Lookup("SHUTDOWN_TIMEOUT"); ok {
+		d, err := configfile.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q", raw)
+		}
+		cfg.ShutdownTimeout = Duration(d)
+	}
+
+	if cfg.Batching, err = loadKlineWriterConfig(f); err != nil {
+		return nil, err
+	}
+	if cfg.Sinks, err = loadSinkConfigs(f, cfg.Batching); err != nil {
+		return nil, err
+	}
+	if cfg.Policies, err = loadKlinePolicies(f); err != nil {
+		return nil, err
+	}
+	if cfg.GapAudit, err = loadGapAuditConfig(f); err != nil {
+		return nil, err
+	}
+	if cfg.Rollup, err = loadRollupConfig(f); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
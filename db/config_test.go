@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadTestConfig 清空会覆盖配置文件的环境变量后读取配置
+func loadTestConfig(t *testing.T, path string) (*Config, error) {
+	t.Helper()
+	for _, key := range []string{"REDIS_ADDR", "REDIS_PASSWORD", "DATABASE_URL", "PG_HOST", "PG_USER", "PG_PASSWORD",
+		"KLINE_TRANSPORT", "DB_WRITER", "CANDLE_SINKS", "DB_MIGRATE", "KLINE_POLICIES", "GAP_AUDIT", "KLINE_ROLLUP", "SHUTDOWN_TIMEOUT"} {
+		t.Setenv(key, "")
+	}
+	return loadConfig(path)
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadTestConfig(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Postgres.DSN() != "postgres://kline@localhost:5432/kline?sslmode=disable" {
+		t.Fatalf("unexpected default dsn %s", cfg.Postgres.DSN())
+	}
+	if cfg.Transport != TransportStream || cfg.Migrate != MigrateAuto || cfg.ShutdownTimeout.Std() != defaultShutdownTimeout {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+	if len(cfg.Sinks) != 1 || cfg.Sinks[0].Type != SinkTimescaleDB {
+		t.Fatalf("want timescaledb only by default, got %+v", cfg.Sinks)
+	}
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	t.Setenv("PG_PASSWORD", "")
+	cfg, err := loadTestConfig(t, "config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Sinks) != 2 || cfg.Sinks[1].SpillKey != "kline_spill:parquet" || cfg.Sinks[1].FinalizeAfter.Std() != time.Hour {
+		t.Fatalf("unexpected sinks %+v", cfg.Sinks)
+	}
+	if cfg.GapAudit.hoursFor("XAUUSD") == nil || cfg.Rollup.Lookback.Std() != 48*time.Hour {
+		t.Fatalf("unexpected audit config %+v / %+v", cfg.GapAudit, cfg.Rollup)
+	}
+}
+
+func TestConfigEnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, "db.toml", `
+transport = "pubsub"
+
+[postgres]
+host = "timescale"
+user = "writer"
+
+[batching]
+batch_size = 200
+`)
+	cfg, err := loadTestConfig(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Transport != TransportPubSub || cfg.Batching.BatchSize != 200 || cfg.Postgres.DSN() != "postgres://writer@timescale:5432/kline?sslmode=disable" {
+		t.Fatalf("want settings from the file, got %+v", cfg)
+	}
+
+	// 环境变量优先: 单个字段覆盖, JSON 变量整节替换 (通用规则的测试见 shared/configfile)
+	t.Setenv("DB_WRITER", `{"batch_size": 50}`)
+	t.Setenv("KLINE_TRANSPORT", "stream")
+	if cfg, err = loadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Transport != TransportStream || cfg.Batching.BatchSize != 50 {
+		t.Fatalf("want env overrides, got %+v", cfg)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown section":  "batch:\n  batch_size: 10\n",
+		"bad transport":    "transport: kafka\n",
+		"bad migrate mode": "migrate: sometimes\n",
+		"bad sink":         "sinks:\n  - type: parquet\n",
+		"bad policies":     "policies:\n  compress_after: 720h\n  retention: 168h\n",
+		"bad shutdown":     "shutdown_timeout: soon\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadTestConfig(t, writeConfigFile(t, "db.yaml", content)); err == nil {
+				t.Fatal("want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+
+	"shared/calendar"
+	"shared/configfile"
+)
+
+const gapEventChannel = "kline_gap" // Redis Pub/Sub: 检测到的缺口及补齐结果
+
+// 缺口状态
+const (
+	GapOpen     = "open"     // 未尝试补齐 (backfill 关闭)
+	GapFilled   = "filled"   // 已全部补齐
+	GapPartial  = "partial"  // 部分补齐
+	GapUnfilled = "unfilled" // 没有可用于补齐的数据
+)
+
+// 审计支持的周期; D1 及以上的对齐取决于 candle 服务的日切配置, 不做审计
+var auditTimeframes = map[string]time.Duration{
+	"M1": time.Minute, "M5": 5 * time.Minute, "M15": 15 * time.Minute,
+	"M30": 30 * time.Minute, "H1": time.Hour, "H4": 4 * time.Hour,
+}
+
+// GapAuditConfig 缺口审计配置 (环境变量 GAP_AUDIT, JSON; 或配置节 gap_audit)
+type GapAuditConfig struct {
+	Disabled   bool                   `json:"disabled"`
+	Interval   Duration               `json:"interval"`    // 审计间隔, 默认 10m
+	Lookback   Duration               `json:"lookback"`    // 每次检查最近多久的K线, 默认 24h
+	SeriesAge  Duration               `json:"series_age"`  // 最近多久内有过K线的序列参与审计, 默认 7 天 (不小于 lookback)
+	Grace      Duration               `json:"grace"`       // K线结束后多久仍未写入才算缺失, 默认 5m
+	Timeframes []string               `json:"timeframes"`  // 审计的周期, 默认 M1 M5 M15 M30 H1
+	NoBackfill bool                   `json:"no_backfill"` // 只报告, 不补齐
+	Hours      map[string]SymbolHours `json:"hours"`       // 品种交易时段和日历, "*" 为默认; 未配置表示全天候
+}
+
+func loadGapAuditConfig(f *configfile.File) (GapAuditConfig, error) {
+	cfg := GapAuditConfig{}
+	if _, err := f.LoadJSONEnv("GAP_AUDIT", &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = Duration(10 * time.Minute)
+	}
+	if cfg.Lookback <= 0 {
+		cfg.Lookback = Duration(24 * time.Hour)
+	}
+	if cfg.SeriesAge <= 0 {
+		cfg.SeriesAge = Duration(7 * 24 * time.Hour)
+	}
+	if cfg.SeriesAge < cfg.Lookback {
+		cfg.SeriesAge = cfg.Lookback
+	}
+	if cfg.Grace <= 0 {
+		cfg.Grace = Duration(5 * time.Minute)
+	}
+	if len(cfg.Timeframes) == 0 {
+		cfg.Timeframes = []string{"M1", "M5", "M15", "M30", "H1"}
+	}
+	for _, tf := range cfg.Timeframes {
+		if _, ok := auditTimeframes[tf]; !ok {
+			return cfg, fmt.Errorf("GAP_AUDIT: unsupported timeframe %q", tf)
+		}
+	}
+	for symbol, h := range cfg.Hours {
+		if err := h.compile(); err != nil {
+			return cfg, fmt.Errorf("GAP_AUDIT: hours %s: %w", symbol, err)
+		}
+		cfg.Hours[symbol] = h
+	}
+	return cfg, nil
+}
+
+// hoursFor 返回品种的交易日历, nil 表示全天候
+func (c GapAuditConfig) hoursFor(symbol string) *calendar.Calendar {
+	if h, ok := c.Hours[symbol]; ok {
+		return h.compiled
+	}
+	if h, ok := c.Hours["*"]; ok {
+		return h.compiled
+	}
+	return nil
+}
+
+// KlineGap 一段连续缺失的K线 [Start, End)
+type KlineGap struct {
+	Symbol      string    `json:"symbol" db:"symbol"`
+	Timeframe   string    `json:"timeframe" db:"timeframe"`
+	PriceType   string    `json:"price_type" db:"price_type"`
+	Start       time.Time `json:"gap_start" db:"gap_start"`
+	End         time.Time `json:"gap_end" db:"gap_end"`
+	MissingBars int       `json:"missing_bars" db:"missing_bars"`
+	FilledBars  int       `json:"filled_bars" db:"filled_bars"`
+	Status      string    `json:"status" db:"status"`
+	Source      string    `json:"source,omitempty" db:"source"` // 补齐使用的数据: "ticks" | "M1"
+}
+
+func (g KlineGap) ToJSON() []byte {
+	b, _ := json.Marshal(g)
+	return b
+}
+
+// GapAuditor 定期检查 klines 中缺失的K线, 记录到 kline_gaps 表并发布事件,
+// 然后尝试补齐: M1 由 ticks 归档表重新聚合, 更大周期由完整的 M1 汇总
+type GapAuditor struct {
+	db       *sqlx.DB
+	rdb      *redis.Client
+	cfg      GapAuditConfig
+	hasTicks bool              // ticks 归档表存在 (candle 服务开启了 TICK_ARCHIVE)
+	reported map[string]string // 已发布事件的缺口及其状态, 避免每轮重复发布
+	seen     map[string]bool   // 本轮仍存在的缺口, 用于清理 reported
+}
+
+func NewGapAuditor(db *sqlx.DB, rdb *redis.Client, cfg GapAuditConfig) *GapAuditor {
+	return &GapAuditor{db: db, rdb: rdb, cfg: cfg, reported: make(map[string]string)}
+}
+
+// Run 每隔 Interval 审计一次, 阻塞直到 ctx 结束
+func (a *GapAuditor) Run(ctx context.Context) {
+	log.Printf("Gap auditor started (every %s, lookback %s, timeframes %v)",
+		a.cfg.Interval.Std(), a.cfg.Lookback.Std(), a.cfg.Timeframes)
+	ticker := time.NewTicker(a.cfg.Interval.Std())
+	defer ticker.Stop()
+	for {
+		if err := a.Audit(ctx, time.Now().UTC()); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: Gap audit failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type klineSeries struct {
+	Symbol    string `db:"symbol"`
+	Timeframe string `db:"timeframe"`
+	PriceType string `db:"price_type"`
+}
+
+// Audit 检查所有序列一次; M1 先于更大周期处理, 以便后者用刚补齐的 M1 汇总
+func (a *GapAuditor) Audit(ctx context.Context, now time.Time) error {
+	var hasTicks sql.NullString
+	if err := a.db.GetContext(ctx, &hasTicks, "SELECT to_regclass('ticks')::text"); err != nil {
+		return err
+	}
+	a.hasTicks = hasTicks.Valid
+
+	// 序列取自比检查窗口更长的时间范围, 整个窗口都没有K线的品种 (行情中断) 同样报告缺口
+	var all []klineSeries
+	if err := a.db.SelectContext(ctx, &all,
+		"SELECT DISTINCT symbol, timeframe, price_type FROM klines WHERE start_time >= $1",
+		now.Add(-a.cfg.SeriesAge.Std())); err != nil {
+		return err
+	}
+	wanted := make(map[string]bool)
+	for _, tf := range a.cfg.Timeframes {
+		wanted[tf] = true
+	}
+	series := all[:0]
+	for _, s := range all {
+		if wanted[s.Timeframe] {
+			series = append(series, s)
+		}
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return auditTimeframes[series[i].Timeframe] < auditTimeframes[series[j].Timeframe]
+	})
+
+	a.seen = make(map[string]bool)
+	found, filled := 0, 0
+	for _, s := range series {
+		gaps, err := a.auditSeries(ctx, s, now)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			log.Printf("ERROR: Gap audit of %s %s (%s) failed: %v", s.Symbol, s.Timeframe, s.PriceType, err)
+			continue
+		}
+		for _, g := range gaps {
+			found += g.MissingBars
+			filled += g.FilledBars
+		}
+	}
+	for key := range a.reported {
+		if !a.seen[key] {
+			delete(a.reported, key)
+		}
+	}
+	if found > 0 {
+		log.Printf("🕳️  Gap audit: %d missing bars across %d series, %d backfilled", found, len(series), filled)
+	}
+	return nil
+}
+
+// auditSeries 找出一个序列的缺口, 尝试补齐并记录
+func (a *GapAuditor) auditSeries(ctx context.Context, s klineSeries, now time.Time) ([]KlineGap, error) {
+	tf := auditTimeframes[s.Timeframe]
+	windowStart := now.Add(-a.cfg.Lookback.Std())
+
+	// 按窗口前最后一根K线对齐网格 (大周期的对齐取决于 candle 服务的时段配置),
+	// 从窗口内的第一个网格点开始检查到 now - grace, 窗口内一根都没有时整个窗口都是缺口;
+	// 窗口前没有K线时从窗口内第一根开始
+	var anchor sql.NullTime
+	if err := a.db.GetContext(ctx, &anchor,
+		"SELECT max(start_time) FROM klines WHERE symbol = $1 AND timeframe = $2 AND price_type = $3 AND start_time < $4",
+		s.Symbol, s.Timeframe, s.PriceType, windowStart); err != nil {
+		return nil, err
+	}
+	from := windowStart
+	if anchor.Valid {
+		from = anchor.Time.Add((windowStart.Sub(anchor.Time) + tf - 1) / tf * tf)
+	}
+	var present []time.Time
+	if err := a.db.SelectContext(ctx, &present,
+		"SELECT start_time FROM klines WHERE symbol = $1 AND timeframe = $2 AND price_type = $3 AND start_time >= $4 ORDER BY start_time",
+		s.Symbol, s.Timeframe, s.PriceType, from); err != nil {
+		return nil, err
+	}
+	if !anchor.Valid {
+		if len(present) == 0 {
+			return nil, nil
+		}
+		from = present[0]
+	}
+
+	hours := a.cfg.hoursFor(s.Symbol)
+	missing := missingBuckets(present, from, tf, now.Add(-a.cfg.Grace.Std()), hours)
+	gaps := gapRanges(s, missing, tf)
+	for i := range gaps {
+		g := &gaps[i]
+		g.Status = GapOpen
+		if !a.cfg.NoBackfill {
+			if err := a.backfill(ctx, g, tf, hours); err != nil {
+				log.Printf("ERROR: Backfill of %s %s (%s) from %s failed: %v",
+					g.Symbol, g.Timeframe, g.PriceType, g.Start.Format(time.RFC3339), err)
+			}
+		}
+		if err := a.record(ctx, *g); err != nil {
+			return gaps, err
+		}
+	}
+	return gaps, nil
+}
+
+// missingBuckets 从 from 起按周期步进, 返回 until 之前缺失且处于交易时段的K线开始时间
+func missingBuckets(present []time.Time, from time.Time, tf time.Duration, until time.Time, hours *calendar.Calendar) []time.Time {
+	have := make(map[int64]bool, len(present))
+	for _, t := range present {
+		have[t.Unix()] = true
+	}
+	var missing []time.Time
+	for t := from; !t.Add(tf).After(until); t = t.Add(tf) {
+		if !have[t.Unix()] && hours.OpenMinutes(t, t.Add(tf)) > 0 {
+			missing = append(missing, t.UTC())
+		}
+	}
+	return missing
+}
+
+// gapRanges 把缺失的K线按连续区间合并 (中间只隔着休市的视为同一段)
+func gapRanges(s klineSeries, missing []time.Time, tf time.Duration) []KlineGap {
+	var gaps []KlineGap
+	for _, t := range missing {
+		if n := len(gaps); n > 0 && gaps[n-1].End.Equal(t) {
+			gaps[n-1].End = t.Add(tf)
+			gaps[n-1].MissingBars++
+			continue
+		}
+		gaps = append(gaps, KlineGap{
+			Symbol: s.Symbol, Timeframe: s.Timeframe, PriceType: s.PriceType,
+			Start: t, End: t.Add(tf), MissingBars: 1,
+		})
+	}
+	return gaps
+}
+
+// backfill 补齐缺口: M1 从 ticks 重新聚合, 其它周期由 M1 汇总 (只汇总 M1 完整的K线)
+func (a *GapAuditor) backfill(ctx context.Context, g *KlineGap, tf time.Duration, hours *calendar.Calendar) error {
+	var filled int64
+	if g.Timeframe == "M1" {
+		if !a.hasTicks {
+			g.Status = GapUnfilled
+			return nil
+		}
+		price, cond := tickPriceExpr(g.PriceType)
+		res, err := a.db.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO klines
+				(start_time, symbol, timeframe, price_type, open, high, low, close, volume,
+				 spread_min, spread_avg, spread_max, tick_count, synthetic)
+			SELECT date_trunc('minute', time), symbol, 'M1', $2::text,
+				(array_agg(%[1]s ORDER BY time))[1], max(%[1]s), min(%[1]s), (array_agg(%[1]s ORDER BY time DESC))[1],
+				count(*), min(ask - bid), avg(ask - bid), max(ask - bid), count(*), false
+			FROM ticks
+			WHERE symbol = $1 AND time >= $3 AND time < $4 %[2]s
+			GROUP BY date_trunc('minute', time), symbol
+			ON CONFLICT (symbol, timeframe, price_type, start_time) DO NOTHING`, price, cond),
+			g.Symbol, g.PriceType, g.Start, g.End)
+		if err != nil {
+			return err
+		}
+		filled, _ = res.RowsAffected()
+		g.Source = "ticks"
+	} else {
+		for t := g.Start; t.Before(g.End); t = t.Add(tf) {
+			needed := hours.OpenMinutes(t, t.Add(tf))
+			if needed == 0 {
+				continue
+			}
+			res, err := a.db.ExecContext(ctx, `
+				INSERT INTO klines
+					(start_time, symbol, timeframe, price_type, open, high, low, close, volume,
+					 spread_min, spread_avg, spread_max, tick_count, synthetic)
+				SELECT $1::timestamptz, symbol, $2::text, price_type,
+					(array_agg(open ORDER BY start_time))[1], max(high), min(low),
+					(array_agg(close ORDER BY start_time DESC))[1], sum(volume),
+					min(spread_min), avg(spread_avg), max(spread_max), sum(tick_count), bool_and(synthetic)
+				FROM klines
+				WHERE symbol = $3 AND timeframe = 'M1' AND price_type = $4 AND start_time >= $1 AND start_time < $5
+				GROUP BY symbol, price_type
+				HAVING count(*) >= $6
+				ON CONFLICT (symbol, timeframe, price_type, start_time) DO NOTHING`,
+				t, g.Timeframe, g.Symbol, g.PriceType, t.Add(tf), needed)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			filled += n
+		}
+		g.Source = "M1"
+	}
+
+	g.FilledBars = int(filled)
+	switch {
+	case g.FilledBars >= g.MissingBars:
+		g.Status = GapFilled
+	case g.FilledBars > 0:
+		g.Status = GapPartial
+	default:
+		g.Status = GapUnfilled
+	}
+	return nil
+}
+
+// tickPriceExpr 返回 ticks 表中对应价格序列的表达式和过滤条件
+func tickPriceExpr(priceType string) (expr, cond string) {
+	switch priceType {
+	case "ask":
+		return "ask", "AND ask > 0"
+	case "mid":
+		return "(bid + ask) / 2", "AND ask > 0 AND bid > 0"
+	default:
+		return "bid", ""
+	}
+}
+
+// record 写入缺口记录, 新缺口或状态变化时发布事件
+func (a *GapAuditor) record(ctx context.Context, g KlineGap) error {
+	_, err := a.db.NamedExecContext(ctx, `
+		INSERT INTO kline_gaps
+			(symbol, timeframe, price_type, gap_start, gap_end, missing_bars, filled_bars, status, source)
+		VALUES
+			(:symbol, :timeframe, :price_type, :gap_start, :gap_end, :missing_bars, :filled_bars, :status, :source)
+		ON CONFLICT (symbol, timeframe, price_type, gap_start) DO UPDATE SET
+			gap_end = EXCLUDED.gap_end, missing_bars = EXCLUDED.missing_bars,
+			filled_bars = EXCLUDED.filled_bars, status = EXCLUDED.status,
+			source = EXCLUDED.source, updated_at = now()`, g)
+	if err != nil {
+		return fmt.Errorf("failed to record gap: %w", err)
+	}
+
+	key := fmt.Sprintf("%s|%s|%s|%d", g.Symbol, g.Timeframe, g.PriceType, g.Start.Unix())
+	a.seen[key] = true
+	if a.reported[key] == g.Status {
+		return nil
+	}
+	a.reported[key] = g.Status
+	log.Printf("🕳️  Gap %s %s (%s) %s - %s: %d missing, %d backfilled (%s)",
+		g.Symbol, g.Timeframe, g.PriceType, g.Start.Format(time.RFC3339), g.End.Format(time.RFC3339),
+		g.MissingBars, g.FilledBars, g.Status)
+	if err := a.rdb.Publish(ctx, gapEventChannel, g.ToJSON()).Err(); err != nil {
+		log.Printf("ERROR: Failed to publish gap event: %v", err)
+	}
+	return nil
+}
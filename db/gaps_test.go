@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"shared/calendar"
+)
+
+func TestMissingBucketsSkipsWeekend(t *testing.T) {
+	hours := SymbolHours{Session: &calendar.SessionConfig{WeekendClose: "Fri 22:00", WeekendOpen: "Sun 22:00"}}
+	if err := hours.compile(); err != nil {
+		t.Fatal(err)
+	}
+	// 2025-01-03 是周五
+	from := time.Date(2025, 1, 3, 20, 0, 0, 0, time.UTC)
+	present := []time.Time{from, from.Add(time.Hour)}
+	until := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+
+	missing := missingBuckets(present, from, time.Hour, until, hours.compiled)
+	want := []time.Time{
+		time.Date(2025, 1, 5, 22, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 5, 23, 0, 0, 0, time.UTC),
+	}
+	if len(missing) != len(want) {
+		t.Fatalf("want %v, got %v", want, missing)
+	}
+	for i := range want {
+		if !missing[i].Equal(want[i]) {
+			t.Fatalf("want %v, got %v", want, missing)
+		}
+	}
+}
+
+func TestGapRangesMergesConsecutiveBars(t *testing.T) {
+	base := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	missing := []time.Time{base, base.Add(time.Minute), base.Add(2 * time.Minute), base.Add(10 * time.Minute)}
+	gaps := gapRanges(klineSeries{Symbol: "XAUUSD", Timeframe: "M1", PriceType: "bid"}, missing, time.Minute)
+
+	if len(gaps) != 2 {
+		t.Fatalf("want 2 gaps, got %+v", gaps)
+	}
+	if !gaps[0].Start.Equal(base) || !gaps[0].End.Equal(base.Add(3*time.Minute)) || gaps[0].MissingBars != 3 {
+		t.Fatalf("unexpected first gap %+v", gaps[0])
+	}
+	if !gaps[1].Start.Equal(base.Add(10*time.Minute)) || gaps[1].MissingBars != 1 {
+		t.Fatalf("unexpected second gap %+v", gaps[1])
+	}
+}
+
+func TestMissingBucketsReportsSilentSeries(t *testing.T) {
+	// 窗口内一根K线都没有 (品种行情中断): 从网格起点到 until 全部缺失, 每日休市时段除外
+	hours := SymbolHours{Calendar: &calendar.CalendarConfig{DailyBreaks: []string{"17:00-18:00"}}}
+	if err := hours.compile(); err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2025, 1, 6, 15, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 1, 6, 20, 0, 0, 0, time.UTC)
+
+	missing := missingBuckets(nil, from, time.Hour, until, hours.compiled)
+	if len(missing) != 4 || !missing[3].Equal(until.Add(-time.Hour)) {
+		t.Fatalf("want 15:00, 16:00, 18:00, 19:00 missing, got %v", missing)
+	}
+}
+
+func TestLoadGapAuditConfigRejectsBadHours(t *testing.T) {
+	t.Setenv("GAP_AUDIT", `{"hours": {"*": {"session": {"weekend_close": "Friday 22:00", "weekend_open": "Sun 22:00"}}}}`)
+	if _, err := loadGapAuditConfig(nil); err == nil {
+		t.Fatal("want error for invalid weekend_close")
+	}
+
+	t.Setenv("GAP_AUDIT", `{"hours": {"XAUUSD": {"calendar": {"holidays": ["25/12/2025"]}}}}`)
+	if _, err := loadGapAuditConfig(nil); err == nil {
+		t.Fatal("want error for invalid holiday")
+	}
+
+	t.Setenv("GAP_AUDIT", `{"timeframes": ["M1", "W1"]}`)
+	if _, err := loadGapAuditConfig(nil); err == nil {
+		t.Fatal("want error for unsupported timeframe")
+	}
+}
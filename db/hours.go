@@ -0,0 +1,20 @@
+package main
+
+import "shared/calendar"
+
+// SymbolHours 品种的交易时段和交易日历, 写法与 candle 服务 symbols 配置中同名的 session / calendar 节相同
+//
+//	{"session": {"timezone": "America/New_York", "weekend_close": "Fri 17:00", "weekend_open": "Sun 18:00"},
+//	 "calendar": {"holidays": ["2025-12-25"], "daily_breaks": ["17:00-18:00"]}}
+type SymbolHours struct {
+	Session  *calendar.SessionConfig  `json:"session"`
+	Calendar *calendar.CalendarConfig `json:"calendar"`
+
+	compiled *calendar.Calendar
+}
+
+// compile 校验并解析配置
+func (h *SymbolHours) compile() (err error) {
+	h.compiled, err = calendar.Compile(h.Session, h.Calendar)
+	return err
+}
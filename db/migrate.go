@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"shared/configfile"
+)
+
+// migrateLockKey 迁移期间持有的 advisory lock, 避免多个实例同时迁移
+const migrateLockKey = "klines_schema_migrations"
+
+// MigrationStatus 一个迁移版本的状态
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time // nil 表示未执行
+}
+
+// Migrator 按版本执行 migrations, 已执行的版本记录在 schema_migrations 表
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sqlx.DB) *Migrator {
+	return &Migrator{db: db, migrations: migrations}
+}
+
+// Status 返回所有版本及其执行时间
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			s := MigrationStatus{Version: mg.Version, Name: mg.Name}
+			if at, ok := applied[mg.Version]; ok {
+				s.AppliedAt = &at
+			}
+			status = append(status, s)
+		}
+		return nil
+	})
+	return status, err
+}
+
+// Up 按顺序执行未执行的版本, 直到 target (0 表示最新版本); 返回执行的版本数
+func (m *Migrator) Up(ctx context.Context, target int) (int, error) {
+	n := 0
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mg := range pendingMigrations(m.migrations, applied, target) {
+			log.Printf("⬆️  Applying migration %d: %s", mg.Version, mg.Name)
+			if err := runMigration(ctx, conn, mg.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", mg.Version, mg.Name); err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", mg.Version, mg.Name, err)
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down 回滚最近执行的 steps 个版本; 返回回滚的版本数
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	n := 0
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		revert, err := revertMigrations(m.migrations, applied, steps)
+		if err != nil {
+			return err
+		}
+		for _, mg := range revert {
+			log.Printf("⬇️  Reverting migration %d: %s", mg.Version, mg.Name)
+			if err := runMigration(ctx, conn, mg.Down,
+				"DELETE FROM schema_migrations WHERE version = $1", mg.Version); err != nil {
+				return fmt.Errorf("revert of migration %d (%s) failed: %w", mg.Version, mg.Name, err)
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// withLock 在持有 advisory lock 的连接上执行 fn
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sqlx.Conn) error) error {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", migrateLockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", migrateLockKey)
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INT         PRIMARY KEY,
+			name       TEXT        NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *sqlx.Conn) (map[int]time.Time, error) {
+	var rows []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := conn.SelectContext(ctx, &rows, "SELECT version, applied_at FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+// pendingMigrations 返回未执行且不超过 target 的版本 (target 为 0 表示不限)
+func pendingMigrations(all []Migration, applied map[int]time.Time, target int) []Migration {
+	var pending []Migration
+	for _, mg := range all {
+		if target > 0 && mg.Version > target {
+			break
+		}
+		if _, ok := applied[mg.Version]; !ok {
+			pending = append(pending, mg)
+		}
+	}
+	return pending
+}
+
+// revertMigrations 返回要回滚的最近 steps 个已执行版本 (从新到旧);
+// 其中有不可回滚的版本 (没有 Down) 时一个都不回滚
+func revertMigrations(all []Migration, applied map[int]time.Time, steps int) ([]Migration, error) {
+	var revert []Migration
+	for i := len(all) - 1; i >= 0 && len(revert) < steps; i-- {
+		mg := all[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		if len(mg.Down) == 0 {
+			return nil, fmt.Errorf("migration %d (%s) cannot be reverted", mg.Version, mg.Name)
+		}
+		revert = append(revert, mg)
+	}
+	return revert, nil
+}
+
+// runMigration 在一个事务内执行语句并更新 schema_migrations
+func runMigration(ctx context.Context, conn *sqlx.Conn, statements []string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("statement %d: %w", i+1, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 服务启动时的 schema 检查方式 (配置节 migrate 或环境变量 DB_MIGRATE)
+const (
+	MigrateAuto   = "auto"   // 执行未执行的版本并同步压缩和保留策略
+	MigrateManual = "manual" // 只检查, 有未执行的版本则报错, 需先运行 `db migrate up`
+)
+
+// migrateOnStart 服务启动时的 schema 检查, mode 为 MigrateAuto 或 MigrateManual
+func migrateOnStart(ctx context.Context, db *sqlx.DB, mode string, policies KlinePolicies) error {
+	m := NewMigrator(db)
+	switch mode {
+	case MigrateAuto:
+		n, err := m.Up(ctx, 0)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("Applied %d migration(s)", n)
+		}
+		return applyKlinePolicies(ctx, db, policies)
+	case MigrateManual:
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			if s.AppliedAt == nil {
+				return fmt.Errorf("migration %d (%s) is pending; run `db migrate up` first", s.Version, s.Name)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid DB_MIGRATE %q, want auto or manual", mode)
+	}
+}
+
+// KlinePolicies klines 超表的压缩和保留策略 (环境变量 KLINE_POLICIES, JSON; 或配置节 policies)
+type KlinePolicies struct {
+	CompressAfter Duration `json:"compress_after"` // 多久之前的 chunk 压缩, 默认 168h; 负数表示不压缩
+	Retention     Duration `json:"retention"`      // 多久之前的 chunk 删除, 默认 0 (永久保留)
+}
+
+func loadKlinePolicies(f *configfile.File) (KlinePolicies, error) {
+	p := KlinePolicies{}
+	if _, err := f.LoadJSONEnv("KLINE_POLICIES", &p); err != nil {
+		return p, err
+	}
+	if p.CompressAfter == 0 {
+		p.CompressAfter = Duration(7 * 24 * time.Hour)
+	}
+	if p.Retention < 0 {
+		return p, fmt.Errorf("KLINE_POLICIES: retention must not be negative")
+	}
+	if p.Retention > 0 && p.CompressAfter > 0 && p.Retention <= p.CompressAfter {
+		return p, fmt.Errorf("KLINE_POLICIES: retention must be longer than compress_after")
+	}
+	return p, nil
+}
+
+// applyKlinePolicies 按配置重建 klines 的压缩和保留策略 (幂等)
+//
+// 压缩后的 chunk 仍可 UPSERT (TimescaleDB >= 2.11), 但 AMEND 和汇总修正通常只涉及最近的K线,
+// compress_after 应大于 KLINE_ROLLUP 的 lookback
+func applyKlinePolicies(ctx context.Context, db *sqlx.DB, p KlinePolicies) error {
+	statements := []string{
+		"SELECT remove_compression_policy('klines', if_exists => TRUE)",
+		"SELECT remove_retention_policy('klines', if_exists => TRUE)",
+	}
+	if p.CompressAfter > 0 {
+		statements = append(statements, fmt.Sprintf(
+			"SELECT add_compression_policy('klines', INTERVAL '%d seconds')", int64(p.CompressAfter.Std().Seconds())))
+	}
+	if p.Retention > 0 {
+		statements = append(statements, fmt.Sprintf(
+			"SELECT add_retention_policy('klines', INTERVAL '%d seconds')", int64(p.Retention.Std().Seconds())))
+	}
+	for _, stmt := range statements {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("klines policy setup failed: %w\nSQL: %s", err, stmt)
+		}
+	}
+	log.Printf("klines policies: compress after %s, retention %s", p.CompressAfter.Std(), p.Retention.Std())
+	return nil
+}
+
+const migrateUsage = "usage: db migrate up [version] | down [steps] | status"
+
+// runMigrateCommand 处理 `db migrate ...` 子命令
+func runMigrateCommand(ctx context.Context, db *sqlx.DB, args []string, policies KlinePolicies) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(migrateUsage)
+	}
+	arg := 0
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid argument %q; %s", args[1], migrateUsage)
+		}
+		arg = n
+	}
+
+	m := NewMigrator(db)
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx, arg)
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied %d migration(s)", n)
+		if arg == 0 {
+			return applyKlinePolicies(ctx, db, policies)
+		}
+		return nil
+	case "down":
+		if arg == 0 {
+			arg = 1
+		}
+		n, err := m.Down(ctx, arg)
+		if err != nil {
+			return err
+		}
+		log.Printf("Reverted %d migration(s)", n)
+		return nil
+	case "status":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		status, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-45s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q; %s", args[0], migrateUsage)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for i, mg := range migrations {
+		if mg.Version != i+1 {
+			t.Fatalf("migration %q has version %d, want %d", mg.Name, mg.Version, i+1)
+		}
+		// 第 1 版接管已有的 klines 表, 不可回滚
+		if mg.Name == "" || len(mg.Up) == 0 || (len(mg.Down) == 0) != (mg.Version == 1) {
+			t.Fatalf("migration %d needs a name, up and down statements", mg.Version)
+		}
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	all := []Migration{{Version: 1}, {Version: 2}, {Version: 3}, {Version: 4}}
+	applied := map[int]time.Time{1: time.Now(), 3: time.Now()}
+
+	if got := pendingMigrations(all, applied, 0); len(got) != 2 || got[0].Version != 2 || got[1].Version != 4 {
+		t.Fatalf("want versions 2 and 4 pending, got %+v", got)
+	}
+	if got := pendingMigrations(all, applied, 3); len(got) != 1 || got[0].Version != 2 {
+		t.Fatalf("want only version 2 pending up to 3, got %+v", got)
+	}
+}
+
+func TestRevertMigrationsStopsAtFirstVersion(t *testing.T) {
+	applied := map[int]time.Time{}
+	for _, mg := range migrations {
+		applied[mg.Version] = time.Now()
+	}
+	last := len(migrations)
+
+	got, err := revertMigrations(migrations, applied, 2)
+	if err != nil || len(got) != 2 || got[0].Version != last || got[1].Version != last-1 {
+		t.Fatalf("want the last two versions, got %+v (%v)", got, err)
+	}
+	if got, err := revertMigrations(migrations, applied, last); err == nil || got != nil {
+		t.Fatalf("want an error and nothing reverted when reaching version 1, got %+v", got)
+	}
+}
+
+func TestLoadKlinePolicies(t *testing.T) {
+	t.Setenv("KLINE_POLICIES", "")
+	p, err := loadKlinePolicies(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CompressAfter.Std() != 7*24*time.Hour || p.Retention != 0 {
+		t.Fatalf("unexpected defaults %+v", p)
+	}
+
+	t.Setenv("KLINE_POLICIES", `{"compress_after": "720h", "retention": "168h"}`)
+	if _, err := loadKlinePolicies(nil); err == nil {
+		t.Fatal("want error when retention is shorter than compress_after")
+	}
+}
+
+func TestMigrateCommandRejectsBadArgs(t *testing.T) {
+	for _, args := range [][]string{nil, {"sideways"}, {"down", "x"}, {"up", "0"}, {"status", "1"}} {
+		if err := runMigrateCommand(context.Background(), nil, args, KlinePolicies{}); err == nil {
+			t.Errorf("want error for %v", args)
+		}
+	}
+}
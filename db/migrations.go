@@ -0,0 +1,177 @@
+package main
+
+// Migration 一个版本的 schema 变更; Up/Down 中的语句在同一事务内按顺序执行, Down 为空表示不可回滚
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// migrations klines 库的全部 schema 变更, 版本号递增, 已发布的版本不再修改
+//
+// 第 1 版兼容由旧的 db.go.bak 脚本建出、由早期写入服务在启动时补齐字段的 klines 表:
+// 所有语句都是幂等的, 已有的表和数据会被保留. 因此第 1 版不可回滚 (db migrate down 不会删除 klines 表),
+// 需要重建时手动删除.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create klines hypertable",
+		Up: []string{
+			"CREATE EXTENSION IF NOT EXISTS timescaledb",
+			`CREATE TABLE IF NOT EXISTS klines (
+				start_time TIMESTAMPTZ      NOT NULL,
+				symbol     TEXT             NOT NULL,
+				timeframe  TEXT             NOT NULL,
+				price_type TEXT             NOT NULL DEFAULT 'bid',
+				open       DOUBLE PRECISION,
+				high       DOUBLE PRECISION,
+				low        DOUBLE PRECISION,
+				close      DOUBLE PRECISION,
+				volume     BIGINT,
+				spread_min DOUBLE PRECISION,
+				spread_avg DOUBLE PRECISION,
+				spread_max DOUBLE PRECISION,
+				tick_count BIGINT,
+				synthetic  BOOLEAN          NOT NULL DEFAULT FALSE
+			)`,
+			// 早期的 klines 表没有买卖价序列、点差统计和 synthetic 字段
+			"ALTER TABLE klines ADD COLUMN IF NOT EXISTS price_type TEXT NOT NULL DEFAULT 'bid'",
+			"ALTER TABLE klines ADD COLUMN IF NOT EXISTS spread_min DOUBLE PRECISION",
+			"ALTER TABLE klines ADD COLUMN IF NOT EXISTS spread_avg DOUBLE PRECISION",
+			"ALTER TABLE klines ADD COLUMN IF NOT EXISTS spread_max DOUBLE PRECISION",
+			"ALTER TABLE klines ADD COLUMN IF NOT EXISTS tick_count BIGINT",
+			"ALTER TABLE klines ADD COLUMN IF NOT EXISTS synthetic BOOLEAN NOT NULL DEFAULT FALSE",
+			// 写入器 UPSERT 和 API 查询都依赖的唯一约束, 替换早期不含 price_type 的约束
+			`DO $$
+			BEGIN
+				IF NOT EXISTS (
+					SELECT 1 FROM pg_constraint
+					WHERE conname = 'klines_series_unique_constraint'
+				) THEN
+					ALTER TABLE klines DROP CONSTRAINT IF EXISTS klines_unique_constraint;
+					ALTER TABLE klines
+					ADD CONSTRAINT klines_series_unique_constraint
+					UNIQUE (symbol, timeframe, price_type, start_time);
+				END IF;
+			END $$`,
+			"SELECT create_hypertable('klines', 'start_time', if_not_exists => TRUE, migrate_data => TRUE)",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "enable klines compression",
+		Up: []string{
+			// 压缩策略 (多久之后压缩) 和保留策略由 KLINE_POLICIES 配置, 见 applyKlinePolicies
+			`ALTER TABLE klines SET (
+				timescaledb.compress,
+				timescaledb.compress_segmentby = 'symbol, timeframe, price_type',
+				timescaledb.compress_orderby = 'start_time DESC'
+			)`,
+		},
+		Down: []string{
+			"SELECT remove_compression_policy('klines', if_exists => TRUE)",
+			"SELECT decompress_chunk(c, if_compressed => TRUE) FROM show_chunks('klines') c",
+			"ALTER TABLE klines SET (timescaledb.compress = false)",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create kline_gaps",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS kline_gaps (
+				symbol       TEXT        NOT NULL,
+				timeframe    TEXT        NOT NULL,
+				price_type   TEXT        NOT NULL,
+				gap_start    TIMESTAMPTZ NOT NULL,
+				gap_end      TIMESTAMPTZ NOT NULL,
+				missing_bars INT         NOT NULL,
+				filled_bars  INT         NOT NULL DEFAULT 0,
+				status       TEXT        NOT NULL,
+				source       TEXT        NOT NULL DEFAULT '',
+				detected_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+				updated_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (symbol, timeframe, price_type, gap_start)
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS kline_gaps",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create klines_rollup and kline_rollup_diffs",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS klines_rollup (
+				start_time TIMESTAMPTZ      NOT NULL,
+				symbol     TEXT             NOT NULL,
+				timeframe  TEXT             NOT NULL,
+				price_type TEXT             NOT NULL,
+				open       DOUBLE PRECISION NOT NULL,
+				high       DOUBLE PRECISION NOT NULL,
+				low        DOUBLE PRECISION NOT NULL,
+				close      DOUBLE PRECISION NOT NULL,
+				volume     BIGINT           NOT NULL,
+				spread_min DOUBLE PRECISION,
+				spread_avg DOUBLE PRECISION,
+				spread_max DOUBLE PRECISION,
+				tick_count BIGINT,
+				synthetic  BOOLEAN          NOT NULL DEFAULT FALSE,
+				m1_bars    INT              NOT NULL,
+				updated_at TIMESTAMPTZ      NOT NULL DEFAULT now(),
+				PRIMARY KEY (symbol, timeframe, price_type, start_time)
+			)`,
+			`CREATE TABLE IF NOT EXISTS kline_rollup_diffs (
+				symbol        TEXT        NOT NULL,
+				timeframe     TEXT        NOT NULL,
+				price_type    TEXT        NOT NULL,
+				start_time    TIMESTAMPTZ NOT NULL,
+				fields        TEXT[]      NOT NULL,
+				live          JSONB       NOT NULL,
+				rollup        JSONB       NOT NULL,
+				m1_bars       INT         NOT NULL,
+				expected_bars INT         NOT NULL,
+				applied       BOOLEAN     NOT NULL DEFAULT FALSE,
+				detected_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+				updated_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (symbol, timeframe, price_type, start_time)
+			)`,
+			// 报告视图: 每个序列每个周期的不一致数量, 最近的排在前面
+			`CREATE OR REPLACE VIEW kline_rollup_report AS
+				SELECT symbol, timeframe, price_type,
+					count(*)                                        AS mismatched,
+					count(*) FILTER (WHERE applied)                 AS applied,
+					count(*) FILTER (WHERE m1_bars < expected_bars) AS incomplete,
+					min(start_time)                                 AS first_start,
+					max(start_time)                                 AS last_start,
+					max(updated_at)                                 AS last_seen
+				FROM kline_rollup_diffs
+				GROUP BY symbol, timeframe, price_type
+				ORDER BY last_seen DESC`,
+		},
+		Down: []string{
+			"DROP VIEW IF EXISTS kline_rollup_report",
+			"DROP TABLE IF EXISTS kline_rollup_diffs",
+			"DROP TABLE IF EXISTS klines_rollup",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create symbol_aliases",
+		Up: []string{
+			// candle 服务的品种映射规则; 与 API 的 symbols 品种目录分开
+			`CREATE TABLE IF NOT EXISTS symbol_aliases (
+				id          BIGSERIAL PRIMARY KEY,
+				platform_id INTEGER NOT NULL DEFAULT 0,
+				kind        TEXT    NOT NULL DEFAULT 'alias',
+				pattern     TEXT    NOT NULL,
+				symbol      TEXT    NOT NULL,
+				priority    INTEGER NOT NULL DEFAULT 0,
+				enabled     BOOLEAN NOT NULL DEFAULT TRUE
+			)`,
+		},
+		Down: []string{
+			"DROP TABLE IF EXISTS symbol_aliases",
+		},
+	},
+}
@@ -5,21 +5,29 @@ import (
 	"time"
 )
 
-// Candle K线结构
+// Candle K线结构 (parquet 标签供 ParquetSink 使用)
 type Candle struct {
-	StartTime time.Time `json:"start_time" db:"start_time"`
-	Symbol    string    `json:"symbol" db:"symbol"`
-	Timeframe string    `json:"timeframe" db:"timeframe"`
-	Open      float64   `json:"open" db:"open"`
-	High      float64   `json:"high" db:"high"`
-	Low       float64   `json:"low" db:"low"`
-	Close     float64   `json:"close" db:"close"`
-	Volume    int64     `json:"volume" db:"volume"`
+	StartTime time.Time `json:"start_time" db:"start_time" parquet:"start_time,timestamp"`
+	Symbol    string    `json:"symbol" db:"symbol" parquet:"symbol,dict"`
+	Timeframe string    `json:"timeframe" db:"timeframe" parquet:"timeframe,dict"`
+	Open      float64   `json:"open" db:"open" parquet:"open"`
+	High      float64   `json:"high" db:"high" parquet:"high"`
+	Low       float64   `json:"low" db:"low" parquet:"low"`
+	Close     float64   `json:"close" db:"close" parquet:"close"`
+	Volume    int64     `json:"volume" db:"volume" parquet:"volume"`
+
+	// 扩展字段 (旧版 candle 服务不发送, 缺省按 bid 处理)
+	PriceType string  `json:"price_type" db:"price_type" parquet:"price_type,dict"`
+	SpreadMin float64 `json:"spread_min" db:"spread_min" parquet:"spread_min"` // 点差统计为 ask-bid, 价格单位
+	SpreadAvg float64 `json:"spread_avg" db:"spread_avg" parquet:"spread_avg"`
+	SpreadMax float64 `json:"spread_max" db:"spread_max" parquet:"spread_max"`
+	TickCount int64   `json:"tick_count" db:"tick_count" parquet:"tick_count"`
+	Synthetic bool    `json:"synthetic" db:"synthetic" parquet:"synthetic"` // 缺口填充生成的K线
 }
 
 // Redis收到的结构
 type PublishEvent struct {
-	Status string `json:"status"` // "UPDATE" 或 "CLOSE"
+	Status string `json:"status"` // "UPDATE", "CLOSE" 或 "AMEND" (修正已闭合K线)
 	Candle Candle `json:"candle"`
 }
 
@@ -29,5 +37,8 @@ func ParseEvent(payload []byte) (*PublishEvent, error) {
 	if err := json.Unmarshal(payload, &event); err != nil {
 		return nil, err
 	}
+	if event.Candle.PriceType == "" {
+		event.Candle.PriceType = "bid"
+	}
 	return &event, nil
 }
\ No newline at end of file
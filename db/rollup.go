@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+
+	"shared/calendar"
+	"shared/configfile"
+)
+
+const rollupEventChannel = "kline_rollup" // Redis Pub/Sub: M1 汇总与实时K线的不一致
+
+// 汇总支持的周期; 桶边界按品种交易时段的时区和日切 (gap_audit.hours) 生成, 与 candle 服务的分桶一致
+var rollupTimeframes = map[string]time.Duration{
+	"M5": 5 * time.Minute, "M15": 15 * time.Minute, "M30": 30 * time.Minute,
+	"H1": time.Hour, "H4": 4 * time.Hour, "D1": 24 * time.Hour,
+}
+
+const rollupDayAligned = 4 * time.Hour // 从该周期起桶边界取决于日切, 见 canApply
+
+// RollupConfig M1 汇总配置 (环境变量 KLINE_ROLLUP, JSON; 或配置节 rollup)
+type RollupConfig struct {
+	Disabled   bool     `json:"disabled"`
+	Interval   Duration `json:"interval"`   // 汇总间隔, 默认 5m
+	Lookback   Duration `json:"lookback"`   // 每次汇总最近多久的K线, 默认 48h
+	Grace      Duration `json:"grace"`      // K线结束后等待 M1 写入的时间, 默认 2m
+	Timeframes []string `json:"timeframes"` // 汇总的周期, 默认 M5 M15 M30 H1 H4 D1
+	Tolerance  float64  `json:"tolerance"`  // 价格允许的误差, 默认 0 (必须完全一致)
+	Apply      bool     `json:"apply"`      // 用 M1 汇总结果覆盖不一致的实时K线 (只覆盖 M1 完整的K线, 见 canApply)
+}
+
+func loadRollupConfig(f *configfile.File) (RollupConfig, error) {
+	cfg := RollupConfig{}
+	if _, err := f.LoadJSONEnv("KLINE_ROLLUP", &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = Duration(5 * time.Minute)
+	}
+	if cfg.Lookback <= 0 {
+		cfg.Lookback = Duration(48 * time.Hour)
+	}
+	if cfg.Grace <= 0 {
+		cfg.Grace = Duration(2 * time.Minute)
+	}
+	if len(cfg.Timeframes) == 0 {
+		cfg.Timeframes = []string{"M5", "M15", "M30", "H1", "H4", "D1"}
+	}
+	for _, tf := range cfg.Timeframes {
+		if _, ok := rollupTimeframes[tf]; !ok {
+			return cfg, fmt.Errorf("KLINE_ROLLUP: unsupported timeframe %q", tf)
+		}
+	}
+	if cfg.Tolerance < 0 {
+		return cfg, fmt.Errorf("KLINE_ROLLUP: tolerance must not be negative")
+	}
+	return cfg, nil
+}
+
+// RollupDiff 一根实时K线与其 M1 汇总结果的不一致
+type RollupDiff struct {
+	Symbol       string    `json:"symbol"`
+	Timeframe    string    `json:"timeframe"`
+	PriceType    string    `json:"price_type"`
+	StartTime    time.Time `json:"start_time"`
+	Fields       []string  `json:"fields"`        // 不一致的字段; 实时K线缺失时为 ["missing"]
+	Live         Candle    `json:"live"`          // candle 服务实时聚合的K线
+	Rollup       Candle    `json:"rollup"`        // 由 M1 汇总的K线
+	M1Bars       int       `json:"m1_bars"`       // 参与汇总的 M1 根数
+	ExpectedBars int       `json:"expected_bars"` // 交易时段内应有的 M1 根数
+	Applied      bool      `json:"applied"`       // 已用汇总结果覆盖 (或补上) 实时K线并发布 AMEND
+}
+
+func (d RollupDiff) ToJSON() []byte {
+	b, _ := json.Marshal(d)
+	return b
+}
+
+// KlineRollup 定期由 M1 汇总出更大周期的K线, 写入 klines_rollup 表,
+// 与 candle 服务实时聚合的K线逐根比对, 不一致的记录到 kline_rollup_diffs 表并发布事件
+//
+// 各周期在 candle 服务中由 tick 独立聚合, 丢包或重连后可能与 M1 之和不一致;
+// M1 是权威数据 (缺失的 M1 由 GapAuditor 补齐), Apply 开启时以汇总结果为准修正实时K线,
+// 补上缺失的实时K线, 并以 AMEND 事件发布修正结果.
+type KlineRollup struct {
+	db       *sqlx.DB
+	rdb      *redis.Client
+	cfg      RollupConfig
+	hours    func(symbol string) *calendar.Calendar // 品种交易时段, 用于判断 M1 是否完整
+	reported map[string]string                      // 已发布事件的不一致及其字段, 避免每轮重复发布
+	seen     map[string]bool                        // 本轮仍存在的不一致, 用于清理 reported
+}
+
+func NewKlineRollup(db *sqlx.DB, rdb *redis.Client, cfg RollupConfig, hours func(string) *calendar.Calendar) *KlineRollup {
+	return &KlineRollup{db: db, rdb: rdb, cfg: cfg, hours: hours, reported: make(map[string]string)}
+}
+
+// Run 每隔 Interval 汇总一次, 阻塞直到 ctx 结束
+func (r *KlineRollup) Run(ctx context.Context) {
+	log.Printf("Kline rollup started (every %s, lookback %s, timeframes %v, apply %v)",
+		r.cfg.Interval.Std(), r.cfg.Lookback.Std(), r.cfg.Timeframes, r.cfg.Apply)
+	ticker := time.NewTicker(r.cfg.Interval.Std())
+	defer ticker.Stop()
+	for {
+		if err := r.Rollup(ctx, time.Now().UTC()); err != nil && ctx.Err() == nil {
+			log.Printf("ERROR: Kline rollup failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Rollup 汇总并比对所有配置的周期一次
+func (r *KlineRollup) Rollup(ctx context.Context, now time.Time) error {
+	r.seen = make(map[string]bool)
+	for _, tf := range r.cfg.Timeframes {
+		if err := r.rollupTimeframe(ctx, tf, now); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			log.Printf("ERROR: Kline rollup of %s failed: %v", tf, err)
+		}
+	}
+	for key := range r.reported {
+		if !r.seen[key] {
+			delete(r.reported, key)
+		}
+	}
+	return nil
+}
+
+// rollupPair 一根实时K线及其 M1 汇总结果
+type rollupPair struct {
+	Live   Candle `db:"live"`
+	Rollup Candle `db:"rollup"`
+	M1Bars int    `db:"m1_bars"`
+}
+
+// rollupTimeframe 汇总一个周期: 按 M1 所在品种的分桶规则生成桶, 把桶内的 M1 汇总写入 klines_rollup,
+// 再与实时K线逐根比对; 没有对应实时K线的桶同样报告
+func (r *KlineRollup) rollupTimeframe(ctx context.Context, timeframe string, now time.Time) error {
+	tf := rollupTimeframes[timeframe]
+	from := now.Add(-r.cfg.Lookback.Std())
+	until := now.Add(-r.cfg.Grace.Std())
+
+	var series []klineSeries
+	if err := r.db.SelectContext(ctx, &series,
+		"SELECT DISTINCT symbol, 'M1' AS timeframe, price_type FROM klines WHERE timeframe = 'M1' AND start_time >= $1 AND start_time < $2",
+		from, until); err != nil {
+		return err
+	}
+	for _, s := range series {
+		starts, ends := rollupBuckets(r.hours(s.Symbol).Hours(), tf, from, until)
+		if len(starts) == 0 {
+			continue
+		}
+		if _, err := r.db.ExecContext(ctx, `
+			INSERT INTO klines_rollup
+				(start_time, symbol, timeframe, price_type, open, high, low, close, volume,
+				 spread_min, spread_avg, spread_max, tick_count, synthetic, m1_bars)
+			SELECT b.start_time, $1, $2, $3, m.open, m.high, m.low, m.close, m.volume,
+				m.spread_min, m.spread_avg, m.spread_max, m.tick_count, m.synthetic, m.bars
+			FROM unnest($4::timestamptz[], $5::timestamptz[]) AS b(start_time, end_time)
+			CROSS JOIN LATERAL (
+				SELECT (array_agg(open ORDER BY start_time))[1] AS open, max(high) AS high, min(low) AS low,
+					(array_agg(close ORDER BY start_time DESC))[1] AS close, sum(volume) AS volume,
+					min(spread_min) AS spread_min,
+					coalesce(sum(spread_avg * tick_count) / nullif(sum(tick_count), 0), avg(spread_avg)) AS spread_avg,
+					max(spread_max) AS spread_max, sum(tick_count) AS tick_count,
+					bool_and(synthetic) AS synthetic, count(*) AS bars
+				FROM klines
+				WHERE symbol = $1 AND price_type = $3 AND timeframe = 'M1'
+					AND start_time >= b.start_time AND start_time < b.end_time
+			) m
+			WHERE m.bars > 0
+			ON CONFLICT (symbol, timeframe, price_type, start_time) DO UPDATE SET
+				open = EXCLUDED.open, high = EXCLUDED.high, low = EXCLUDED.low,
+				close = EXCLUDED.close, volume = EXCLUDED.volume,
+				spread_min = EXCLUDED.spread_min, spread_avg = EXCLUDED.spread_avg,
+				spread_max = EXCLUDED.spread_max, tick_count = EXCLUDED.tick_count,
+				synthetic = EXCLUDED.synthetic, m1_bars = EXCLUDED.m1_bars, updated_at = now()`,
+			s.Symbol, timeframe, s.PriceType, starts, ends); err != nil {
+			return err
+		}
+	}
+
+	var pairs []rollupPair
+	if err := r.db.SelectContext(ctx, &pairs, fmt.Sprintf(`
+		SELECT %s, %s, r.m1_bars
+		FROM klines k
+		JOIN klines_rollup r USING (symbol, timeframe, price_type, start_time)
+		WHERE k.timeframe = $1 AND k.start_time >= $2 AND k.start_time + make_interval(secs => $3) <= $4`,
+		candleSelect("k", "live"), candleSelect("r", "rollup")),
+		timeframe, from, tf.Seconds(), until); err != nil {
+		return err
+	}
+	var missing []rollupPair
+	if err := r.db.SelectContext(ctx, &missing, fmt.Sprintf(`
+		SELECT %s, r.m1_bars
+		FROM klines_rollup r
+		WHERE r.timeframe = $1 AND r.start_time >= $2 AND r.start_time + make_interval(secs => $3) <= $4
+			AND NOT EXISTS (
+				SELECT 1 FROM klines k
+				WHERE k.symbol = r.symbol AND k.timeframe = r.timeframe
+					AND k.price_type = r.price_type AND k.start_time = r.start_time)`,
+		candleSelect("r", "rollup")),
+		timeframe, from, tf.Seconds(), until); err != nil {
+		return err
+	}
+
+	mismatched, incomplete, applied, skipped := 0, 0, 0, 0
+	for _, p := range append(pairs, missing...) {
+		fields := []string{"missing"}
+		if p.Live.Symbol != "" {
+			if fields = candleMismatch(p.Live, p.Rollup, r.cfg.Tolerance); len(fields) == 0 {
+				continue
+			}
+		}
+		cal := r.hours(p.Rollup.Symbol)
+		start := p.Rollup.StartTime
+		d := RollupDiff{
+			Symbol: p.Rollup.Symbol, Timeframe: timeframe, PriceType: p.Rollup.PriceType, StartTime: start,
+			Fields: fields, Live: p.Live, Rollup: p.Rollup, M1Bars: p.M1Bars,
+			ExpectedBars: cal.OpenMinutes(start, bucketEnd(cal.Hours(), tf, start)),
+		}
+		mismatched++
+		if d.M1Bars < d.ExpectedBars {
+			incomplete++
+		} else if r.cfg.Apply && !r.canApply(d.Symbol, tf) {
+			skipped++
+		} else if r.cfg.Apply {
+			if err := r.apply(ctx, d); err != nil {
+				return err
+			}
+			d.Applied = true
+			applied++
+		}
+		if err := r.record(ctx, d); err != nil {
+			return err
+		}
+	}
+	if mismatched > 0 {
+		log.Printf("🧮 Rollup %s: %d of %d bars disagree with M1 (%d missing, %d with incomplete M1, %d corrected)",
+			timeframe, mismatched, len(pairs)+len(missing), len(missing), incomplete, applied)
+	}
+	if skipped > 0 {
+		log.Printf("⚠️  Rollup %s: %d bars not corrected, configure gap_audit.hours to match the candle service sessions", timeframe, skipped)
+	}
+	return nil
+}
+
+// canApply H4 及以上的桶边界取决于交易时段的时区和日切; 品种没有配置 gap_audit.hours 时
+// 无法确认与 candle 服务的分桶一致 (例如 candle 按经纪商日切), 这些周期只报告不修正
+func (r *KlineRollup) canApply(symbol string, tf time.Duration) bool {
+	return tf < rollupDayAligned || r.hours(symbol) != nil
+}
+
+// rollupBuckets 生成 [from, until] 内完整的K线区间 [starts[i], ends[i]),
+// 分桶规则与 candle 服务相同: 按交易时段的时区和日切对齐, D1 在夏令时切换日为 23 或 25 小时
+func rollupBuckets(hours *calendar.Hours, tf time.Duration, from, until time.Time) (starts, ends []time.Time) {
+	for t := hours.WindowStart(tf, from); ; {
+		next := bucketEnd(hours, tf, t)
+		if next.After(until) || !next.After(t) {
+			return starts, ends
+		}
+		if !t.Before(from) {
+			starts = append(starts, t)
+			ends = append(ends, next)
+		}
+		t = next
+	}
+}
+
+// bucketEnd 返回开始于 start 的K线的结束时间
+func bucketEnd(hours *calendar.Hours, tf time.Duration, start time.Time) time.Time {
+	return hours.FromWall(hours.ToWall(start).Add(tf))
+}
+
+// candleSelect 生成把 alias 表的K线列映射到 sqlx 嵌套字段 prefix.* 的 SELECT 列表
+func candleSelect(alias, prefix string) string {
+	cols := make([]string, 0, len(klineColumns))
+	for _, c := range klineColumns {
+		expr := alias + "." + c
+		switch c {
+		case "spread_min", "spread_avg", "spread_max", "tick_count":
+			expr = "coalesce(" + expr + ", 0)"
+		}
+		cols = append(cols, fmt.Sprintf(`%s AS "%s.%s"`, expr, prefix, c))
+	}
+	return strings.Join(cols, ", ")
+}
+
+// candleMismatch 返回两根K线不一致的字段; tick_count 只在两边都有记录时比较
+func candleMismatch(live, rollup Candle, tolerance float64) []string {
+	var fields []string
+	prices := []struct {
+		name string
+		a, b float64
+	}{
+		{"open", live.Open, rollup.Open}, {"high", live.High, rollup.High},
+		{"low", live.Low, rollup.Low}, {"close", live.Close, rollup.Close},
+	}
+	for _, p := range prices {
+		if math.Abs(p.a-p.b) > tolerance {
+			fields = append(fields, p.name)
+		}
+	}
+	if live.Volume != rollup.Volume {
+		fields = append(fields, "volume")
+	}
+	if live.TickCount > 0 && rollup.TickCount > 0 && live.TickCount != rollup.TickCount {
+		fields = append(fields, "tick_count")
+	}
+	return fields
+}
+
+// apply 用汇总结果覆盖实时K线的 OHLC、成交量和 tick 数 (实时K线缺失时写入汇总结果), 然后发布 AMEND
+func (r *KlineRollup) apply(ctx context.Context, d RollupDiff) error {
+	amended := d.Live
+	if amended.Symbol == "" {
+		amended = d.Rollup
+		if _, err := r.db.ExecContext(ctx, `
+			INSERT INTO klines
+				(start_time, symbol, timeframe, price_type, open, high, low, close, volume,
+				 spread_min, spread_avg, spread_max, tick_count, synthetic)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			ON CONFLICT (symbol, timeframe, price_type, start_time) DO NOTHING`,
+			amended.StartTime, amended.Symbol, amended.Timeframe, amended.PriceType,
+			amended.Open, amended.High, amended.Low, amended.Close, amended.Volume,
+			amended.SpreadMin, amended.SpreadAvg, amended.SpreadMax, amended.TickCount, amended.Synthetic); err != nil {
+			return fmt.Errorf("failed to apply rollup: %w", err)
+		}
+	} else {
+		c := d.Rollup
+		amended.Open, amended.High, amended.Low, amended.Close = c.Open, c.High, c.Low, c.Close
+		amended.Volume, amended.TickCount = c.Volume, c.TickCount
+		if _, err := r.db.ExecContext(ctx, `
+			UPDATE klines SET open = $5, high = $6, low = $7, close = $8, volume = $9, tick_count = $10
+			WHERE symbol = $1 AND timeframe = $2 AND price_type = $3 AND start_time = $4`,
+			c.Symbol, c.Timeframe, c.PriceType, c.StartTime, c.Open, c.High, c.Low, c.Close, c.Volume, c.TickCount); err != nil {
+			return fmt.Errorf("failed to apply rollup: %w", err)
+		}
+	}
+	r.publishAmend(ctx, amended)
+	return nil
+}
+
+// publishAmend 以 AMEND 事件发布修正后的K线, 格式与 candle 服务修正迟到Tick时相同:
+// 同时写入 Pub/Sub 频道和品种的 Stream, 两种传输方式的消费方 (API Hub、其它 sink) 都能收到.
+// Stream 条目不带 seq, 不影响 candle 服务的编号; Stream 不存在 (candle 未开启 stream 输出) 时不创建
+func (r *KlineRollup) publishAmend(ctx context.Context, c Candle) {
+	payload, _ := json.Marshal(PublishEvent{Status: "AMEND", Candle: c})
+	channel := klineChannel(c)
+	pipe := r.rdb.Pipeline()
+	pipe.Publish(ctx, channel, payload)
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream:     streamKeyPrefix + c.Symbol,
+		NoMkStream: true,
+		Values:     map[string]interface{}{"channel": channel, "event": payload},
+	})
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		log.Printf("ERROR: Failed to publish AMEND for %s %s %s: %v",
+			c.Symbol, c.Timeframe, c.StartTime.Format(time.RFC3339), err)
+	}
+}
+
+// klineChannel K线事件的 Pub/Sub 频道, 与 candle 服务一致: bid 为 kline:{symbol}:{timeframe}, 其它价格序列追加后缀
+func klineChannel(c Candle) string {
+	if c.PriceType == "" || c.PriceType == "bid" {
+		return fmt.Sprintf("kline:%s:%s", c.Symbol, c.Timeframe)
+	}
+	return fmt.Sprintf("kline:%s:%s:%s", c.Symbol, c.Timeframe, c.PriceType)
+}
+
+// record 写入不一致记录, 新的或字段变化的不一致发布事件
+func (r *KlineRollup) record(ctx context.Context, d RollupDiff) error {
+	live, _ := json.Marshal(d.Live)
+	rollup, _ := json.Marshal(d.Rollup)
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO kline_rollup_diffs
+			(symbol, timeframe, price_type, start_time, fields, live, rollup, m1_bars, expected_bars, applied)
+		VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7::jsonb, $8, $9, $10)
+		ON CONFLICT (symbol, timeframe, price_type, start_time) DO UPDATE SET
+			fields = EXCLUDED.fields, live = EXCLUDED.live, rollup = EXCLUDED.rollup,
+			m1_bars = EXCLUDED.m1_bars, expected_bars = EXCLUDED.expected_bars,
+			applied = EXCLUDED.applied, updated_at = now()`,
+		d.Symbol, d.Timeframe, d.PriceType, d.StartTime, d.Fields, string(live), string(rollup),
+		d.M1Bars, d.ExpectedBars, d.Applied)
+	if err != nil {
+		return fmt.Errorf("failed to record rollup diff: %w", err)
+	}
+
+	key := fmt.Sprintf("%s|%s|%s|%d", d.Symbol, d.Timeframe, d.PriceType, d.StartTime.Unix())
+	state := fmt.Sprintf("%v|%v", d.Fields, d.Applied)
+	r.seen[key] = true
+	if r.reported[key] == state {
+		return nil
+	}
+	r.reported[key] = state
+	if err := r.rdb.Publish(ctx, rollupEventChannel, d.ToJSON()).Err(); err != nil {
+		log.Printf("ERROR: Failed to publish rollup diff: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"shared/calendar"
+)
+
+func TestCandleMismatch(t *testing.T) {
+	live := Candle{Open: 2650.1, High: 2655.0, Low: 2648.2, Close: 2651.3, Volume: 120, TickCount: 120}
+	rollup := live
+	if fields := candleMismatch(live, rollup, 0); len(fields) != 0 {
+		t.Fatalf("want no mismatch, got %v", fields)
+	}
+
+	rollup.High = 2656.4
+	rollup.Volume = 131
+	rollup.TickCount = 131
+	want := []string{"high", "volume", "tick_count"}
+	if fields := candleMismatch(live, rollup, 0); !reflect.DeepEqual(fields, want) {
+		t.Fatalf("want %v, got %v", want, fields)
+	}
+
+	// 容差内的价格差异和旧版K线缺少的 tick_count 不算不一致
+	rollup = live
+	rollup.Close = 2651.3000001
+	live.TickCount = 0
+	rollup.TickCount = 99
+	if fields := candleMismatch(live, rollup, 1e-6); len(fields) != 0 {
+		t.Fatalf("want no mismatch within tolerance, got %v", fields)
+	}
+}
+
+func TestCandleSelectMapsNestedColumns(t *testing.T) {
+	cols := candleSelect("k", "live")
+	for _, want := range []string{`k.open AS "live.open"`, `coalesce(k.tick_count, 0) AS "live.tick_count"`} {
+		if !strings.Contains(cols, want) {
+			t.Fatalf("want %q in %s", want, cols)
+		}
+	}
+}
+
+func TestRollupBucketsFollowSessionDayRoll(t *testing.T) {
+	hours, err := (&calendar.SessionConfig{Timezone: "America/New_York", DayRoll: "17:00"}).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2025-03-09 纽约进入夏令时: 当天的 D1 只有 23 小时
+	from := time.Date(2025, 3, 7, 12, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 3, 11, 0, 0, 0, 0, time.UTC)
+	starts, ends := rollupBuckets(hours, 24*time.Hour, from, until)
+
+	want := []time.Time{
+		time.Date(2025, 3, 7, 22, 0, 0, 0, time.UTC), // 周五 17:00 EST
+		time.Date(2025, 3, 8, 22, 0, 0, 0, time.UTC),
+		time.Date(2025, 3, 9, 21, 0, 0, 0, time.UTC), // 周日 17:00 EDT
+	}
+	if !reflect.DeepEqual(starts, want) {
+		t.Fatalf("want %v, got %v", want, starts)
+	}
+	if d := ends[1].Sub(starts[1]); d != 23*time.Hour {
+		t.Fatalf("want a 23h bar across the DST switch, got %s", d)
+	}
+
+	// 没有时段配置时按 UTC 对齐, 不完整的最后一根不汇总
+	starts, _ = rollupBuckets(nil, time.Hour, from.Add(30*time.Minute), from.Add(3*time.Hour+30*time.Minute))
+	if len(starts) != 2 || !starts[0].Equal(from.Add(time.Hour)) {
+		t.Fatalf("unexpected UTC buckets %v", starts)
+	}
+}
+
+func TestKlineChannelMatchesCandleService(t *testing.T) {
+	if ch := klineChannel(Candle{Symbol: "XAUUSD", Timeframe: "H1", PriceType: "bid"}); ch != "kline:XAUUSD:H1" {
+		t.Fatalf("unexpected bid channel %s", ch)
+	}
+	if ch := klineChannel(Candle{Symbol: "XAUUSD", Timeframe: "H1", PriceType: "ask"}); ch != "kline:XAUUSD:H1:ask" {
+		t.Fatalf("unexpected ask channel %s", ch)
+	}
+}
+
+func TestLoadRollupConfig(t *testing.T) {
+	t.Setenv("KLINE_ROLLUP", "")
+	cfg, err := loadRollupConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Timeframes) != 6 || cfg.Apply {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+
+	t.Setenv("KLINE_ROLLUP", `{"timeframes": ["M1"]}`)
+	if _, err := loadRollupConfig(nil); err == nil {
+		t.Fatal("want error for rolling up M1")
+	}
+}
+
+func TestRollupAppliesDayAlignedBarsOnlyWithHours(t *testing.T) {
+	cal, err := calendar.Compile(&calendar.SessionConfig{Timezone: "America/New_York", DayRoll: "17:00"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewKlineRollup(nil, nil, RollupConfig{}, func(symbol string) *calendar.Calendar {
+		if symbol == "XAUUSD" {
+			return cal
+		}
+		return nil
+	})
+	if !r.canApply("EURUSD", time.Hour) || r.canApply("EURUSD", 4*time.Hour) || r.canApply("EURUSD", 24*time.Hour) {
+		t.Fatal("want H4 and D1 left uncorrected without configured hours")
+	}
+	if !r.canApply("XAUUSD", 24*time.Hour) {
+		t.Fatal("want D1 corrected when the symbol has hours")
+	}
+}
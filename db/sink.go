@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/jmoiron/sqlx"
+
+	"shared/configfile"
+)
+
+// CandleSink 闭合K线的存储目标
+//
+// Write 可能收到同一根K线的多个版本 (AMEND, 或写入失败后重放), 实现需要按
+// (symbol, timeframe, price_type, start_time) 幂等或以最后一次为准.
+type CandleSink interface {
+	Name() string
+	Write(ctx context.Context, candles []Candle) error
+	Close() error
+}
+
+// 支持的存储类型
+const (
+	SinkTimescaleDB = "timescaledb" // klines 超表 (默认)
+	SinkClickHouse  = "clickhouse"  // ClickHouse HTTP 接口 (INSERT ... FORMAT JSONEachRow)
+	SinkParquet     = "parquet"     // 按 品种/日期 分区的 Parquet 文件, 每天收盘后生成
+	SinkNDJSON      = "ndjson"      // 按 品种/日期 分区的 NDJSON 文件, 实时追加
+)
+
+// SinkConfig 一个存储目标的配置 (环境变量 CANDLE_SINKS, JSON 数组; 或配置节 sinks), 例如
+//
+//	[{"type": "timescaledb"},
+//	 {"type": "parquet", "dir": "/data/klines", "timeframes": ["M1", "D1"]}]
+type SinkConfig struct {
+	Type       string   `json:"type"`
+	Name       string   `json:"name"`       // 日志和溢出列表使用的名称, 默认与 type 相同
+	Timeframes []string `json:"timeframes"` // 只写入这些周期, 为空表示全部
+	SpillKey   string   `json:"spill_key"`  // 写入失败的事件转存到该 Redis List, 默认 DB_WRITER.spill_key + ":" + name
+
+	// clickhouse
+	URL      string   `json:"url"`      // 如 "http://localhost:8123"
+	Database string   `json:"database"` // 默认 "default"
+	Table    string   `json:"table"`    // 默认 "klines"
+	User     string   `json:"user"`
+	Password string   `json:"password"`
+	Timeout  Duration `json:"timeout"` // 单次请求超时, 默认 30s
+
+	// parquet / ndjson
+	Dir           string   `json:"dir"`
+	FinalizeAfter Duration `json:"finalize_after"` // parquet: UTC 日结束后多久生成当天文件, 默认 1h
+}
+
+// loadSinkConfigs 读取 CANDLE_SINKS, 未设置时只写 TimescaleDB
+func loadSinkConfigs(f *configfile.File, writerCfg KlineWriterConfig) ([]SinkConfig, error) {
+	var configs []SinkConfig
+	if ok, err := f.LoadJSONEnv("CANDLE_SINKS", &configs); err != nil {
+		return nil, err
+	} else if !ok {
+		configs = []SinkConfig{{Type: SinkTimescaleDB}}
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("CANDLE_SINKS: at least one sink is required")
+	}
+
+	names := make(map[string]bool)
+	for i := range configs {
+		c := &configs[i]
+		if c.Name == "" {
+			c.Name = c.Type
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("CANDLE_SINKS: duplicate sink name %q", c.Name)
+		}
+		names[c.Name] = true
+		if c.SpillKey == "" {
+			c.SpillKey = writerCfg.SpillKey + ":" + c.Name
+			if c.Name == SinkTimescaleDB {
+				c.SpillKey = writerCfg.SpillKey // 与只写 TimescaleDB 的旧版本共用溢出列表
+			}
+		}
+
+		switch c.Type {
+		case SinkTimescaleDB:
+		case SinkClickHouse:
+			if c.URL == "" {
+				return nil, fmt.Errorf("CANDLE_SINKS: sink %q: url is required", c.Name)
+			}
+			if c.Database == "" {
+				c.Database = "default"
+			}
+			if c.Table == "" {
+				c.Table = "klines"
+			}
+			if c.Timeout <= 0 {
+				c.Timeout = Duration(sinkWriteTimeout)
+			}
+		case SinkParquet, SinkNDJSON:
+			if c.Dir == "" {
+				return nil, fmt.Errorf("CANDLE_SINKS: sink %q: dir is required", c.Name)
+			}
+			if c.FinalizeAfter <= 0 {
+				c.FinalizeAfter = Duration(defaultFinalizeAfter)
+			}
+		default:
+			return nil, fmt.Errorf("CANDLE_SINKS: sink %q: unsupported type %q", c.Name, c.Type)
+		}
+	}
+	return configs, nil
+}
+
+// needsDatabase 是否配置了 TimescaleDB 存储 (没有时不连接数据库, 也不运行审计和汇总)
+func needsDatabase(configs []SinkConfig) bool {
+	for _, c := range configs {
+		if c.Type == SinkTimescaleDB {
+			return true
+		}
+	}
+	return false
+}
+
+// newCandleSink 按配置创建存储目标; db 只在 timescaledb 类型时使用
+func newCandleSink(cfg SinkConfig, db *sqlx.DB) (CandleSink, error) {
+	switch cfg.Type {
+	case SinkTimescaleDB:
+		return NewTimescaleSink(cfg.Name, db), nil
+	case SinkClickHouse:
+		return NewClickHouseSink(cfg), nil
+	case SinkNDJSON:
+		return NewNDJSONSink(cfg.Name, cfg.Dir)
+	case SinkParquet:
+		return NewParquetSink(cfg.Name, cfg.Dir, cfg.FinalizeAfter.Std())
+	default:
+		return nil, fmt.Errorf("unsupported sink type %q", cfg.Type)
+	}
+}
+
+// partitionDir 文件类存储的分区目录: <dir>/symbol=<symbol>/date=<UTC 日期>
+func partitionDir(dir string, c Candle) string {
+	return filepath.Join(dir, "symbol="+c.Symbol, "date="+c.StartTime.UTC().Format("2006-01-02"))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	ndjsonFile           = "candles.ndjson"
+	parquetFile          = "candles.parquet"
+	defaultFinalizeAfter = time.Hour
+	finalizeScanInterval = time.Minute // Parquet 检查可生成的日期的间隔
+)
+
+// NDJSONSink 按 品种/UTC日期 分区追加 NDJSON 文件: <dir>/symbol=XAUUSD/date=2025-01-06/candles.ndjson
+//
+// 同一根K线的 AMEND 和重放会追加新的一行, 读取时以最后一行为准.
+type NDJSONSink struct {
+	name string
+	dir  string
+}
+
+func NewNDJSONSink(name, dir string) (*NDJSONSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("sink %s: %w", name, err)
+	}
+	return &NDJSONSink{name: name, dir: dir}, nil
+}
+
+func (s *NDJSONSink) Name() string { return s.name }
+
+func (s *NDJSONSink) Close() error { return nil }
+
+func (s *NDJSONSink) Write(ctx context.Context, candles []Candle) error {
+	partitions := make(map[string][]Candle)
+	for _, c := range candles {
+		dir := partitionDir(s.dir, c)
+		partitions[dir] = append(partitions[dir], c)
+	}
+	for dir, rows := range partitions {
+		if err := appendNDJSON(dir, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendNDJSON(dir string, candles []Candle) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, ndjsonFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, c := range candles {
+		if err := enc.Encode(c); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readNDJSON 读取分区文件; 崩溃时写了一半的行会被跳过
+func readNDJSON(path string) ([]Candle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var candles []Candle
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var c Candle
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			log.Printf("⚠️  Skipping malformed line in %s: %v", path, err)
+			continue
+		}
+		candles = append(candles, c)
+	}
+	return candles, scanner.Err()
+}
+
+// ParquetSink 按 品种/UTC日期 分区生成 Parquet 文件: <dir>/symbol=XAUUSD/date=2025-01-06/candles.parquet
+//
+// Parquet 文件不能追加, K线先以 NDJSON 暂存在 <dir>/.staging 下, UTC 日结束 FinalizeAfter 之后
+// 去重 (每根K线保留最后一个版本) 生成当天的文件. 生成之后才到达的 AMEND 会与已有文件合并后重新生成.
+// 生成由独立的定时器触发, 没有新K线写入 (如周末休市) 时到期的日期同样会生成.
+type ParquetSink struct {
+	name          string
+	dir           string
+	finalizeAfter time.Duration
+	staging       *NDJSONSink
+	mu            sync.Mutex // 暂存写入与生成文件互斥, 避免生成时删除刚追加的K线
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+func NewParquetSink(name, dir string, finalizeAfter time.Duration) (*ParquetSink, error) {
+	return newParquetSink(name, dir, finalizeAfter, finalizeScanInterval)
+}
+
+func newParquetSink(name, dir string, finalizeAfter, scanEvery time.Duration) (*ParquetSink, error) {
+	staging, err := NewNDJSONSink(name, filepath.Join(dir, ".staging"))
+	if err != nil {
+		return nil, err
+	}
+	s := &ParquetSink{
+		name: name, dir: dir, finalizeAfter: finalizeAfter, staging: staging,
+		stop: make(chan struct{}), done: make(chan struct{}),
+	}
+	go s.run(scanEvery)
+	return s, nil
+}
+
+func (s *ParquetSink) Name() string { return s.name }
+
+// Close 停止定时器并生成已到期的日期; 未到期的留在暂存区, 下次启动后继续
+func (s *ParquetSink) Close() error {
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finalize(time.Now())
+}
+
+func (s *ParquetSink) Write(ctx context.Context, candles []Candle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.staging.Write(ctx, candles)
+}
+
+// run 每隔 scanEvery 生成已到期的日期; 失败时暂存文件保留, 下次检查时重试
+func (s *ParquetSink) run(scanEvery time.Duration) {
+	defer close(s.done)
+	ticker := time.NewTicker(scanEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			err := s.finalize(now)
+			s.mu.Unlock()
+			if err != nil {
+				log.Printf("ERROR: Sink %s failed to write parquet files: %v", s.name, err)
+			}
+		}
+	}
+}
+
+// finalize 为所有已到期的暂存分区生成 Parquet 文件
+func (s *ParquetSink) finalize(now time.Time) error {
+	staged, err := filepath.Glob(filepath.Join(s.staging.dir, "symbol=*", "date=*", ndjsonFile))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, path := range staged {
+		partition := filepath.Dir(path)
+		day, err := time.Parse("2006-01-02", strings.TrimPrefix(filepath.Base(partition), "date="))
+		if err != nil || now.Before(day.Add(24*time.Hour+s.finalizeAfter)) {
+			continue
+		}
+		rel, _ := filepath.Rel(s.staging.dir, partition)
+		if err := s.writePartition(path, filepath.Join(s.dir, rel)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", rel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writePartition 合并暂存文件和已有的 Parquet 文件, 写入新文件后替换, 再删除暂存文件
+func (s *ParquetSink) writePartition(stagedPath, dir string) error {
+	candles, err := readNDJSON(stagedPath)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(dir, parquetFile)
+	existing, err := parquet.ReadFile[Candle](target)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	rows := dedupeCandles(append(existing, candles...))
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Timeframe != b.Timeframe {
+			return a.Timeframe < b.Timeframe
+		}
+		if a.PriceType != b.PriceType {
+			return a.PriceType < b.PriceType
+		}
+		return a.StartTime.Before(b.StartTime)
+	})
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp := target + ".tmp"
+	if err := parquet.WriteFile(tmp, rows, parquet.Compression(&parquet.Zstd)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+	log.Printf("📦 Sink %s wrote %d candles to %s", s.name, len(rows), target)
+
+	if err := os.Remove(stagedPath); err != nil {
+		return err
+	}
+	os.Remove(filepath.Dir(stagedPath)) // 空的暂存目录, 失败 (非空) 时忽略
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func testCandle(symbol, timeframe string, start time.Time, close float64) Candle {
+	return Candle{StartTime: start, Symbol: symbol, Timeframe: timeframe, PriceType: "bid",
+		Open: close - 1, High: close + 1, Low: close - 2, Close: close, Volume: 10, TickCount: 10}
+}
+
+func TestNDJSONSinkPartitionsBySymbolAndDay(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewNDJSONSink("ndjson", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2025, 1, 6, 23, 59, 0, 0, time.UTC)
+	err = sink.Write(context.Background(), []Candle{
+		testCandle("XAUUSD", "M1", day, 2650),
+		testCandle("XAUUSD", "M1", day.Add(time.Minute), 2651),
+		testCandle("EURUSD", "M1", day, 1.03),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]int{
+		"symbol=XAUUSD/date=2025-01-06/candles.ndjson": 1,
+		"symbol=XAUUSD/date=2025-01-07/candles.ndjson": 1,
+		"symbol=EURUSD/date=2025-01-06/candles.ndjson": 1,
+	} {
+		rows, err := readNDJSON(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != want {
+			t.Fatalf("%s: want %d rows, got %d", path, want, len(rows))
+		}
+	}
+}
+
+func TestParquetSinkFinalizesCompletedDays(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := newParquetSink("parquet", dir, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	day := time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	if err := sink.staging.Write(ctx, []Candle{
+		testCandle("XAUUSD", "M1", day, 2650),
+		testCandle("XAUUSD", "M1", day.Add(time.Minute), 2651),
+		testCandle("XAUUSD", "M1", day, 2652), // AMEND
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "symbol=XAUUSD", "date=2025-01-06", parquetFile)
+	if err := sink.finalize(time.Date(2025, 1, 7, 0, 30, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatal("want no parquet file before finalize_after has passed")
+	}
+
+	if err := sink.finalize(day.Add(48 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := parquet.ReadFile[Candle](target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Close != 2652 || !rows[0].StartTime.Equal(day) {
+		t.Fatalf("want the amended bar and the next bar, got %+v", rows)
+	}
+
+	// 生成之后到达的 AMEND 与已有文件合并
+	if err := sink.staging.Write(ctx, []Candle{testCandle("XAUUSD", "M1", day.Add(time.Minute), 2655)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.finalize(day.Add(48 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if rows, err = parquet.ReadFile[Candle](target); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1].Close != 2655 {
+		t.Fatalf("want the late amend merged, got %+v", rows)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".staging", "symbol=XAUUSD", "date=2025-01-06")); !os.IsNotExist(err) {
+		t.Fatal("want the staging partition removed")
+	}
+}
+
+func TestParquetSinkFinalizesWithoutWrites(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := newParquetSink("parquet", dir, time.Hour, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	// 暂存的是早已到期的日期, 之后不再有K线写入: 定时器仍会生成文件
+	day := time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC)
+	if err := sink.Write(context.Background(), []Candle{testCandle("XAUUSD", "M1", day, 2650)}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "symbol=XAUUSD", "date=2025-01-06", parquetFile)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(target); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("want the parquet file written by the finalize ticker")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClickHouseSinkPostsJSONEachRow(t *testing.T) {
+	var query, user string
+	var rows []Candle
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, user = r.URL.Query().Get("query"), r.Header.Get("X-ClickHouse-User")
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var c Candle
+			if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+				t.Errorf("bad row %q: %v", scanner.Text(), err)
+			}
+			rows = append(rows, c)
+		}
+	}))
+	defer server.Close()
+
+	sink := NewClickHouseSink(SinkConfig{Name: "ch", URL: server.URL, Database: "market", Table: "klines", User: "writer", Timeout: Duration(time.Second)})
+	day := time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC)
+	if err := sink.Write(context.Background(), []Candle{testCandle("XAUUSD", "M1", day, 2650), testCandle("XAUUSD", "M5", day, 2650)}); err != nil {
+		t.Fatal(err)
+	}
+	if query != "INSERT INTO market.klines FORMAT JSONEachRow" || user != "writer" || len(rows) != 2 {
+		t.Fatalf("unexpected request: query %q, user %q, %d rows", query, user, len(rows))
+	}
+}
+
+func TestClickHouseSinkReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "Code: 60. DB::Exception: Table market.klines does not exist", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	sink := NewClickHouseSink(SinkConfig{Name: "ch", URL: server.URL, Database: "market", Table: "klines", Timeout: Duration(time.Second)})
+	err := sink.Write(context.Background(), []Candle{testCandle("XAUUSD", "M1", time.Now(), 2650)})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("want the clickhouse error message, got %v", err)
+	}
+}
+
+func TestLoadSinkConfigs(t *testing.T) {
+	writerCfg := KlineWriterConfig{SpillKey: "kline_spill"}
+
+	t.Setenv("CANDLE_SINKS", "")
+	configs, err := loadSinkConfigs(nil, writerCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].Type != SinkTimescaleDB || configs[0].SpillKey != "kline_spill" || !needsDatabase(configs) {
+		t.Fatalf("want timescaledb only by default, got %+v", configs)
+	}
+
+	t.Setenv("CANDLE_SINKS", `[{"type": "parquet", "dir": "/data/klines"}, {"type": "ndjson", "name": "raw", "dir": "/data/raw"}]`)
+	if configs, err = loadSinkConfigs(nil, writerCfg); err != nil {
+		t.Fatal(err)
+	}
+	if needsDatabase(configs) || configs[0].SpillKey != "kline_spill:parquet" || configs[1].SpillKey != "kline_spill:raw" {
+		t.Fatalf("unexpected sinks %+v", configs)
+	}
+
+	for _, bad := range []string{
+		`[]`,
+		`[{"type": "s3"}]`,
+		`[{"type": "clickhouse"}]`,
+		`[{"type": "ndjson", "dir": "/a"}, {"type": "ndjson", "dir": "/b"}]`,
+	} {
+		t.Setenv("CANDLE_SINKS", bad)
+		if _, err := loadSinkConfigs(nil, writerCfg); err == nil {
+			t.Errorf("want error for %s", bad)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+var klineColumns = []string{
+	"start_time", "symbol", "timeframe", "price_type", "open", "high", "low", "close", "volume",
+	"spread_min", "spread_avg", "spread_max", "tick_count", "synthetic",
+}
+
+// TimescaleSink 写入 klines 超表
+type TimescaleSink struct {
+	name string
+	db   *sqlx.DB
+}
+
+func NewTimescaleSink(name string, db *sqlx.DB) *TimescaleSink {
+	return &TimescaleSink{name: name, db: db}
+}
+
+func (s *TimescaleSink) Name() string { return s.name }
+
+// Close 数据库连接由 main 管理
+func (s *TimescaleSink) Close() error { return nil }
+
+// Write 通过 COPY 写入临时表, 再一次 UPSERT 到 klines (幂等, AMEND 覆盖已写入的K线)
+func (s *TimescaleSink) Write(ctx context.Context, candles []Candle) error {
+	rows := make([][]interface{}, len(candles))
+	for i, c := range candles {
+		rows[i] = []interface{}{c.StartTime, c.Symbol, c.Timeframe, c.PriceType, c.Open, c.High, c.Low, c.Close, c.Volume,
+			c.SpreadMin, c.SpreadAvg, c.SpreadMax, c.TickCount, c.Synthetic}
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		tx, err := pgConn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		if _, err := tx.Exec(ctx, `CREATE TEMP TABLE klines_stage ON COMMIT DROP AS
+			SELECT start_time, symbol, timeframe, price_type, open, high, low, close, volume,
+				spread_min, spread_avg, spread_max, tick_count, synthetic
+			FROM klines WITH NO DATA`); err != nil {
+			return err
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"klines_stage"}, klineColumns, pgx.CopyFromRows(rows)); err != nil {
+			return err
+		}
+		// 关键: ON CONFLICT DO UPDATE 保证幂等性, 同时让 AMEND 覆盖已写入的K线
+		// (唯一约束 (symbol, timeframe, price_type, start_time) 由 migrations 第 1 版创建)
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO klines
+				(start_time, symbol, timeframe, price_type, open, high, low, close, volume,
+				 spread_min, spread_avg, spread_max, tick_count, synthetic)
+			SELECT start_time, symbol, timeframe, price_type, open, high, low, close, volume,
+				spread_min, spread_avg, spread_max, tick_count, synthetic
+			FROM klines_stage
+			ON CONFLICT (symbol, timeframe, price_type, start_time) DO UPDATE SET
+				open = EXCLUDED.open, high = EXCLUDED.high, low = EXCLUDED.low,
+				close = EXCLUDED.close, volume = EXCLUDED.volume,
+				spread_min = EXCLUDED.spread_min, spread_avg = EXCLUDED.spread_avg,
+				spread_max = EXCLUDED.spread_max, tick_count = EXCLUDED.tick_count,
+				synthetic = EXCLUDED.synthetic`); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis Streams 约定 (与 candle 服务一致)
+const (
+	streamKeyPrefix     = "kline_stream:" // 每个品种一个 Stream: kline_stream:{symbol}
+	streamIndexKey      = "kline_streams" // Set: 所有品种的 Stream key
+	dbWriterGroup       = "db_writer"     // 默认消费组名 (DB_WRITER.group)
+	streamRefreshPeriod = 10 * time.Second
+	streamReadBatch     = 200
+	streamReadBlock     = 2 * time.Second
+)
+
+// RunStreams 以消费组方式读取所有品种的K线 Stream, 交给写入器批量写库,
+// 写库 (或转存) 成功后才 ACK; 写入服务停机期间产生的事件保留在 Stream 中, 重启后继续处理.
+// ctx 结束后不再读取新消息; 已读到的事件由 KlineWriter.Close 写完
+func (s *DBWriterService) RunStreams(ctx context.Context) {
+	consumer, _ := os.Hostname()
+	if consumer == "" {
+		consumer = "db-writer"
+	}
+	log.Printf("DB Writer started. Consuming kline streams as %s/%s", s.writer.cfg.Group, consumer)
+
+	joined := make(map[string]bool) // 已创建消费组的 Stream
+	var streams []string
+	var lastRefresh time.Time
+	readPending := true                    // 启动时先处理本消费者上次领取但未 ACK 的消息
+	pendingFrom := make(map[string]string) // 读取待处理消息的位置: 已交给写入器的最后一条
+
+	for ctx.Err() == nil {
+		if time.Since(lastRefresh) > streamRefreshPeriod {
+			streams = s.refreshStreams(ctx, joined)
+			lastRefresh = time.Now()
+		}
+		if len(streams) == 0 {
+			sleepCtx(ctx, time.Second)
+			continue
+		}
+
+		args := make([]string, 0, 2*len(streams))
+		args = append(args, streams...)
+		for _, stream := range streams {
+			id := ">"
+			if readPending {
+				if id = pendingFrom[stream]; id == "" {
+					id = "0"
+				}
+			}
+			args = append(args, id)
+		}
+		res, err := s.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    s.writer.cfg.Group,
+			Consumer: consumer,
+			Streams:  args,
+			Count:    streamReadBatch,
+			Block:    streamReadBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("ERROR: XREADGROUP failed: %v", err)
+				sleepCtx(ctx, time.Second)
+			}
+			continue
+		}
+
+		got := 0
+		for _, stream := range res {
+			for _, msg := range stream.Messages {
+				got++
+				s.handleEvent([]byte(fmt.Sprint(msg.Values["event"])), stream.Stream, msg.ID)
+				pendingFrom[stream.Stream] = msg.ID
+			}
+		}
+		// 待处理消息按 ID 顺序从上次的位置继续读, 读完 (返回为空) 后改为读取新消息;
+		// 写库失败的事件由写入器转存, 不再依赖重新读取待处理消息
+		if readPending && got == 0 {
+			readPending = false
+		}
+	}
+}
+
+// refreshStreams 读取 Stream 索引, 为新出现的 Stream 创建消费组 (从头消费)
+func (s *DBWriterService) refreshStreams(ctx context.Context, joined map[string]bool) []string {
+	keys, err := s.rdb.SMembers(ctx, streamIndexKey).Result()
+	if err != nil {
+		log.Printf("ERROR: Failed to list kline streams: %v", err)
+	}
+	for _, key := range keys {
+		if joined[key] {
+			continue
+		}
+		err := s.rdb.XGroupCreateMkStream(ctx, key, s.writer.cfg.Group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			log.Printf("ERROR: Failed to create consumer group on %s: %v", key, err)
+			continue
+		}
+		joined[key] = true
+		log.Printf("Joined kline stream %s", key)
+	}
+
+	streams := make([]string, 0, len(joined))
+	for key := range joined {
+		streams = append(streams, key)
+	}
+	return streams
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
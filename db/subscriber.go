@@ -5,21 +5,19 @@ import (
 	"log"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/jmoiron/sqlx"
-	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
 type DBWriterService struct {
-	rdb *redis.Client
-	db  *sqlx.DB
+	rdb    *redis.Client
+	writer *KlineWriter
 }
 
-func NewDBWriterService(rdb *redis.Client, db *sqlx.DB) *DBWriterService {
-	return &DBWriterService{ rdb: rdb, db: db }
+func NewDBWriterService(rdb *redis.Client, writer *KlineWriter) *DBWriterService {
+	return &DBWriterService{rdb: rdb, writer: writer}
 }
 
-func (s *DBWriterService) Run() {
-	ctx := context.Background()
+// Run 订阅 Pub/Sub 并把事件交给写入器, ctx 结束时处理完当前消息后返回
+func (s *DBWriterService) Run(ctx context.Context) {
 	pubsub := s.rdb.PSubscribe(ctx, "kline:*:*")
 	_, err := pubsub.Receive(ctx)
 	if err != nil {
@@ -27,48 +25,35 @@ func (s *DBWriterService) Run() {
 	}
 	log.Println("DB Writer started. Subscribed to 'kline:*:*'")
 
+	// ctx 结束时关闭订阅, Channel 随之关闭, 下面的循环在当前消息写完后退出
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+
 	ch := pubsub.Channel()
 	for msg := range ch {
-		// (可选) 可以在 goroutine 中处理, 或使用 worker pool,
-		// 但对于DB写入, 顺序处理更简单
 		s.processMessage(msg)
 	}
 }
 
 func (s *DBWriterService) processMessage(msg *redis.Message) {
-	event, err := ParseEvent([]byte(msg.Payload))
-	if err != nil {
-		log.Printf("ERROR: Failed to parse event payload: %v", err)
-		return
-	}
+	s.handleEvent([]byte(msg.Payload), "", "")
+}
 
-	// 关键逻辑: 只处理 "CLOSE" 事件
-	if event.Status != "CLOSE" {
-		return // 忽略 "UPDATE"
+// handleEvent 解析K线事件并交给写入器; 只写 "CLOSE" 和 "AMEND" (迟到Tick修正),
+// 其它事件 (UPDATE) 和无法解析的消息来自 Stream 时只需 ACK
+func (s *DBWriterService) handleEvent(payload []byte, stream, id string) {
+	item := writeItem{payload: payload, stream: stream, id: id}
+	event, err := ParseEvent(payload)
+	switch {
+	case err != nil:
+		log.Printf("ERROR: Failed to parse event payload: %v", err)
+	case event.Status == "CLOSE" || event.Status == "AMEND":
+		item.event = event
 	}
-
-	if err := s.insertKlineToDB(event.Candle); err != nil {
-		log.Printf("ERROR: Failed to insert kline to DB: %v", err)
-	} else {
-		log.Printf("DB Write: %s %s StartTime: %s", 
-			event.Candle.Symbol, 
-			event.Candle.Timeframe, 
-			event.Candle.StartTime.Format("15:04:05"))
+	if item.event == nil && stream == "" {
+		return
 	}
-}
-
-// insertKlineToDB 执行数据库 INSERT 操作 (幂等)
-func (s *DBWriterService) insertKlineToDB(c Candle) error {
-	// 关键: ON CONFLICT DO NOTHING 保证幂等性
-	// (这需要您在DB中为 (symbol, timeframe, start_time) 设置 UNIQUE 约束)
-	query := `
-		INSERT INTO klines 
-			(start_time, symbol, timeframe, open, high, low, close, volume)
-		VALUES 
-			(:start_time, :symbol, :timeframe, :open, :high, :low, :close, :volume)
-		ON CONFLICT (symbol, timeframe, start_time) DO NOTHING;
-	`
-	
-	_, err := s.db.NamedExec(query, c)
-	return err
+	s.writer.Add(item)
 }
\ No newline at end of file
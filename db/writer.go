@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"shared/configfile"
+)
+
+const sinkWriteTimeout = 30 * time.Second // 单次写入存储目标的超时
+
+// KlineWriterConfig K线写入配置 (环境变量 DB_WRITER, JSON; 或配置节 batching)
+type KlineWriterConfig struct {
+	BatchSize      int      `json:"batch_size"`      // 每批最多事件数, 默认 500
+	FlushInterval  Duration `json:"flush_interval"`  // 最长攒批时间, 默认 1s
+	MaxRetries     int      `json:"max_retries"`     // 一批写入失败后的重试次数, 之后转存到溢出列表, 默认 5
+	RetryDelay     Duration `json:"retry_delay"`     // 首次重试间隔, 之后翻倍, 默认 1s
+	MaxRetryDelay  Duration `json:"max_retry_delay"` // 重试间隔上限, 默认 30s
+	SpillKey       string   `json:"spill_key"`       // TimescaleDB 的溢出列表, 默认 "kline_spill"; 其它存储目标默认使用 "<spill_key>:<name>"
+	ReplayInterval Duration `json:"replay_interval"` // 空闲时检查并重放溢出列表的间隔, 默认 30s
+	Group          string   `json:"group"`           // Stream 消费组, 默认 "db_writer"; 独立部署的写入实例 (如只写 Parquet) 需使用不同的组
+}
+
+func loadKlineWriterConfig(f *configfile.File) (KlineWriterConfig, error) {
+	cfg := KlineWriterConfig{}
+	if _, err := f.LoadJSONEnv("DB_WRITER", &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.BatchSize < 0 || cfg.MaxRetries < 0 {
+		return cfg, fmt.Errorf("DB_WRITER: batch_size and max_retries must not be negative")
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = Duration(time.Second)
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = Duration(time.Second)
+	}
+	if cfg.MaxRetryDelay <= 0 {
+		cfg.MaxRetryDelay = Duration(30 * time.Second)
+	}
+	if cfg.SpillKey == "" {
+		cfg.SpillKey = "kline_spill"
+	}
+	if cfg.ReplayInterval <= 0 {
+		cfg.ReplayInterval = Duration(30 * time.Second)
+	}
+	if cfg.Group == "" {
+		cfg.Group = dbWriterGroup
+	}
+	return cfg, nil
+}
+
+// writeItem 一条待处理的事件
+type writeItem struct {
+	event   *PublishEvent // 需要写入的 CLOSE/AMEND 事件; 为空表示只需 ACK
+	payload []byte        // 原始消息, 转存时使用
+	stream  string        // 来自 Stream 时需要 ACK
+	id      string
+}
+
+// KlineWriter 批量写入K线
+//
+// 事件按条数或时间攒批, 写入所有存储目标 (CandleSink). 每个目标独立重试:
+// 失败时按指数退避重试 MaxRetries 次, 仍失败则转存到该目标的 Redis List (SpillKey);
+// 恢复后先按顺序重放溢出列表, 再写新的事件, 避免旧的K线覆盖新的修正.
+// 来自 Stream 的事件在所有目标都写入或转存成功后才 ACK, 否则不 ACK, 重启后由 Stream 重新投递
+// (已写入成功的目标会再收到一次, 各目标的写入都是幂等的).
+type KlineWriter struct {
+	rdb   *redis.Client
+	cfg   KlineWriterConfig
+	sinks []*sinkState
+	queue chan writeItem
+	done  chan struct{}
+}
+
+// sinkState 一个存储目标及其写入状态 (仅由 run goroutine 访问)
+type sinkState struct {
+	sink       CandleSink
+	timeframes map[string]bool // 只写入这些周期, 为空表示全部
+	spillKey   string
+	degraded   bool // 上一批写入失败; 恢复前不再逐批重试, 直接转存
+}
+
+func NewKlineWriter(rdb *redis.Client, cfg KlineWriterConfig, sinks []CandleSink, sinkCfgs []SinkConfig) *KlineWriter {
+	w := &KlineWriter{
+		rdb:   rdb,
+		cfg:   cfg,
+		queue: make(chan writeItem, 4*cfg.BatchSize),
+		done:  make(chan struct{}),
+	}
+	for i, sink := range sinks {
+		s := &sinkState{sink: sink, spillKey: sinkCfgs[i].SpillKey}
+		if len(sinkCfgs[i].Timeframes) > 0 {
+			s.timeframes = make(map[string]bool)
+			for _, tf := range sinkCfgs[i].Timeframes {
+				s.timeframes[tf] = true
+			}
+		}
+		w.sinks = append(w.sinks, s)
+	}
+	return w
+}
+
+// Start 启动写入 goroutine; ctx 结束后不再等待重试, 写不进去的事件直接转存
+func (w *KlineWriter) Start(ctx context.Context) {
+	go w.run(ctx)
+}
+
+// Add 入队; 队列满 (写入变慢) 时阻塞, 对读取形成背压
+func (w *KlineWriter) Add(item writeItem) {
+	w.queue <- item
+}
+
+// Close 写完队列中剩余的事件并关闭所有存储目标后返回; 调用后不能再 Add
+func (w *KlineWriter) Close() {
+	close(w.queue)
+	<-w.done
+	for _, s := range w.sinks {
+		if err := s.sink.Close(); err != nil {
+			log.Printf("ERROR: Failed to close sink %s: %v", s.sink.Name(), err)
+		}
+	}
+}
+
+func (w *KlineWriter) run(ctx context.Context) {
+	defer close(w.done)
+	flush := time.NewTicker(w.cfg.FlushInterval.Std())
+	defer flush.Stop()
+	replay := time.NewTicker(w.cfg.ReplayInterval.Std())
+	defer replay.Stop()
+
+	batch := make([]writeItem, 0, w.cfg.BatchSize)
+	for {
+		select {
+		case item, ok := <-w.queue:
+			if !ok {
+				w.flush(ctx, batch)
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= w.cfg.BatchSize {
+				w.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-flush.C:
+			if len(batch) > 0 {
+				w.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-replay.C:
+			for _, s := range w.sinks {
+				w.replay(ctx, s)
+			}
+		}
+	}
+}
+
+// flush 把一批事件写入所有存储目标, 失败的目标转存, 然后 ACK
+func (w *KlineWriter) flush(ctx context.Context, batch []writeItem) {
+	candles := latestCandles(batch)
+	acked := true
+	for _, s := range w.sinks {
+		if selected := s.filter(candles); len(selected) > 0 && !w.write(ctx, s, selected) {
+			if err := w.spill(s, batch); err != nil {
+				log.Printf("🔴 Failed to spill %d kline events for %s, leaving them unacknowledged: %v",
+					len(selected), s.sink.Name(), err)
+				acked = false
+			}
+		}
+	}
+	if acked {
+		w.ack(batch)
+	}
+}
+
+// filter 取出该目标需要的周期
+func (s *sinkState) filter(candles []Candle) []Candle {
+	if s.timeframes == nil {
+		return candles
+	}
+	selected := make([]Candle, 0, len(candles))
+	for _, c := range candles {
+		if s.timeframes[c.Timeframe] {
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
+
+// write 写入一个目标; 溢出列表不为空时先重放, 重放完之前新事件也转存以保持顺序. 返回 false 表示应转存
+func (w *KlineWriter) write(ctx context.Context, s *sinkState, candles []Candle) bool {
+	if !w.replay(ctx, s) {
+		return false
+	}
+	retries := w.cfg.MaxRetries
+	if s.degraded || ctx.Err() != nil {
+		retries = 0
+	}
+
+	name := s.sink.Name()
+	delay := w.cfg.RetryDelay.Std()
+	for attempt := 0; ; attempt++ {
+		err := writeSink(s.sink, candles)
+		if err == nil {
+			if s.degraded {
+				log.Printf("✅ Writes to %s recovered", name)
+			}
+			s.degraded = false
+			return true
+		}
+		if attempt >= retries {
+			log.Printf("ERROR: Failed to write %d candles to %s after %d attempts: %v", len(candles), name, attempt+1, err)
+			s.degraded = true
+			return false
+		}
+		log.Printf("ERROR: Failed to write %d candles to %s (attempt %d/%d), retrying in %s: %v",
+			len(candles), name, attempt+1, retries+1, delay, err)
+		select {
+		case <-ctx.Done():
+			retries = attempt // 停机中: 不再等待
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > w.cfg.MaxRetryDelay.Std() {
+			delay = w.cfg.MaxRetryDelay.Std()
+		}
+	}
+}
+
+// writeSink 带超时写入; 不使用服务的 ctx, 停机时也要写完进行中的批次
+func writeSink(sink CandleSink, candles []Candle) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sinkWriteTimeout)
+	defer cancel()
+	return sink.Write(ctx, candles)
+}
+
+// replay 按顺序重放一个目标的溢出列表, 返回列表是否已清空.
+// 读取列表失败时无法确认是否还有积压, 返回 false, 新事件转存到积压之后以保持顺序
+func (w *KlineWriter) replay(ctx context.Context, s *sinkState) bool {
+	bg := context.Background()
+	replayed := 0
+	for {
+		payloads, err := w.rdb.LRange(bg, s.spillKey, 0, int64(w.cfg.BatchSize)-1).Result()
+		if err != nil {
+			log.Printf("ERROR: Failed to read spilled kline events from %s: %v", s.spillKey, err)
+			return false
+		}
+		if len(payloads) == 0 {
+			if replayed > 0 {
+				log.Printf("♻️  Replayed %d spilled kline events to %s", replayed, s.sink.Name())
+			}
+			return true
+		}
+		if s.degraded && replayed == 0 && ctx.Err() != nil {
+			return false // 停机中且目标不可用, 留给下次启动
+		}
+
+		items := make([]writeItem, 0, len(payloads))
+		for _, payload := range payloads {
+			event, err := ParseEvent([]byte(payload))
+			if err != nil {
+				log.Printf("ERROR: Dropping unparsable spilled event: %v", err)
+				continue
+			}
+			items = append(items, writeItem{event: event})
+		}
+		if candles := s.filter(latestCandles(items)); len(candles) > 0 {
+			if err := writeSink(s.sink, candles); err != nil {
+				if !s.degraded {
+					log.Printf("ERROR: Failed to replay spilled kline events to %s: %v", s.sink.Name(), err)
+				}
+				s.degraded = true
+				return false
+			}
+		}
+		s.degraded = false
+		if err := w.rdb.LTrim(bg, s.spillKey, int64(len(payloads)), -1).Err(); err != nil {
+			log.Printf("ERROR: Failed to trim spilled kline events (they will be replayed again): %v", err)
+			return true
+		}
+		replayed += len(payloads)
+	}
+}
+
+// spill 把一批中需要写入的事件追加到目标的溢出列表
+func (w *KlineWriter) spill(s *sinkState, batch []writeItem) error {
+	payloads := make([]interface{}, 0, len(batch))
+	for _, item := range batch {
+		if item.event != nil {
+			payloads = append(payloads, item.payload)
+		}
+	}
+	n, err := w.rdb.RPush(context.Background(), s.spillKey, payloads...).Result()
+	if err != nil {
+		return err
+	}
+	log.Printf("⚠️  Spilled %d kline events to %s (%d pending replay)", len(payloads), s.spillKey, n)
+	return nil
+}
+
+// ack 按 Stream 批量 ACK
+func (w *KlineWriter) ack(batch []writeItem) {
+	ids := make(map[string][]string)
+	for _, item := range batch {
+		if item.stream != "" {
+			ids[item.stream] = append(ids[item.stream], item.id)
+		}
+	}
+	for stream, streamIDs := range ids {
+		if err := w.rdb.XAck(context.Background(), stream, w.cfg.Group, streamIDs...).Err(); err != nil {
+			log.Printf("ERROR: XACK %s (%d messages) failed: %v", stream, len(streamIDs), err)
+		}
+	}
+}
+
+// latestCandles 取出需要写入的K线; 同一根K线 (如 CLOSE 后又 AMEND) 只保留最后一个版本,
+// 否则同一条 INSERT ... ON CONFLICT 无法两次更新同一行
+func latestCandles(batch []writeItem) []Candle {
+	var candles []Candle
+	for _, item := range batch {
+		if item.event != nil {
+			candles = append(candles, item.event.Candle)
+		}
+	}
+	return dedupeCandles(candles)
+}
+
+// dedupeCandles 按 (symbol, timeframe, price_type, start_time) 去重, 保留最后一个版本, 顺序按首次出现
+func dedupeCandles(candles []Candle) []Candle {
+	type key struct {
+		symbol, timeframe, priceType string
+		start                        time.Time
+	}
+	index := make(map[key]int)
+	result := make([]Candle, 0, len(candles))
+	for _, c := range candles {
+		k := key{c.Symbol, c.Timeframe, c.PriceType, c.StartTime.UTC()}
+		if i, ok := index[k]; ok {
+			result[i] = c
+			continue
+		}
+		index[k] = len(result)
+		result = append(result, c)
+	}
+	return result
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatestCandlesKeepsLastVersion(t *testing.T) {
+	start := time.Date(2025, 11, 24, 10, 0, 0, 0, time.UTC)
+	closeEvent := &PublishEvent{Status: "CLOSE", Candle: Candle{Symbol: "XAUUSD", Timeframe: "M1", PriceType: "bid", StartTime: start, Close: 2000}}
+	amendEvent := &PublishEvent{Status: "AMEND", Candle: Candle{Symbol: "XAUUSD", Timeframe: "M1", PriceType: "bid", StartTime: start, Close: 2001}}
+	askEvent := &PublishEvent{Status: "CLOSE", Candle: Candle{Symbol: "XAUUSD", Timeframe: "M1", PriceType: "ask", StartTime: start, Close: 2002}}
+
+	candles := latestCandles([]writeItem{
+		{event: closeEvent},
+		{stream: "kline_stream:XAUUSD", id: "1-0"}, // UPDATE, 只需 ACK
+		{event: askEvent},
+		{event: amendEvent},
+	})
+	if len(candles) != 2 || candles[0].Close != 2001 || candles[1].PriceType != "ask" {
+		t.Fatalf("want the amended bid bar and the ask bar, got %+v", candles)
+	}
+}
+
+func TestLoadKlineWriterConfigDefaults(t *testing.T) {
+	t.Setenv("DB_WRITER", `{"batch_size": 100, "retry_delay": "500ms"}`)
+	cfg, err := loadKlineWriterConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BatchSize != 100 || cfg.RetryDelay.Std() != 500*time.Millisecond || cfg.MaxRetries != 5 || cfg.SpillKey != "kline_spill" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
@@ -0,0 +1,294 @@
+// Package calendar 品种的交易时段和交易日历, candle 和 db 服务使用同一份定义和配置写法
+package calendar
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// SessionConfig 品种的交易时段配置 (JSON)
+//
+//	{"timezone": "America/New_York", "day_roll": "17:00",
+//	 "trading_hours": ["00:00-24:00"], "weekend_close": "Fri 17:00", "weekend_open": "Sun 17:00"}
+type SessionConfig struct {
+	Timezone     string   `json:"timezone"`      // IANA 时区, 默认 UTC
+	DayRoll      string   `json:"day_roll"`      // 交易日开始的本地时间, 如 "17:00"
+	TradingHours []string `json:"trading_hours"` // 每日交易时段 "HH:MM-HH:MM" (本地时间, 可跨午夜), 为空表示全天
+	WeekendClose string   `json:"weekend_close"` // 周末收盘 "Fri 17:00", 为空表示不休市
+	WeekendOpen  string   `json:"weekend_open"`  // 周末开盘 "Sun 17:00"
+}
+
+// CalendarConfig 品种的交易日历 (周末规则见 SessionConfig)
+type CalendarConfig struct {
+	Holidays    []string `json:"holidays"`     // 全天休市的本地日期 "2025-12-25"
+	DailyBreaks []string `json:"daily_breaks"` // 每日休市时段 "HH:MM-HH:MM" (本地时间), 如 XAUUSD 的 "17:00-18:00"
+}
+
+// Hours 编译后的交易时段, nil 表示 UTC 自然日且全天交易
+type Hours struct {
+	loc          *time.Location
+	roll         time.Duration // 交易日开始相对本地午夜的偏移
+	hours        []minuteRange // 每日交易时段 (分钟)
+	weekendClose int           // 周内分钟 (周一 00:00 = 0), -1 表示不休市
+	weekendOpen  int
+}
+
+type minuteRange struct{ from, to int }
+
+// Compile 校验并编译配置; c 为 nil 时返回 nil
+func (c *SessionConfig) Compile() (*Hours, error) {
+	if c == nil {
+		return nil, nil
+	}
+	h := &Hours{loc: time.UTC, weekendClose: -1, weekendOpen: -1}
+
+	if c.Timezone != "" {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", c.Timezone, err)
+		}
+		h.loc = loc
+	}
+	if c.DayRoll != "" {
+		m, err := parseClock(c.DayRoll)
+		if err != nil {
+			return nil, fmt.Errorf("invalid day_roll: %w", err)
+		}
+		h.roll = time.Duration(m) * time.Minute
+	}
+	for _, r := range c.TradingHours {
+		from, to, err := parseClockRange(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trading_hours %q: %w", r, err)
+		}
+		h.hours = append(h.hours, minuteRange{from, to})
+	}
+	if c.WeekendClose != "" || c.WeekendOpen != "" {
+		var err error
+		if h.weekendClose, err = parseWeekClock(c.WeekendClose); err != nil {
+			return nil, fmt.Errorf("invalid weekend_close: %w", err)
+		}
+		if h.weekendOpen, err = parseWeekClock(c.WeekendOpen); err != nil {
+			return nil, fmt.Errorf("invalid weekend_open: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// Location 返回交易时段的时区, nil 表示 UTC
+func (h *Hours) Location() *time.Location {
+	if h == nil {
+		return time.UTC
+	}
+	return h.loc
+}
+
+// DayRoll 返回交易日开始相对本地午夜的偏移
+func (h *Hours) DayRoll() time.Duration {
+	if h == nil {
+		return 0
+	}
+	return h.roll
+}
+
+// ToWall 将绝对时间转换为交易日坐标下的"墙上时间" (以UTC标记),
+// 即本地时间减去日切偏移, 交易日从该坐标的午夜开始
+func (h *Hours) ToWall(t time.Time) time.Time {
+	if h == nil {
+		return t.UTC()
+	}
+	local := t.In(h.loc)
+	wall := time.Date(local.Year(), local.Month(), local.Day(),
+		local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+	return wall.Add(-h.roll)
+}
+
+// FromWall ToWall 的逆变换; 夏令时切换造成的不存在/重复时刻由 time.Date 规则处理
+func (h *Hours) FromWall(wall time.Time) time.Time {
+	if h == nil {
+		return wall.UTC()
+	}
+	w := wall.Add(h.roll)
+	return time.Date(w.Year(), w.Month(), w.Day(),
+		w.Hour(), w.Minute(), w.Second(), w.Nanosecond(), h.loc).UTC()
+}
+
+// WindowStart 返回 t 所在的固定长度周期 (不超过一天) K线的开始时间 (UTC), 与 candle 服务的分桶一致
+func (h *Hours) WindowStart(size time.Duration, t time.Time) time.Time {
+	return h.FromWall(h.ToWall(t).Truncate(size))
+}
+
+// IsOpen 判断 t 时刻是否处于交易时段 (不含节假日和每日休市, 见 Calendar)
+func (h *Hours) IsOpen(t time.Time) bool {
+	if h == nil {
+		return true
+	}
+	local := t.In(h.loc)
+	minuteOfDay := local.Hour()*60 + local.Minute()
+	minuteOfWeek := ((int(local.Weekday())+6)%7)*minutesPerDay + minuteOfDay
+
+	if h.weekendClose >= 0 && inRange(minuteOfWeek, h.weekendClose, h.weekendOpen) {
+		return false
+	}
+	if len(h.hours) == 0 {
+		return true
+	}
+	for _, r := range h.hours {
+		if inRange(minuteOfDay, r.from, r.to) {
+			return true
+		}
+	}
+	return false
+}
+
+// Calendar 判断某一时刻是否可交易: 交易时段/周末 + 节假日 + 每日休市
+type Calendar struct {
+	hours    *Hours
+	holidays map[string]bool
+	breaks   []minuteRange
+}
+
+// New 组合交易时段与日历配置; 两者都为空时返回 nil (全天候交易)
+func New(hours *Hours, cfg *CalendarConfig) (*Calendar, error) {
+	if hours == nil && cfg == nil {
+		return nil, nil
+	}
+	c := &Calendar{hours: hours, holidays: make(map[string]bool)}
+	if cfg == nil {
+		return c, nil
+	}
+	for _, day := range cfg.Holidays {
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return nil, fmt.Errorf("invalid holiday %q, want YYYY-MM-DD", day)
+		}
+		c.holidays[day] = true
+	}
+	for _, r := range cfg.DailyBreaks {
+		from, to, err := parseClockRange(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid daily_breaks %q: %w", r, err)
+		}
+		c.breaks = append(c.breaks, minuteRange{from, to})
+	}
+	return c, nil
+}
+
+// Compile 编译时段和日历配置, 两者都为空时返回 nil (全天候交易)
+func Compile(session *SessionConfig, cfg *CalendarConfig) (*Calendar, error) {
+	hours, err := session.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	c, err := New(hours, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("calendar: %w", err)
+	}
+	return c, nil
+}
+
+// Hours 返回日历使用的交易时段, nil 表示 UTC 自然日且全天交易
+func (c *Calendar) Hours() *Hours {
+	if c == nil {
+		return nil
+	}
+	return c.hours
+}
+
+// IsOpen 判断 t 时刻是否可交易
+func (c *Calendar) IsOpen(t time.Time) bool {
+	if c == nil {
+		return true
+	}
+	if !c.hours.IsOpen(t) {
+		return false
+	}
+	local := t.In(c.hours.Location())
+	if c.holidays[local.Format("2006-01-02")] {
+		return false
+	}
+	minuteOfDay := local.Hour()*60 + local.Minute()
+	for _, b := range c.breaks {
+		if inRange(minuteOfDay, b.from, b.to) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsOpenDuring 判断 [from, to) 内是否有任意时刻可交易 (按分钟检查)
+func (c *Calendar) IsOpenDuring(from, to time.Time) bool {
+	if c == nil {
+		return true
+	}
+	for t := from; t.Before(to); t = t.Add(time.Minute) {
+		if c.IsOpen(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenMinutes 返回 [from, to) 内可交易的分钟数
+func (c *Calendar) OpenMinutes(from, to time.Time) int {
+	n := 0
+	for t := from; t.Before(to); t = t.Add(time.Minute) {
+		if c.IsOpen(t) {
+			n++
+		}
+	}
+	return n
+}
+
+// parseClockRange "HH:MM-HH:MM" -> 当日分钟数区间
+func parseClockRange(s string) (from, to int, err error) {
+	f, t, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("want HH:MM-HH:MM")
+	}
+	if from, err = parseClock(f); err != nil {
+		return 0, 0, err
+	}
+	if to, err = parseClock(t); err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
+
+// parseClock "HH:MM" -> 当日分钟数, 允许 "24:00"
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d", &h, &m); err != nil {
+		return 0, fmt.Errorf("invalid clock %q", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h*60+m > minutesPerDay {
+		return 0, fmt.Errorf("invalid clock %q", s)
+	}
+	return h*60 + m, nil
+}
+
+var weekdayNames = map[string]int{"Mon": 0, "Tue": 1, "Wed": 2, "Thu": 3, "Fri": 4, "Sat": 5, "Sun": 6}
+
+// parseWeekClock "Fri 17:00" -> 周内分钟数
+func parseWeekClock(s string) (int, error) {
+	day, clock, ok := strings.Cut(strings.TrimSpace(s), " ")
+	d, known := weekdayNames[day]
+	if !ok || !known {
+		return 0, fmt.Errorf("invalid weekday clock %q, want e.g. \"Fri 17:00\"", s)
+	}
+	m, err := parseClock(clock)
+	if err != nil {
+		return 0, err
+	}
+	return d*minutesPerDay + m, nil
+}
+
+// inRange 判断 m 是否在 [from, to) 内, to <= from 时视为跨越周期边界
+func inRange(m, from, to int) bool {
+	if from < to {
+		return m >= from && m < to
+	}
+	return m >= from || m < to
+}
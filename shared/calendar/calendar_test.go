@@ -0,0 +1,83 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoursAcrossMidnight(t *testing.T) {
+	hours, err := (&SessionConfig{Timezone: "Asia/Shanghai", TradingHours: []string{"21:00-02:30", "09:00-15:00"}}).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cst := time.FixedZone("CST", 8*3600)
+	cases := map[time.Time]bool{
+		time.Date(2025, 1, 6, 23, 0, 0, 0, cst): true,
+		time.Date(2025, 1, 7, 2, 29, 0, 0, cst): true,
+		time.Date(2025, 1, 7, 2, 30, 0, 0, cst): false,
+		time.Date(2025, 1, 7, 10, 0, 0, 0, cst): true,
+		time.Date(2025, 1, 7, 17, 0, 0, 0, cst): false,
+	}
+	for ts, want := range cases {
+		if got := hours.IsOpen(ts); got != want {
+			t.Errorf("IsOpen(%s) = %v, want %v", ts.Format(time.RFC3339), got, want)
+		}
+	}
+}
+
+func TestCalendarHolidaysAndBreaks(t *testing.T) {
+	cal, err := Compile(
+		&SessionConfig{Timezone: "America/New_York", WeekendClose: "Fri 17:00", WeekendOpen: "Sun 18:00"},
+		&CalendarConfig{Holidays: []string{"2025-12-25"}, DailyBreaks: []string{"17:00-18:00"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ny, _ := time.LoadLocation("America/New_York")
+	cases := map[time.Time]bool{
+		time.Date(2025, 12, 23, 12, 0, 0, 0, ny):  true,
+		time.Date(2025, 12, 23, 17, 30, 0, 0, ny): false, // 每日休市
+		time.Date(2025, 12, 25, 12, 0, 0, 0, ny):  false, // 节假日
+		time.Date(2025, 12, 27, 12, 0, 0, 0, ny):  false, // 周末
+	}
+	for ts, want := range cases {
+		if got := cal.IsOpen(ts); got != want {
+			t.Errorf("IsOpen(%s) = %v, want %v", ts.Format(time.RFC3339), got, want)
+		}
+	}
+	hour := time.Date(2025, 12, 23, 17, 0, 0, 0, ny)
+	if n := cal.OpenMinutes(hour, hour.Add(2*time.Hour)); n != 60 {
+		t.Errorf("want 60 open minutes around the daily break, got %d", n)
+	}
+	if cal.IsOpenDuring(hour, hour.Add(time.Hour)) {
+		t.Error("want the daily break closed")
+	}
+}
+
+func TestNilCalendarAlwaysOpen(t *testing.T) {
+	cal, err := Compile(nil, nil)
+	if err != nil || cal != nil {
+		t.Fatalf("want nil calendar, got %v, %v", cal, err)
+	}
+	from := time.Date(2025, 1, 4, 0, 0, 0, 0, time.UTC)
+	if !cal.IsOpen(from) || cal.OpenMinutes(from, from.Add(time.Hour)) != 60 {
+		t.Fatal("want a nil calendar open around the clock")
+	}
+}
+
+func TestCompileRejectsBadConfig(t *testing.T) {
+	for name, c := range map[string]struct {
+		session  *SessionConfig
+		calendar *CalendarConfig
+	}{
+		"bad timezone":    {session: &SessionConfig{Timezone: "Mars/Olympus"}},
+		"bad weekend":     {session: &SessionConfig{WeekendClose: "Friday 17:00", WeekendOpen: "Sun 17:00"}},
+		"bad hours":       {session: &SessionConfig{TradingHours: []string{"09:00"}}},
+		"bad holiday":     {calendar: &CalendarConfig{Holidays: []string{"25/12/2025"}}},
+		"bad daily break": {calendar: &CalendarConfig{DailyBreaks: []string{"17:00-25:00"}}},
+	} {
+		if _, err := Compile(c.session, c.calendar); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
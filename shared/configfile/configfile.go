@@ -0,0 +1,216 @@
+// Package configfile candle 和 db 服务共用的配置文件加载: 文件格式、${NAME} 引用、环境变量覆盖,
+// 以及两者都有的 redis/postgres/log 配置节和 -print-config 输出
+//
+// 配置文件 (-config 参数或环境变量 CONFIG_FILE): YAML (.yaml/.yml), TOML (.toml) 或 JSON (.json)
+//
+// 顶层的每一节对应一个环境变量 (由服务传入的节名表决定), 例如
+//
+//	redis:
+//	  addr: redis:6379
+//	  password: "${REDIS_PASSWORD}"
+//
+// 环境变量优先于配置文件: JSON 类变量 (TICK_SOURCES, CANDLE_SINKS 等) 设置后整节替换文件中的配置,
+// redis/postgres/log 等节的单个字段可以用 REDIS_ADDR, PG_PASSWORD 等变量覆盖.
+// 文件中的 ${NAME} 在解析前替换为环境变量的值, 密码不必写在文件里.
+package configfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var envRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// File 已加载的配置文件, 按节名保存每一节的 JSON; nil 表示没有配置文件, 只用环境变量
+type File struct {
+	sections map[string]string // 节名 -> 对应的环境变量; 为空表示只能用字段级的变量覆盖 (见 ApplyEnvOverrides)
+	values   map[string]json.RawMessage
+}
+
+// Load 解析配置文件; path 为空时返回不含任何节的 File. 未知的节名报错
+func Load(path string, sections map[string]string) (*File, error) {
+	f := &File{sections: sections, values: make(map[string]json.RawMessage)}
+	if path == "" {
+		return f, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = expandEnvRefs(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var parsed map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &parsed)
+	case ".toml":
+		err = toml.Unmarshal(data, &parsed)
+	case ".json":
+		err = json.Unmarshal(data, &parsed)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config format %q, want .yaml, .toml or .json", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name, value := range parsed {
+		if _, ok := sections[name]; !ok {
+			return nil, fmt.Errorf("%s: unknown section %q (known: %s)", path, name, strings.Join(f.sectionNames(), ", "))
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: section %s: %w", path, name, err)
+		}
+		f.values[name] = raw
+	}
+	return f, nil
+}
+
+// expandEnvRefs 将 ${NAME} 替换为环境变量的值; 注释行 (# 开头) 不替换, 引用未设置的变量时报错
+func expandEnvRefs(data []byte) ([]byte, error) {
+	var missing []string
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("#")) {
+			continue
+		}
+		lines[i] = envRefPattern.ReplaceAllFunc(line, func(ref []byte) []byte {
+			name := string(envRefPattern.FindSubmatch(ref)[1])
+			value, ok := os.LookupEnv(name)
+			if !ok && !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+			return []byte(value)
+		})
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return bytes.Join(lines, nil), nil
+}
+
+func (f *File) sectionNames() []string {
+	names := make([]string, 0, len(f.sections))
+	for name := range f.sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sectionForEnv 返回环境变量对应的配置文件节名
+func (f *File) sectionForEnv(key string) string {
+	if f == nil {
+		return ""
+	}
+	for name, env := range f.sections {
+		if env == key {
+			return name
+		}
+	}
+	return ""
+}
+
+// DecodeSection 将配置文件中的一节解析到 v; 文件中拼错的字段名直接报错. 没有该节时返回 false
+func (f *File) DecodeSection(name string, v interface{}) (bool, error) {
+	if f == nil || name == "" {
+		return false, nil
+	}
+	raw, ok := f.values[name]
+	if !ok {
+		return false, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return true, fmt.Errorf("invalid config section %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// LoadSection 读取配置文件中的一节, 再用字段 env 标签指定的环境变量覆盖单个字段
+func (f *File) LoadSection(name string, v interface{}) error {
+	if _, err := f.DecodeSection(name, v); err != nil {
+		return err
+	}
+	return ApplyEnvOverrides(v)
+}
+
+// LoadJSONEnv 将环境变量中的JSON解析到 v; 变量未设置时使用配置文件中对应的节, 都没有时返回 false
+func (f *File) LoadJSONEnv(key string, v interface{}) (bool, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return f.DecodeSection(f.sectionForEnv(key), v)
+	}
+	if err := json.Unmarshal([]byte(raw), v); err != nil {
+		return true, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Lookup 读取字符串配置: 环境变量优先, 其次配置文件中对应的节
+func (f *File) Lookup(key string) (string, bool) {
+	if raw := os.Getenv(key); raw != "" {
+		return raw, true
+	}
+	if f == nil {
+		return "", false
+	}
+	raw, ok := f.values[f.sectionForEnv(key)]
+	if !ok {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return string(raw), true // 数字等非字符串值原样返回, 由调用方校验
+	}
+	return s, true
+}
+
+// ApplyEnvOverrides 按 env 标签用环境变量覆盖结构体字段 (只支持 string/int/bool)
+func ApplyEnvOverrides(v interface{}) error {
+	rv := reflect.ValueOf(v).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		key := rv.Type().Field(i).Tag.Get("env")
+		raw := os.Getenv(key)
+		if key == "" || raw == "" {
+			continue
+		}
+		field := rv.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(raw)
+		case reflect.Int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %q is not an integer", key, raw)
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %q is not a boolean", key, raw)
+			}
+			field.SetBool(b)
+		default:
+			return fmt.Errorf("%s: unsupported field type %s", key, field.Type())
+		}
+	}
+	return nil
+}
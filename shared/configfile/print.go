@@ -0,0 +1,81 @@
+package configfile
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const Redacted = "xxxxx" // 隐藏的密码, 与 url.URL.Redacted 一致
+
+var secretKeyPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key)$`)
+
+// Print 以 YAML 输出生效的配置 (可直接作为配置文件), 密码、token 和 URL 中的密码替换为 xxxxx
+func Print(w io.Writer, cfg interface{}) error {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node // JSON 也是 YAML, 解析为节点以保留字段顺序
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	redactSecrets(&doc)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// redactSecrets 隐藏密码类字段和 URL 中的密码, 并去掉 JSON 的 flow 风格
+func redactSecrets(n *yaml.Node) {
+	n.Style = 0
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if n.Tag == "!!str" {
+			n.Value = redactURL(n.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if value.Kind == yaml.ScalarNode && value.Value != "" && secretKeyPattern.MatchString(key.Value) {
+				value.Value = Redacted
+			}
+		}
+	}
+	for _, child := range n.Content {
+		redactSecrets(child)
+	}
+}
+
+// redactURL 隐藏 URL 中的密码和 token 类查询参数; 不是 URL 的字符串原样返回
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	query := u.Query()
+	changed := false
+	for key := range query {
+		if secretKeyPattern.MatchString(key) {
+			query.Set(key, Redacted)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+	if _, ok := u.User.Password(); !ok && !changed {
+		return s
+	}
+	return u.Redacted()
+}
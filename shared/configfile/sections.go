@@ -0,0 +1,155 @@
+package configfile
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const DefaultRedisAddr = "localhost:6379"
+
+// RedisConfig Redis 连接 (配置节 redis)
+type RedisConfig struct {
+	Addr     string `json:"addr" env:"REDIS_ADDR"` // 默认 localhost:6379
+	Username string `json:"username" env:"REDIS_USERNAME"`
+	Password string `json:"password" env:"REDIS_PASSWORD"`
+	DB       int    `json:"db" env:"REDIS_DB"`
+}
+
+// LoadRedis 读取 redis 节
+func (f *File) LoadRedis() (RedisConfig, error) {
+	cfg := RedisConfig{}
+	if err := f.LoadSection("redis", &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultRedisAddr
+	}
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		return cfg, fmt.Errorf("redis: invalid addr %q: %w", cfg.Addr, err)
+	}
+	if cfg.DB < 0 {
+		return cfg, fmt.Errorf("redis: db must not be negative")
+	}
+	return cfg, nil
+}
+
+func (c RedisConfig) Options() *redis.Options {
+	return &redis.Options{Addr: c.Addr, Username: c.Username, Password: c.Password, DB: c.DB}
+}
+
+// PostgresConfig TimescaleDB/PostgreSQL 连接 (配置节 postgres)
+//
+// 设置 url 时直接使用, 否则由其余字段组成. 密码为空时 pgx 按 libpq 的约定读取 PGPASSWORD 或 ~/.pgpass
+type PostgresConfig struct {
+	URL      string `json:"url" env:"DATABASE_URL"`
+	Host     string `json:"host" env:"PG_HOST"`
+	Port     int    `json:"port" env:"PG_PORT"` // 默认 5432
+	User     string `json:"user" env:"PG_USER"`
+	Password string `json:"password" env:"PG_PASSWORD"`
+	DBName   string `json:"dbname" env:"PG_DBNAME"`
+	SSLMode  string `json:"sslmode" env:"PG_SSLMODE"` // 默认 disable
+}
+
+// LoadPostgres 读取 postgres 节
+func (f *File) LoadPostgres() (PostgresConfig, error) {
+	cfg := PostgresConfig{}
+	if err := f.LoadSection("postgres", &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.URL != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			return cfg, fmt.Errorf("postgres: url must be a postgres:// URL")
+		}
+		return cfg, nil
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 5432
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return cfg, fmt.Errorf("postgres: invalid port %d", cfg.Port)
+	}
+	if cfg.SSLMode == "" {
+		cfg.SSLMode = "disable"
+	}
+	return cfg, nil
+}
+
+// Configured 是否配置了数据库 (url 或 host)
+func (c PostgresConfig) Configured() bool {
+	return c.URL != "" || c.Host != ""
+}
+
+// DSN pgx 连接串
+func (c PostgresConfig) DSN() string {
+	if c.URL != "" {
+		return c.URL
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.DBName,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	if c.Password != "" {
+		u.User = url.UserPassword(c.User, c.Password)
+	} else if c.User != "" {
+		u.User = url.User(c.User)
+	}
+	return u.String()
+}
+
+// LogConfig 日志输出 (配置节 log)
+type LogConfig struct {
+	Output       string `json:"output" env:"LOG_OUTPUT"` // stderr (默认) | stdout | 文件路径 (追加写入)
+	Prefix       string `json:"prefix" env:"LOG_PREFIX"` // 每行的前缀, 如实例名
+	UTC          bool   `json:"utc" env:"LOG_UTC"`       // 时间使用 UTC
+	Microseconds bool   `json:"microseconds" env:"LOG_MICROSECONDS"`
+}
+
+// LoadLog 读取 log 节
+func (f *File) LoadLog() (LogConfig, error) {
+	cfg := LogConfig{}
+	if err := f.LoadSection("log", &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.Output == "" {
+		cfg.Output = "stderr"
+	}
+	return cfg, nil
+}
+
+// SetupLogging 按配置设置标准库 log; 日志文件在进程退出前一直打开
+func SetupLogging(c LogConfig) error {
+	var out io.Writer
+	switch c.Output {
+	case "", "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	default:
+		f, err := os.OpenFile(c.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("log: %w", err)
+		}
+		out = f
+	}
+	flags := log.LstdFlags
+	if c.UTC {
+		flags |= log.LUTC
+	}
+	if c.Microseconds {
+		flags |= log.Lmicroseconds
+	}
+	log.SetOutput(out)
+	log.SetFlags(flags)
+	log.SetPrefix(c.Prefix)
+	return nil
+}